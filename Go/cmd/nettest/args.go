@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	nettest "nettest/pkg/dns"
+
+	"github.com/miekg/dns"
+)
+
+// args is a parsed command line. Flags may come before or after the name.
+type args struct {
+	servers []string
+	opts    nettest.DnsRequestOptions // template; Server is filled per query
+	short   bool
+	json    bool
+	tcp     bool
+
+	count       int
+	concurrency int
+}
+
+// parseArgs reads dig-style arguments: @server, +option, -flag or
+// --flag value / --flag=value, then name, type and class in any order.
+func parseArgs(list []string) (*args, error) {
+	a := &args{count: 100, concurrency: 10}
+	for i := 0; i < len(list); i++ {
+		arg := list[i]
+		// value returns the flag's argument, inline after "=" or the next word.
+		value := func() (string, error) {
+			if j := strings.IndexByte(arg, '='); j >= 0 && strings.HasPrefix(arg, "--") {
+				return arg[j+1:], nil
+			}
+			if i+1 >= len(list) {
+				return "", fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			return list[i], nil
+		}
+		name := arg
+		if j := strings.IndexByte(arg, '='); j >= 0 && strings.HasPrefix(arg, "--") {
+			name = arg[:j]
+		}
+		var (
+			v   string
+			err error
+		)
+		switch name {
+		case "+short":
+			a.short = true
+		case "+json":
+			a.json = true
+		case "+tcp", "+vc":
+			a.tcp = true
+		case "+cache":
+			a.opts.Cache = true
+		case "-4":
+			a.opts.IPVersion = 4
+		case "-6":
+			a.opts.IPVersion = 6
+		case "--proxy-insecure":
+			a.opts.ProxyInsecure = true
+		case "-x":
+			if v, err = value(); err == nil {
+				a.opts.Qname, err = dns.ReverseAddr(v)
+				a.opts.Qtype = "PTR"
+			}
+		case "--socks5":
+			a.opts.Socks5, err = value()
+		case "--proxy":
+			a.opts.Proxy, err = value()
+		case "--proxy-chain":
+			if v, err = value(); err == nil {
+				a.opts.ProxyChain = append(a.opts.ProxyChain, v)
+			}
+		case "--sni":
+			a.opts.SNI, err = value()
+		case "--subnet":
+			a.opts.ClientSubnet, err = value()
+		case "--bootstrap":
+			a.opts.Bootstrap, err = value()
+		case "--resolve":
+			if v, err = value(); err == nil {
+				a.opts.Resolve = append(a.opts.Resolve, v)
+			}
+		case "--source-ip":
+			a.opts.SourceIP, err = value()
+		case "--interface":
+			a.opts.Interface, err = value()
+		case "--tsig":
+			if v, err = value(); err == nil {
+				a.opts.Tsig, err = parseTsig(v)
+			}
+		case "--timeout":
+			if v, err = value(); err == nil {
+				var d time.Duration
+				if d, err = time.ParseDuration(v); err == nil {
+					a.opts.TimeoutMs = int(d.Milliseconds())
+				}
+			}
+		case "-n":
+			if v, err = value(); err == nil {
+				a.count, err = positive(arg, v)
+			}
+		case "-c":
+			if v, err = value(); err == nil {
+				a.concurrency, err = positive(arg, v)
+			}
+		default:
+			err = a.positional(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if a.opts.Qname == "" {
+		// Like dig, no name asks for the root name servers.
+		a.opts.Qname = "."
+		if a.opts.Qtype == "" {
+			a.opts.Qtype = "NS"
+		}
+	}
+	if a.opts.Qtype == "" {
+		a.opts.Qtype = "A"
+	}
+	if a.opts.Qclass == "" {
+		a.opts.Qclass = "IN"
+	}
+	if len(a.servers) == 0 {
+		s, err := systemServer()
+		if err != nil {
+			return nil, err
+		}
+		a.servers = []string{s}
+	}
+	for i, s := range a.servers {
+		a.servers[i] = a.serverURL(s)
+	}
+	return a, nil
+}
+
+// positional takes @server, a type, a class or the name.
+func (a *args) positional(arg string) error {
+	switch {
+	case strings.HasPrefix(arg, "@"):
+		a.servers = append(a.servers, arg[1:])
+	case strings.HasPrefix(arg, "+"), strings.HasPrefix(arg, "-") && len(arg) > 1:
+		return fmt.Errorf("unknown option %s", arg)
+	case isType(arg) && a.opts.Qtype == "":
+		a.opts.Qtype = strings.ToUpper(arg)
+	case isClass(arg) && a.opts.Qclass == "":
+		a.opts.Qclass = strings.ToUpper(arg)
+	case a.opts.Qname == "":
+		a.opts.Qname = arg
+	default:
+		return fmt.Errorf("unexpected argument %q", arg)
+	}
+	return nil
+}
+
+func isType(s string) bool {
+	_, ok := dns.StringToType[strings.ToUpper(s)]
+	return ok
+}
+
+func isClass(s string) bool {
+	_, ok := dns.StringToClass[strings.ToUpper(s)]
+	return ok
+}
+
+// serverURL adds the udp:// scheme to a bare address, and switches udp to
+// tcp for +tcp.
+func (a *args) serverURL(s string) string {
+	if !strings.Contains(s, "://") {
+		if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		s = "udp://" + s
+	}
+	if a.tcp && strings.HasPrefix(s, "udp://") {
+		s = "tcp://" + strings.TrimPrefix(s, "udp://")
+	}
+	return s
+}
+
+// systemServer is the first nameserver in /etc/resolv.conf.
+func systemServer() (string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no @server given and %w", err)
+	}
+	if len(conf.Servers) == 0 {
+		return "", errors.New("no @server given and /etc/resolv.conf has no nameserver")
+	}
+	return "udp://" + net.JoinHostPort(conf.Servers[0], conf.Port), nil
+}
+
+// parseTsig reads name:secret[:algorithm].
+func parseTsig(s string) (*nettest.TsigOptions, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("--tsig wants name:secret[:algorithm], got %q", s)
+	}
+	t := &nettest.TsigOptions{Name: parts[0], Secret: parts[1]}
+	if len(parts) == 3 {
+		t.Algorithm = parts[2]
+	}
+	return t, nil
+}
+
+func positive(flag, s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s wants a positive number, got %q", flag, s)
+	}
+	return n, nil
+}
+
+// request is the query options for one server.
+func (a *args) request(server string) nettest.DnsRequestOptions {
+	opts := a.opts
+	opts.Server = server
+	return opts
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		servers string
+		qname   string
+		qtype   string
+		qclass  string
+		check   func(a *args) bool
+	}{
+		{"@1.1.1.1", "udp://1.1.1.1", ".", "NS", "IN", nil},
+		{"@1.1.1.1 mx", "udp://1.1.1.1", ".", "MX", "IN", nil},
+		{"example.com @1.1.1.1", "udp://1.1.1.1", "example.com", "A", "IN", nil},
+		{"@1.1.1.1 aaaa example.com ch", "udp://1.1.1.1", "example.com", "AAAA", "CH", nil},
+		{"@2001:db8::1 @tls://9.9.9.9 example.com", "udp://[2001:db8::1]:53 tls://9.9.9.9", "example.com", "A", "IN", nil},
+		{"+tcp @1.1.1.1 @udp://8.8.8.8:5353 @tls://9.9.9.9 a.example", "tcp://1.1.1.1 tcp://8.8.8.8:5353 tls://9.9.9.9", "a.example", "A", "IN", nil},
+		{"-x 192.0.2.1 @1.1.1.1", "udp://1.1.1.1", "1.2.0.192.in-addr.arpa.", "PTR", "IN", nil},
+		{"@1.1.1.1 a.example +short +json +cache -6", "udp://1.1.1.1", "a.example", "A", "IN",
+			func(a *args) bool { return a.short && a.json && a.opts.Cache && a.opts.IPVersion == 6 }},
+		{"@1.1.1.1 a.example --timeout 1500ms --subnet=192.0.2.0/24 --proxy-chain socks5://p1:1080 --proxy-chain=http://p2:8080", "udp://1.1.1.1", "a.example", "A", "IN",
+			func(a *args) bool {
+				return a.opts.TimeoutMs == 1500 && a.opts.ClientSubnet == "192.0.2.0/24" &&
+					strings.Join(a.opts.ProxyChain, " ") == "socks5://p1:1080 http://p2:8080"
+			}},
+		{"@1.1.1.1 a.example --tsig key.:c2VjcmV0:hmac-sha512. -n 5 -c 2", "udp://1.1.1.1", "a.example", "A", "IN",
+			func(a *args) bool {
+				return a.opts.Tsig.Name == "key." && a.opts.Tsig.Secret == "c2VjcmV0" && a.opts.Tsig.Algorithm == "hmac-sha512." &&
+					a.count == 5 && a.concurrency == 2
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			a, err := parseArgs(strings.Fields(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(a.servers, " "); got != tt.servers {
+				t.Errorf("servers %s, want %s", got, tt.servers)
+			}
+			if a.opts.Qname != tt.qname || a.opts.Qtype != tt.qtype || a.opts.Qclass != tt.qclass {
+				t.Errorf("question %s %s %s, want %s %s %s", a.opts.Qname, a.opts.Qclass, a.opts.Qtype, tt.qname, tt.qclass, tt.qtype)
+			}
+			if tt.check != nil && !tt.check(a) {
+				t.Errorf("options %+v", a.opts)
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	for args, want := range map[string]string{
+		"@1.1.1.1 a.example b.example": `unexpected argument "b.example"`,
+		"@1.1.1.1 +trace":              "unknown option +trace",
+		"@1.1.1.1 --bogus":             "unknown option --bogus",
+		"@1.1.1.1 --sni":               "--sni needs a value",
+		"@1.1.1.1 -n 0":                "-n wants a positive number",
+		"@1.1.1.1 -c many":             "-c wants a positive number",
+		"@1.1.1.1 --timeout soon":      "invalid duration",
+		"@1.1.1.1 --tsig key.":         "--tsig wants name:secret",
+		"@1.1.1.1 -x nope":             "unrecognized address",
+	} {
+		if _, err := parseArgs(strings.Fields(args)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", args, err, want)
+		}
+	}
+}
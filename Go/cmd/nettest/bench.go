@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nettest "nettest/pkg/dns"
+
+	"github.com/miekg/dns"
+)
+
+// benchStats summarizes one server's run. Latencies are wall-clock per query,
+// so they include connection setup, handshakes and proxies.
+type benchStats struct {
+	Server      string         `json:"server"`
+	Sent        int            `json:"sent"`
+	OK          int            `json:"ok"`
+	Failed      int            `json:"failed"`
+	Errors      map[string]int `json:"errors,omitempty"` // by class
+	Rcodes      map[string]int `json:"rcodes"`
+	Concurrency int            `json:"concurrency"`
+	Elapsed     time.Duration  `json:"elapsed"`
+	QPS         float64        `json:"qps"`
+	Min         time.Duration  `json:"min"`
+	Avg         time.Duration  `json:"avg"`
+	P50         time.Duration  `json:"p50"`
+	P95         time.Duration  `json:"p95"`
+	P99         time.Duration  `json:"p99"`
+	Max         time.Duration  `json:"max"`
+}
+
+// runBench sends -n queries to each server, -c at a time, one server after another.
+func runBench(list []string) error {
+	a, err := parseArgs(list)
+	if err != nil {
+		return err
+	}
+	all := make([]*benchStats, 0, len(a.servers))
+	for _, server := range a.servers {
+		if !a.json {
+			fmt.Fprintf(os.Stderr, "benchmarking %s: %d x %s %s, concurrency %d\n",
+				server, a.count, a.opts.Qname, a.opts.Qtype, a.concurrency)
+		}
+		st := bench(a, server)
+		all = append(all, st)
+		if !a.json {
+			printBench(st)
+		}
+	}
+	if a.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"results": all})
+	}
+	return nil
+}
+
+func bench(a *args, server string) *benchStats {
+	st := &benchStats{
+		Server:      server,
+		Sent:        a.count,
+		Errors:      make(map[string]int),
+		Rcodes:      make(map[string]int),
+		Concurrency: a.concurrency,
+	}
+	var (
+		mu   sync.Mutex
+		rtts []time.Duration
+		wg   sync.WaitGroup
+	)
+	jobs := make(chan struct{})
+	start := time.Now()
+	for i := 0; i < a.concurrency && i < a.count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				t := time.Now()
+				res, err := nettest.Query(a.request(server))
+				d := time.Since(t)
+				mu.Lock()
+				if err != nil {
+					st.Failed++
+					st.Errors[nettest.ErrorClass(err)]++
+				} else {
+					st.OK++
+					st.Rcodes[dns.RcodeToString[res.Msg.Rcode]]++
+					rtts = append(rtts, d)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < a.count; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	st.Elapsed = time.Since(start)
+	st.QPS = float64(st.Sent) / st.Elapsed.Seconds()
+	st.latency(rtts)
+	return st
+}
+
+// latency fills in the latency summary of the answered queries' rtts.
+func (st *benchStats) latency(rtts []time.Duration) {
+	if len(rtts) == 0 {
+		return
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var sum time.Duration
+	for _, d := range rtts {
+		sum += d
+	}
+	st.Min, st.Max = rtts[0], rtts[len(rtts)-1]
+	st.Avg = sum / time.Duration(len(rtts))
+	st.P50 = percentile(rtts, 50)
+	st.P95 = percentile(rtts, 95)
+	st.P99 = percentile(rtts, 99)
+}
+
+// percentile is the smallest of the sorted rtts that at least p percent of
+// them do not exceed (nearest rank).
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func printBench(st *benchStats) {
+	fmt.Printf("%s\n", st.Server)
+	fmt.Printf("  queries:  %d sent, %d ok, %d failed%s\n", st.Sent, st.OK, st.Failed, counts(st.Errors, " (", ")"))
+	if len(st.Rcodes) > 0 {
+		fmt.Printf("  rcodes:  %s\n", counts(st.Rcodes, " ", ""))
+	}
+	if st.OK > 0 {
+		fmt.Printf("  latency:  min %v  avg %v  p50 %v  p95 %v  p99 %v  max %v\n",
+			ms(st.Min), ms(st.Avg), ms(st.P50), ms(st.P95), ms(st.P99), ms(st.Max))
+	}
+	fmt.Printf("  rate:     %.1f qps over %v\n\n", st.QPS, ms(st.Elapsed))
+}
+
+// counts renders m as "a 3, b 1", largest first, between prefix and suffix.
+func counts(m map[string]int, prefix, suffix string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, m[k])
+	}
+	return prefix + strings.Join(parts, ", ") + suffix
+}
+
+// ms rounds d for display.
+func ms(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
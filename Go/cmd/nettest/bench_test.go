@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBenchLatency(t *testing.T) {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	shuffled := func(n int) []time.Duration {
+		out := make([]time.Duration, n)
+		for i := range out {
+			out[i] = ms(i + 1)
+		}
+		rand.Shuffle(n, func(i, j int) { out[i], out[j] = out[j], out[i] })
+		return out
+	}
+	tests := []struct {
+		name                         string
+		rtts                         []time.Duration
+		min, avg, p50, p95, p99, max time.Duration
+	}{
+		{"none", nil, 0, 0, 0, 0, 0, 0},
+		{"one", []time.Duration{ms(7)}, ms(7), ms(7), ms(7), ms(7), ms(7), ms(7)},
+		{"hundred", shuffled(100), ms(1), 50500 * time.Microsecond, ms(50), ms(95), ms(99), ms(100)},
+		{"ten", shuffled(10), ms(1), 5500 * time.Microsecond, ms(5), ms(10), ms(10), ms(10)},
+		{"thousand", shuffled(1000), ms(1), 500500 * time.Microsecond, ms(500), ms(950), ms(990), ms(1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &benchStats{}
+			st.latency(tt.rtts)
+			got := []time.Duration{st.Min, st.Avg, st.P50, st.P95, st.P99, st.Max}
+			want := []time.Duration{tt.min, tt.avg, tt.p50, tt.p95, tt.p99, tt.max}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("min avg p50 p95 p99 max = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	nettest "nettest/pkg/dns"
+	"nettest/pkg/dns/profile"
+
+	"github.com/miekg/dns"
+)
+
+// compareResult is one server's answer. Answers are the rdata sorted, so two
+// servers agree when their lists are equal whatever the order and TTLs.
+type compareResult struct {
+	Server  string        `json:"server"`
+	RTT     time.Duration `json:"rtt"`
+	Rcode   string        `json:"rcode,omitempty"`
+	Answers []string      `json:"answers"`
+	Error   string        `json:"error,omitempty"`
+	Agrees  bool          `json:"agrees"`
+}
+
+// runCompare queries every server at once and checks the answers against the
+// majority. It fails when any server disagrees, so scripts can test the exit
+// status.
+func runCompare(list []string) error {
+	a, err := parseArgs(list)
+	if err != nil {
+		return err
+	}
+	if len(a.servers) < 2 {
+		return errors.New("compare needs at least two @servers")
+	}
+	results := make([]*compareResult, len(a.servers))
+	var wg sync.WaitGroup
+	for i, server := range a.servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			r := &compareResult{Server: server, Answers: []string{}}
+			res, err := nettest.Query(a.request(server))
+			if err != nil {
+				r.Error = err.Error()
+			} else {
+				r.RTT = res.RTT
+				r.Rcode = dns.RcodeToString[res.Msg.Rcode]
+				for _, rr := range res.Msg.Answer {
+					r.Answers = append(r.Answers, dns.TypeToString[rr.Header().Rrtype]+" "+strings.TrimSpace(profile.Rdata(rr)))
+				}
+				sort.Strings(r.Answers)
+			}
+			results[i] = r
+		}(i, server)
+	}
+	wg.Wait()
+
+	majority, votes := agreement(results)
+	var disagree error
+	if votes != len(results) {
+		disagree = fmt.Errorf("%d of %d servers disagree", len(results)-votes, len(results))
+	}
+	if a.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{
+			"qname":   a.opts.Qname,
+			"qtype":   a.opts.Qtype,
+			"results": results,
+			"agree":   disagree == nil,
+		}); err != nil {
+			return err
+		}
+		return disagree
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tSERVER\tRTT\tRCODE\tANSWERS")
+	for _, r := range results {
+		mark := " "
+		if !r.Agrees {
+			mark = "*"
+		}
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t%s\n", mark, r.Server, r.Error)
+			continue
+		}
+		answers := strings.Join(r.Answers, ", ")
+		if answers == "" {
+			answers = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\t%s\n", mark, r.Server, ms(r.RTT), r.Rcode, answers)
+	}
+	tw.Flush()
+	if disagree == nil {
+		fmt.Printf("\nall %d servers agree\n", len(results))
+	} else {
+		fmt.Printf("\n%d of %d servers differ from the majority answer (%s)\n",
+			len(results)-votes, len(results), majority)
+	}
+	return disagree
+}
+
+// agreement marks the results that match the most common answer and returns
+// that answer and how many servers gave it. Failed queries never agree.
+func agreement(results []*compareResult) (string, int) {
+	key := func(r *compareResult) string {
+		return r.Rcode + " " + strings.Join(r.Answers, ", ")
+	}
+	tally := make(map[string]int)
+	for _, r := range results {
+		if r.Error == "" {
+			tally[key(r)]++
+		}
+	}
+	best, votes := "", 0
+	for k, n := range tally {
+		if n > votes || n == votes && k < best {
+			best, votes = k, n
+		}
+	}
+	for _, r := range results {
+		r.Agrees = r.Error == "" && key(r) == best
+	}
+	return strings.TrimSpace(best), votes
+}
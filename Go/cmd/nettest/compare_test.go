@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+
+	"github.com/miekg/dns"
+)
+
+func TestAgreement(t *testing.T) {
+	ok := func(answers ...string) *compareResult {
+		return &compareResult{Rcode: "NOERROR", Answers: answers}
+	}
+	failed := &compareResult{Error: "i/o timeout", Answers: []string{}}
+	tests := []struct {
+		name     string
+		results  []*compareResult
+		majority string
+		votes    int
+		agrees   string
+	}{
+		{"all agree", []*compareResult{ok("A 192.0.2.1"), ok("A 192.0.2.1")}, "NOERROR A 192.0.2.1", 2, "yy"},
+		{"one differs", []*compareResult{ok("A 192.0.2.1"), ok("A 192.0.2.2"), ok("A 192.0.2.1")}, "NOERROR A 192.0.2.1", 2, "yny"},
+		{"rcode differs", []*compareResult{ok(), {Rcode: "NXDOMAIN", Answers: []string{}}, ok()}, "NOERROR", 2, "yny"},
+		{"failure never agrees", []*compareResult{failed, ok("A 192.0.2.1")}, "NOERROR A 192.0.2.1", 1, "ny"},
+		{"all failed", []*compareResult{failed, failed}, "", 0, "nn"},
+		{"tie breaks by answer", []*compareResult{ok("A 192.0.2.2"), ok("A 192.0.2.1")}, "NOERROR A 192.0.2.1", 1, "ny"},
+		{"answer sets", []*compareResult{ok("A 192.0.2.1", "A 192.0.2.2"), ok("A 192.0.2.1")}, "NOERROR A 192.0.2.1", 1, "ny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			majority, votes := agreement(tt.results)
+			var agrees strings.Builder
+			for _, r := range tt.results {
+				if r.Agrees {
+					agrees.WriteByte('y')
+				} else {
+					agrees.WriteByte('n')
+				}
+			}
+			if majority != tt.majority || votes != tt.votes || agrees.String() != tt.agrees {
+				t.Fatalf("majority %q votes %d agrees %s, want %q %d %s", majority, votes, agrees.String(), tt.majority, tt.votes, tt.agrees)
+			}
+		})
+	}
+}
+
+// runCompare fails when the servers disagree, so the exit status shows it.
+func TestRunCompareExitStatus(t *testing.T) {
+	z, err := dnstest.ParseZoneString(`$TTL 300
+@ SOA ns1 hostmaster 1 3600 600 86400 60
+www A 192.0.2.1
+`, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := make([]string, 3)
+	for i := range servers {
+		s := dnstest.NewServer(z)
+		t.Cleanup(func() { s.Close() })
+		if servers[i], err = s.Listen("udp", ""); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			s.SetScript(dnstest.Always(dnstest.Fault{Rcode: dns.RcodeServerFailure}))
+		}
+	}
+	if err := runCompare([]string{"@" + servers[0], "@" + servers[1], "www.example.test", "+json"}); err != nil {
+		t.Fatalf("agreeing servers: %v", err)
+	}
+	for _, extra := range [][]string{nil, {"+json"}} {
+		err := runCompare(append([]string{"@" + servers[0], "@" + servers[1], "@" + servers[2], "www.example.test"}, extra...))
+		if err == nil || err.Error() != "1 of 3 servers disagree" {
+			t.Fatalf("%v: error %v", extra, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Command nettest runs the same DNS queries as the shared library from a
+// terminal: dig-like single queries, load tests, side-by-side comparisons of
+// several servers and test profiles.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: nettest <command> [@server...] [name] [type] [class] [options]
+       nettest run profile.yaml [--suite name] [--server group[/name]] [+json]
+
+commands:
+  query     send one query and print the answer, dig style
+  bench     send many queries and report latency percentiles and rcodes
+  compare   query several servers at once, exit 1 if they do not all agree
+  run       run the suites of a YAML or JSON profile, exit 1 if a check fails
+
+servers:
+  @1.1.1.1  @tcp://1.1.1.1  @tls://1.1.1.1  @https://1.1.1.1/dns-query
+  @quic://dns.adguard.com  @h3://dns.google/dns-query
+  The first nameserver in /etc/resolv.conf is used when none is given.
+  Without a name the root name servers are asked for, like dig: . NS
+
+options:
+  +short               print only the answer data
+  +json                print results as JSON
+  +tcp                 use TCP for udp:// servers
+  +cache               answer from and fill the response cache
+  -x addr              reverse lookup of addr
+  -4, -6               only use IPv4 or IPv6
+  --socks5 url         SOCKS5 proxy, socks5[h]://[user:pass@]host:port
+  --proxy url          http://, https://, socks5:// or socks5h:// proxy
+  --proxy-chain url    add a hop to a proxy chain (repeatable, dial order)
+  --proxy-insecure     skip certificate checks of https:// proxies
+  --sni name           TLS server name for encrypted servers
+  --subnet cidr        EDNS client subnet, e.g. 1.2.3.0/24
+  --bootstrap url      server that resolves hostname-based servers
+  --resolve h:p:addr   pin a hostname like curl --resolve (repeatable)
+  --source-ip addr     local address to send from
+  --interface name     network interface to bind to
+  --tsig name:secret[:algorithm]
+                       sign the query with TSIG (secret in base64)
+  --timeout duration   per query, e.g. 2s
+
+bench options:
+  -n count             queries per server (default 100)
+  -c concurrency       queries in flight per server (default 10)
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "query", "q":
+		err = runQuery(args)
+	case "bench":
+		err = runBench(args)
+	case "compare":
+		err = runCompare(args)
+	case "run":
+		err = runProfile(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "nettest: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "nettest:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	nettest "nettest/pkg/dns"
+	"nettest/pkg/dns/profile"
+)
+
+// runQuery sends the query to each server in turn and prints the answers.
+func runQuery(list []string) error {
+	a, err := parseArgs(list)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, server := range a.servers {
+		if a.json {
+			// Same JSON as the library's DnsRequestJson.
+			out := nettest.DnsRequestWithOptions(a.request(server))
+			fmt.Println(out)
+			var data map[string]interface{}
+			if json.Unmarshal([]byte(out), &data) != nil || data["answer"] == nil {
+				failed++
+			}
+			continue
+		}
+		start := time.Now()
+		res, err := nettest.Query(a.request(server))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, ";; %s: %v\n", server, err)
+			failed++
+			continue
+		}
+		if a.short {
+			for _, rr := range res.Msg.Answer {
+				fmt.Println(profile.Rdata(rr))
+			}
+			continue
+		}
+		printDig(server, start, res)
+	}
+	if failed > 0 {
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+// printDig prints res the way dig does, with the nettest extras after the footer.
+func printDig(server string, start time.Time, res *nettest.QueryResult) {
+	fmt.Println(res.Msg.String())
+	fmt.Printf(";; Query time: %d msec\n", res.RTT.Milliseconds())
+	fmt.Printf(";; SERVER: %s\n", server)
+	fmt.Printf(";; WHEN: %s\n", start.Format("Mon Jan 02 15:04:05 MST 2006"))
+	fmt.Printf(";; MSG SIZE  rcvd: %d\n", res.Msg.Len())
+	if res.Cache != "" {
+		fmt.Printf(";; CACHE: %s\n", res.Cache)
+	}
+	for _, hop := range res.ProxyHops {
+		fmt.Printf(";; PROXY HOP %d: %s %s, handshake %v\n", hop.Index+1, hop.Type, hop.Addr, hop.Handshake)
+	}
+	if t := res.Tsig; t != nil {
+		fmt.Printf(";; TSIG: %s %s, %d of %d responses verified", t.Key, t.Algorithm, t.Signed, t.Messages)
+		if t.Error != "" {
+			fmt.Printf(", %s", t.Error)
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+}
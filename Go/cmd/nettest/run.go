@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	nettest "nettest/pkg/dns"
+	"nettest/pkg/dns/profile"
+)
+
+// runProfile runs a profile file's suites and prints one line per check.
+func runProfile(list []string) error {
+	var (
+		path   string
+		opts   profile.RunOptions
+		asJSON bool
+	)
+	for i := 0; i < len(list); i++ {
+		arg := list[i]
+		name, v, inline := strings.Cut(arg, "=")
+		if strings.HasPrefix(name, "--") && !inline {
+			if i+1 >= len(list) {
+				return fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			v = list[i]
+		}
+		switch {
+		case arg == "+json":
+			asJSON = true
+		case name == "--suite":
+			opts.Suites = append(opts.Suites, v)
+		case name == "--server":
+			opts.Servers = append(opts.Servers, v)
+		case strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+"):
+			return fmt.Errorf("unknown option %s", arg)
+		case path == "":
+			path = arg
+		default:
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+	}
+	if path == "" {
+		return errors.New("run needs a profile file")
+	}
+	p, err := profile.Load(path)
+	if err != nil {
+		return err
+	}
+	rep, err := nettest.RunProfile(context.Background(), p, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		printReport(rep)
+	}
+	if rep.Failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", rep.Failed, rep.Checks)
+	}
+	return nil
+}
+
+func printReport(rep *profile.Report) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, sr := range rep.Suites {
+		fmt.Fprintf(tw, "suite %s: %d of %d passed\n", sr.Name, sr.Passed, sr.Checks)
+		for _, r := range sr.Results {
+			status := "PASS"
+			if !r.Pass {
+				status = "FAIL"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s %s\t%d/%d\t%v\t%s\n", status, r.Server, r.Qname, r.Qtype,
+				r.Passed, r.Runs, ms(r.AvgRTT), strings.Join(r.Failures, "; "))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tCHECKS\tRUNS\tLOSS\tAVG RTT")
+	for _, s := range rep.Servers {
+		fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%.0f%%\t%v\n", s.Server, s.Passed, s.Checks, s.Runs, s.Loss*100, ms(s.AvgRTT))
+	}
+	tw.Flush()
+	fmt.Printf("\n%d of %d checks passed, %d runs in %v\n", rep.Passed, rep.Checks, rep.Runs, ms(rep.Elapsed))
+}
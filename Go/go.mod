@@ -4,22 +4,24 @@ go 1.25.5
 
 require (
 	github.com/miekg/dns v1.1.69
+	github.com/sagernet/quic-go v0.52.0-beta.1
 	github.com/sagernet/sing v0.7.13
 	github.com/sagernet/sing-dns v0.4.6
 	github.com/txthinking/socks5 v0.0.0-20251011041537-5c31f201a10e
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/net v0.48.0
+	golang.org/x/sys v0.39.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
 	github.com/quic-go/qpack v0.5.1 // indirect
-	github.com/sagernet/quic-go v0.52.0-beta.1 // indirect
 	github.com/txthinking/runnergroup v0.0.0-20250224021307-5864ffeb65ae // indirect
 	golang.org/x/crypto v0.46.0 // indirect
 	golang.org/x/mod v0.31.0 // indirect
-	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
 )
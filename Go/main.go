@@ -46,6 +46,19 @@ func DnsRequestOverSocks5(proxy, server, qname, qtype, qclass, sni, clientSubnet
 	return C.CString(result)
 }
 
+//export DnsRequestOverProxy
+func DnsRequestOverProxy(proxy, server, qname, qtype, qclass, sni, clientSubnet *C.char) *C.char {
+	goProxy := C.GoString(proxy)
+	goServer := C.GoString(server)
+	goQname := C.GoString(qname)
+	goQtype := C.GoString(qtype)
+	goQclass := C.GoString(qclass)
+	goSNI := C.GoString(sni)
+	goClientSubnet := C.GoString(clientSubnet)
+	result := dns.DnsRequestOverProxy(goProxy, goServer, goQname, goQtype, goQclass, goSNI, goClientSubnet)
+	return C.CString(result)
+}
+
 //export DnsRequestAsync
 func DnsRequestAsync(server, qname, qtype, qclass, sni, clientSubnet *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
 	goServer := C.GoString(server)
@@ -77,6 +90,306 @@ func DnsRequestOverSocks5Async(proxy, server, qname, qtype, qclass, sni, clientS
 	}()
 }
 
+//export DnsRequestOverProxyAsync
+func DnsRequestOverProxyAsync(proxy, server, qname, qtype, qclass, sni, clientSubnet *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goProxy := C.GoString(proxy)
+	goServer := C.GoString(server)
+	goQname := C.GoString(qname)
+	goQtype := C.GoString(qtype)
+	goQclass := C.GoString(qclass)
+	goSNI := C.GoString(sni)
+	goClientSubnet := C.GoString(clientSubnet)
+	go func() {
+		result := dns.DnsRequestOverProxy(goProxy, goServer, goQname, goQtype, goQclass, goSNI, goClientSubnet)
+		cResult := C.CString(result)
+		C.callDnsCallback(cb, userData, cResult)
+	}()
+}
+
+//export DnsSdBrowseJson
+func DnsSdBrowseJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.DnsSdBrowseJson(goJSON)
+	return C.CString(result)
+}
+
+//export DnsSdBrowseJsonAsync
+func DnsSdBrowseJsonAsync(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goJSON := C.GoString(json)
+	go func() {
+		result := dns.DnsSdBrowseJson(goJSON)
+		cResult := C.CString(result)
+		C.callDnsCallback(cb, userData, cResult)
+	}()
+}
+
+//export ProxyCheckJson
+func ProxyCheckJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.ProxyCheckJson(goJSON)
+	return C.CString(result)
+}
+
+//export ProxyCheckJsonAsync
+func ProxyCheckJsonAsync(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goJSON := C.GoString(json)
+	go func() {
+		result := dns.ProxyCheckJson(goJSON)
+		cResult := C.CString(result)
+		C.callDnsCallback(cb, userData, cResult)
+	}()
+}
+
+//export ProxyCloseIdleSessions
+func ProxyCloseIdleSessions() *C.char {
+	result := dns.ProxyCloseIdleSessions()
+	return C.CString(result)
+}
+
+//export ForwarderStartJson
+func ForwarderStartJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.ForwarderStartJson(goJSON)
+	return C.CString(result)
+}
+
+//export ForwarderStop
+func ForwarderStop(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.ForwarderStop(goID)
+	return C.CString(result)
+}
+
+//export ForwarderStats
+func ForwarderStats(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.ForwarderStats(goID)
+	return C.CString(result)
+}
+
+//export TestServerStartJson
+func TestServerStartJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.TestServerStartJson(goJSON)
+	return C.CString(result)
+}
+
+//export TestServerStop
+func TestServerStop(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.TestServerStop(goID)
+	return C.CString(result)
+}
+
+//export ZoneTransferJson
+func ZoneTransferJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.ZoneTransferJson(goJSON)
+	return C.CString(result)
+}
+
+// ZoneTransferJsonAsync calls cb once per response message and a last time with
+// the summary ("done":true) or an error.
+//
+//export ZoneTransferJsonAsync
+func ZoneTransferJsonAsync(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goJSON := C.GoString(json)
+	go func() {
+		dns.ZoneTransferJsonStream(goJSON, func(result string) {
+			cResult := C.CString(result)
+			C.callDnsCallback(cb, userData, cResult)
+		})
+	}()
+}
+
+//export DnsUpdateJson
+func DnsUpdateJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.DnsUpdateJson(goJSON)
+	return C.CString(result)
+}
+
+//export DnsUpdateJsonAsync
+func DnsUpdateJsonAsync(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goJSON := C.GoString(json)
+	go func() {
+		result := dns.DnsUpdateJson(goJSON)
+		cResult := C.CString(result)
+		C.callDnsCallback(cb, userData, cResult)
+	}()
+}
+
+//export CacheConfigureJson
+func CacheConfigureJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.CacheConfigureJson(goJSON)
+	return C.CString(result)
+}
+
+//export CacheStatsJson
+func CacheStatsJson() *C.char {
+	result := dns.CacheStatsJson()
+	return C.CString(result)
+}
+
+//export CacheDumpJson
+func CacheDumpJson() *C.char {
+	result := dns.CacheDumpJson()
+	return C.CString(result)
+}
+
+//export CacheFlushJson
+func CacheFlushJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.CacheFlushJson(goJSON)
+	return C.CString(result)
+}
+
+// MonitorAddJson starts a monitor and returns its id; cb is called with every
+// event until the monitor is removed.
+//
+//export MonitorAddJson
+func MonitorAddJson(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.MonitorAddJson(goJSON, func(event string) {
+		cEvent := C.CString(event)
+		C.callDnsCallback(cb, userData, cEvent)
+	})
+	return C.CString(result)
+}
+
+//export MonitorRemove
+func MonitorRemove(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.MonitorRemove(goID)
+	return C.CString(result)
+}
+
+//export MonitorListJson
+func MonitorListJson() *C.char {
+	result := dns.MonitorListJson()
+	return C.CString(result)
+}
+
+//export MonitorHistoryJson
+func MonitorHistoryJson(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.MonitorHistoryJson(goID)
+	return C.CString(result)
+}
+
+//export MetricsServeJson
+func MetricsServeJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.MetricsServeJson(goJSON)
+	return C.CString(result)
+}
+
+//export MetricsStop
+func MetricsStop(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.MetricsStop(goID)
+	return C.CString(result)
+}
+
+//export MetricsText
+func MetricsText() *C.char {
+	result := dns.MetricsText()
+	return C.CString(result)
+}
+
+//export TracingConfigureJson
+func TracingConfigureJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.TracingConfigureJson(goJSON)
+	return C.CString(result)
+}
+
+//export TracingFlush
+func TracingFlush() *C.char {
+	result := dns.TracingFlush()
+	return C.CString(result)
+}
+
+//export TracingCollectorStart
+func TracingCollectorStart(listen *C.char) *C.char {
+	goListen := C.GoString(listen)
+	result := dns.TracingCollectorStart(goListen)
+	return C.CString(result)
+}
+
+//export TracingCollectorSpans
+func TracingCollectorSpans(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.TracingCollectorSpans(goID)
+	return C.CString(result)
+}
+
+//export TracingCollectorStop
+func TracingCollectorStop(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.TracingCollectorStop(goID)
+	return C.CString(result)
+}
+
+//export HistoryOpenJson
+func HistoryOpenJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.HistoryOpenJson(goJSON)
+	return C.CString(result)
+}
+
+//export HistoryClose
+func HistoryClose(id *C.char) *C.char {
+	goID := C.GoString(id)
+	result := dns.HistoryClose(goID)
+	return C.CString(result)
+}
+
+//export HistoryListJson
+func HistoryListJson() *C.char {
+	result := dns.HistoryListJson()
+	return C.CString(result)
+}
+
+//export HistoryQueryJson
+func HistoryQueryJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.HistoryQueryJson(goJSON)
+	return C.CString(result)
+}
+
+//export HistorySeriesJson
+func HistorySeriesJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.HistorySeriesJson(goJSON)
+	return C.CString(result)
+}
+
+//export ProfileLoadJson
+func ProfileLoadJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.ProfileLoadJson(goJSON)
+	return C.CString(result)
+}
+
+//export RunProfileJson
+func RunProfileJson(json *C.char) *C.char {
+	goJSON := C.GoString(json)
+	result := dns.RunProfileJson(goJSON)
+	return C.CString(result)
+}
+
+//export RunProfileJsonAsync
+func RunProfileJsonAsync(json *C.char, cb C.DnsCallback, userData unsafe.Pointer) {
+	goJSON := C.GoString(json)
+	go func() {
+		result := dns.RunProfileJson(goJSON)
+		cResult := C.CString(result)
+		C.callDnsCallback(cb, userData, cResult)
+	}()
+}
+
 //export FreeCString
 func FreeCString(s *C.char) {
 	utils.FreeCString(unsafe.Pointer(s))
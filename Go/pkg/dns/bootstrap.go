@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"nettest/pkg/dns/singdns"
+	"nettest/pkg/dns/transport"
+
+	"github.com/miekg/dns"
+)
+
+// bootstrapResolver resolves upstream hostnames through a dedicated DNS server
+// instead of the system resolver.
+type bootstrapResolver struct {
+	server string
+	dialer transport.Dialer
+}
+
+func (r *bootstrapResolver) LookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	var pd transport.PacketDialer
+	if v, ok := r.dialer.(transport.PacketDialer); ok {
+		pd = v
+	}
+	sd := singdns.NewDialerAdapter(r.dialer, pd)
+	serverAddr := getTransportAddress(r.server, GetNetScheme(r.server))
+	t, err := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr})
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		addrs []netip.Addr
+		errs  []error
+	)
+	for _, qtype := range []string{"A", "AAAA"} {
+		wg.Add(1)
+		go func(qtype string) {
+			defer wg.Done()
+			resp, err := t.Exchange(ctx, buildDnsMassage(host, qtype, "IN"))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if resp.Rcode != dns.RcodeSuccess {
+				errs = append(errs, fmt.Errorf("%s %s: %s", host, qtype, dns.RcodeToString[resp.Rcode]))
+				return
+			}
+			for _, rr := range resp.Answer {
+				switch a := rr.(type) {
+				case *dns.A:
+					if ip, ok := netip.AddrFromSlice(a.A.To4()); ok {
+						addrs = append(addrs, ip)
+					}
+				case *dns.AAAA:
+					if ip, ok := netip.AddrFromSlice(a.AAAA); ok {
+						addrs = append(addrs, ip)
+					}
+				}
+			}
+		}(qtype)
+	}
+	wg.Wait()
+	if len(addrs) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, fmt.Errorf("%s: no A/AAAA records", host)
+	}
+	// Prefer IPv4 first, matching what most upstreams are reachable over.
+	slices.SortStableFunc(addrs, func(a, b netip.Addr) int {
+		switch {
+		case a.Is4() == b.Is4():
+			return 0
+		case a.Is4():
+			return -1
+		default:
+			return 1
+		}
+	})
+	return addrs, nil
+}
+
+// pinnedOnly refuses to resolve: the bootstrap server is reached through the
+// pins alone, never through the system resolver.
+type pinnedOnly struct{}
+
+func (pinnedOnly) LookupHost(_ context.Context, host string) ([]netip.Addr, error) {
+	return nil, fmt.Errorf("%s is not pinned with resolve", host)
+}
+
+// bootstrapHost returns the host part of a bootstrap server URL.
+func bootstrapHost(server string) string {
+	addr := GetNetAddress(server)
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr = addr[:i]
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// newBootstrapDialer wraps base with pins from resolve ("host:port:addr") and an
+// optional bootstrap server. The bootstrap server itself only honours the pins,
+// so a hostname-based bootstrap server must be pinned.
+func newBootstrapDialer(base transport.Dialer, bootstrap string, resolve []string, ipVersion int) (*transport.BootstrapDialer, error) {
+	var pins []transport.HostPin
+	for _, s := range resolve {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		pin, err := transport.ParseHostPin(s)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	opts := transport.BootstrapOptions{Pins: pins, IPVersion: ipVersion}
+	if b := strings.TrimSpace(bootstrap); b != "" {
+		if GetNetScheme(b) == "mdns" {
+			return nil, errors.New("mdns cannot be used as a bootstrap server")
+		}
+		host := strings.ToLower(strings.TrimSuffix(bootstrapHost(b), "."))
+		if _, err := netip.ParseAddr(host); err != nil && !slices.ContainsFunc(pins, func(p transport.HostPin) bool { return p.Host == host }) {
+			return nil, fmt.Errorf("bootstrap server %s must be an IP address or pinned with resolve", b)
+		}
+		opts.Server = b
+		opts.Resolver = &bootstrapResolver{
+			server: b,
+			dialer: transport.NewBootstrapDialer(base, transport.BootstrapOptions{Pins: pins, Resolver: pinnedOnly{}, Server: b}),
+		}
+	}
+	return transport.NewBootstrapDialer(base, opts), nil
+}
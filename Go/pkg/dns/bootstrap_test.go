@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"nettest/pkg/dns/transport"
+)
+
+// A hostname bootstrap server must never fall back to the system resolver.
+func TestBootstrapServerMustBePinned(t *testing.T) {
+	base := transport.NewDirectDialer(transport.DialOptions{})
+	tests := []struct {
+		bootstrap string
+		resolve   []string
+		ok        bool
+	}{
+		{"udp://8.8.8.8", nil, true},
+		{"tls://[2001:db8::53]:853", nil, true},
+		{"udp://dns.example", nil, false},
+		{"https://dns.example/dns-query", nil, false},
+		{"udp://dns.example", []string{"other.example:53:192.0.2.1"}, false},
+		{"udp://dns.example", []string{"dns.example:53:192.0.2.53"}, true},
+		{"https://DNS.example./dns-query", []string{"dns.example:443:192.0.2.53"}, true},
+	}
+	for _, tt := range tests {
+		_, err := newBootstrapDialer(base, tt.bootstrap, tt.resolve, 0)
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s with %q: %v", tt.bootstrap, tt.resolve, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "pinned") {
+			t.Fatalf("%s: %v", tt.bootstrap, err)
+		}
+	}
+}
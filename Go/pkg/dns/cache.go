@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nettest/pkg/dns/singdns"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// sharedCache backs every request and forwarder that sets "cache":true.
+var sharedCache = singdns.NewCache(singdns.CacheOptions{})
+
+// CacheConfigureJson replaces the shared cache's tuning and returns the effective
+// options. Fields: max_entries, min_ttl, max_ttl, max_negative_ttl, serve_stale,
+// stale_ttl, stale_answer_ttl, prefetch_percent; unset fields take their defaults.
+// Example: {"max_entries":10000,"min_ttl":60,"max_ttl":3600,"serve_stale":true,"prefetch_percent":10}
+func CacheConfigureJson(jsonStr string) string {
+	var opts singdns.CacheOptions
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+			return utils.BuildErrJSON(err)
+		}
+	}
+	sharedCache.SetOptions(opts)
+	return marshalResult(sharedCache.Options())
+}
+
+// CacheStatsJson returns the shared cache's hit/miss/eviction counters.
+func CacheStatsJson() string {
+	return marshalResult(sharedCache.Stats())
+}
+
+// CacheDumpJson lists the cached entries, most recently used first.
+func CacheDumpJson() string {
+	return marshalResult(map[string]interface{}{
+		"stats":   sharedCache.Stats(),
+		"entries": sharedCache.Dump(),
+	})
+}
+
+// CacheFlushJson removes entries for name (every type unless type is given), or
+// the whole cache when name is empty, and returns how many were removed.
+// Example: {"name":"example.com","type":"AAAA"}
+func CacheFlushJson(jsonStr string) string {
+	var in struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+			return utils.BuildErrJSON(err)
+		}
+	}
+	var qtype uint16
+	if in.Type != "" {
+		t, ok := dns.StringToType[strings.ToUpper(in.Type)]
+		if !ok {
+			return utils.BuildErrJSON(fmt.Errorf("unknown record type %q", in.Type))
+		}
+		qtype = t
+	}
+	return fmt.Sprintf(`{"flushed":%d}`, sharedCache.Flush(in.Name, qtype))
+}
+
+func marshalResult(v interface{}) string {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
@@ -0,0 +1,92 @@
+package dnstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The certificate is shared by every server in the process so that it can be
+// trusted once, before the first TLS handshake, via TrustRoot.
+var testCert struct {
+	once sync.Once
+	cert tls.Certificate
+	pem  []byte
+	err  error
+}
+
+func certificate() (tls.Certificate, error) {
+	testCert.once.Do(func() {
+		testCert.cert, testCert.pem, testCert.err = selfSigned("localhost", "127.0.0.1", "::1")
+	})
+	return testCert.cert, testCert.err
+}
+
+// RootPEM returns the PEM encoded self-signed certificate presented by the TLS,
+// HTTPS, QUIC and HTTP/3 listeners. It is valid for localhost, 127.0.0.1 and ::1.
+func RootPEM() ([]byte, error) {
+	if _, err := certificate(); err != nil {
+		return nil, err
+	}
+	return testCert.pem, nil
+}
+
+// TrustRoot writes RootPEM to a temporary file and points SSL_CERT_FILE at it,
+// so clients that verify against the system roots accept the test listeners.
+// Go reads the system roots once per process: call it before the first TLS
+// handshake, e.g. from TestMain. The returned path can be removed afterwards.
+func TrustRoot() (string, error) {
+	b, err := RootPEM()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(os.TempDir(), "dnstest-root-"+time.Now().Format("20060102150405.000000000")+".pem")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return "", err
+	}
+	return path, os.Setenv("SSL_CERT_FILE", path)
+}
+
+func selfSigned(hosts ...string) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"NetTest dnstest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, p, nil
+}
@@ -0,0 +1,165 @@
+package dnstest
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Fault describes how the server misbehaves for one query or handshake. The
+// zero value answers normally. Delay applies first; then the first set field
+// of Reset, Drop, Malformed, Rcode, Truncate decides the reply, and WrongID
+// may be combined with any reply that is sent.
+type Fault struct {
+	Delay         time.Duration // wait before replying (or before finishing the handshake)
+	Drop          bool          // never reply; the connection or stream stays open
+	Reset         bool          // abort the connection (TCP RST, HTTP stream abort, QUIC close); UDP drops
+	Malformed     bool          // reply with bytes that do not parse as a DNS message
+	Rcode         int           // reply with this rcode and no records, e.g. dns.RcodeServerFailure
+	Truncate      bool          // reply with TC set and no records
+	WrongID       bool          // reply with a message ID that does not match the query
+	HandshakeFail bool          // TLS-based schemes: reject the handshake with an alert
+}
+
+// Query is what a Script sees. Msg is nil when the script is consulted for a
+// TLS or QUIC handshake; then only Delay and HandshakeFail are honoured.
+type Query struct {
+	Net string // scheme of the listener: udp, tcp, tls, https, quic or h3
+	Seq int    // 0-based count of queries (or of handshakes) seen by the server
+	Msg *dns.Msg
+}
+
+// Script picks the fault for each query. It may be called concurrently.
+type Script func(q Query) Fault
+
+// Always applies f to every query and handshake.
+func Always(f Fault) Script {
+	return func(Query) Fault { return f }
+}
+
+// Sequence applies faults[i] to the i-th query and answers normally after
+// that. Handshakes are unaffected.
+func Sequence(faults ...Fault) Script {
+	return func(q Query) Fault {
+		if q.Msg == nil || q.Seq >= len(faults) {
+			return Fault{}
+		}
+		return faults[q.Seq]
+	}
+}
+
+// OnNet applies script to queries arriving over the given schemes only.
+func OnNet(script Script, nets ...string) Script {
+	return func(q Query) Fault {
+		for _, n := range nets {
+			if n == q.Net {
+				return script(q)
+			}
+		}
+		return Fault{}
+	}
+}
+
+var errHandshakeFault = errors.New("dnstest: handshake rejected by script")
+
+// malformedReply is a header announcing one question whose name is a
+// compression pointer to itself, which no parser accepts.
+var malformedReply = []byte{0, 0, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0x0c, 0, 1, 0, 1}
+
+// fault consults the script; a nil r is counted as a handshake.
+func (s *Server) fault(network string, r *dns.Msg) Fault {
+	s.mu.Lock()
+	script := s.script
+	var seq int
+	if r == nil {
+		seq = s.handshakes
+		s.handshakes++
+	} else {
+		seq = s.queries
+		s.queries++
+	}
+	s.mu.Unlock()
+	if script == nil {
+		return Fault{}
+	}
+	return script(Query{Net: network, Seq: seq, Msg: r})
+}
+
+// wait sleeps for d, returning false if the server is closed first.
+func (s *Server) wait(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// respond produces the wire reply for r, received as wire, after applying the
+// script. A nil reply means send nothing; reset asks the caller to abort the
+// connection.
+func (s *Server) respond(network string, r *dns.Msg, wire []byte) (out []byte, reset bool) {
+	f := s.fault(network, r)
+	if !s.wait(f.Delay) {
+		return nil, false
+	}
+	switch {
+	case f.Reset:
+		return nil, true
+	case f.Drop:
+		return nil, false
+	case f.Malformed:
+		out = append([]byte(nil), malformedReply...)
+		out[0], out[1] = byte(r.Id>>8), byte(r.Id)
+	default:
+		var resp *dns.Msg
+		switch {
+		case f.Rcode != 0:
+			resp = new(dns.Msg)
+			resp.SetRcode(r, f.Rcode)
+		case f.Truncate:
+			resp = new(dns.Msg)
+			resp.SetReply(r)
+			resp.Truncated = true
+		default:
+			resp = s.Exchange(r)
+			if opt := r.IsEdns0(); opt != nil {
+				resp.SetEdns0(opt.UDPSize(), false)
+			}
+			if network == "udp" {
+				size := dns.MinMsgSize
+				if opt := r.IsEdns0(); opt != nil {
+					size = int(opt.UDPSize())
+				}
+				resp.Truncate(size)
+			}
+		}
+		var err error
+		if out, err = s.pack(r, wire, resp); err != nil {
+			return nil, false
+		}
+	}
+	if f.WrongID {
+		id := r.Id + 1
+		out[0], out[1] = byte(id>>8), byte(id)
+	}
+	return out, false
+}
+
+// handshakeConfig consults the script for each TLS or QUIC handshake.
+func (s *Server) handshakeConfig(network string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		f := s.fault(network, nil)
+		if !s.wait(f.Delay) || f.HandshakeFail {
+			return nil, errHandshakeFault
+		}
+		return nil, nil
+	}
+}
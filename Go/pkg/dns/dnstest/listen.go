@@ -0,0 +1,405 @@
+package dnstest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/quic-go"
+	"github.com/sagernet/quic-go/http3"
+)
+
+// DoHPath is where the https and h3 listeners answer (RFC 8484).
+const DoHPath = "/dns-query"
+
+// idleTimeout closes stream connections that stay silent this long.
+const idleTimeout = 30 * time.Second
+
+// Listen starts a listener for scheme (udp, tcp, tls, https, quic or h3) on
+// addr (default "127.0.0.1:0") and returns its server URL, e.g.
+// "tls://127.0.0.1:40123" or "https://127.0.0.1:40124/dns-query". TLS-based
+// listeners present the certificate from RootPEM. The tcp and tls listeners
+// also serve AXFR and IXFR of the zones.
+func (s *Server) Listen(scheme, addr string) (string, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	var (
+		bound string
+		err   error
+	)
+	switch scheme {
+	case "udp":
+		bound, err = s.listenUDP(addr)
+	case "tcp":
+		bound, err = s.listenStream("tcp", addr, nil)
+	case "tls", "https", "quic", "h3":
+		var cfg *tls.Config
+		if cfg, err = s.tlsConfig(scheme); err == nil {
+			bound, err = s.listenEncrypted(scheme, addr, cfg)
+		}
+	default:
+		err = fmt.Errorf("dnstest: unsupported scheme %q", scheme)
+	}
+	if err != nil {
+		return "", err
+	}
+	u := scheme + "://" + bound
+	if scheme == "https" || scheme == "h3" {
+		u += DoHPath
+	}
+	s.mu.Lock()
+	s.urls[scheme] = u
+	s.mu.Unlock()
+	return u, nil
+}
+
+// addCloser registers c with Close, closing it at once if the server is already closed.
+func (s *Server) addCloser(c io.Closer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		_ = c.Close()
+		return net.ErrClosed
+	}
+	s.closers = append(s.closers, c)
+	return nil
+}
+
+func (s *Server) tlsConfig(scheme string) (*tls.Config, error) {
+	cert, err := certificate()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: s.handshakeConfig(scheme),
+	}
+	switch scheme {
+	case "https":
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	case "quic":
+		cfg.NextProtos = []string{"doq"}
+	case "h3":
+		cfg = http3.ConfigureTLSConfig(cfg)
+	}
+	return cfg, nil
+}
+
+func (s *Server) listenUDP(addr string) (string, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	if err := s.addCloser(pc); err != nil {
+		return "", err
+	}
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			wire := append([]byte(nil), buf[:n]...)
+			r := new(dns.Msg)
+			if r.Unpack(wire) != nil {
+				continue
+			}
+			go func() {
+				if out, _ := s.respond("udp", r, wire); out != nil {
+					_, _ = pc.WriteTo(out, from)
+				}
+			}()
+		}
+	}()
+	return pc.LocalAddr().String(), nil
+}
+
+// listenStream serves 2-byte length-prefixed messages over TCP, wrapped in TLS
+// when cfg is set (RFC 7766, RFC 7858). Queries on one connection are answered
+// concurrently, so a dropped or delayed one does not hold up the rest.
+func (s *Server) listenStream(scheme, addr string, cfg *tls.Config) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	if err := s.addCloser(l); err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if cfg != nil {
+				c = tls.Server(c, cfg)
+			}
+			go s.serveConn(scheme, c)
+		}
+	}()
+	return l.Addr().String(), nil
+}
+
+func (s *Server) serveConn(scheme string, c net.Conn) {
+	if !s.track(c) {
+		_ = c.Close()
+		return
+	}
+	defer s.untrack(c)
+	defer c.Close()
+	if tc, ok := c.(*tls.Conn); ok {
+		_ = c.SetDeadline(time.Now().Add(idleTimeout))
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		_ = c.SetDeadline(time.Time{})
+	}
+	// Responders may still be delayed when the reader returns, so writes is
+	// never closed; done tells the writer and late responders to give up.
+	writes := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case b := <-writes:
+				if _, err := c.Write(b); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(idleTimeout))
+		r, wire, err := readPrefixed(c)
+		if err != nil {
+			return
+		}
+		go func() {
+			msgs, reset, ok := s.transfer(scheme, r, wire)
+			if !ok {
+				var out []byte
+				if out, reset = s.respond(scheme, r, wire); out != nil {
+					msgs = [][]byte{out}
+				}
+			}
+			if reset {
+				abort(c)
+				return
+			}
+			for _, out := range msgs {
+				select {
+				case writes <- prefixed(out):
+				case <-done:
+					return
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (s *Server) listenEncrypted(scheme, addr string, cfg *tls.Config) (string, error) {
+	switch scheme {
+	case "tls":
+		return s.listenStream("tls", addr, cfg)
+	case "https":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return "", err
+		}
+		srv := &http.Server{
+			Handler:           s.dohHandler("https"),
+			TLSConfig:         cfg,
+			ReadHeaderTimeout: idleTimeout,
+			ErrorLog:          log.New(io.Discard, "", 0), // scripted handshake failures are expected
+		}
+		if err := s.addCloser(srv); err != nil {
+			_ = l.Close()
+			return "", err
+		}
+		go func() { _ = srv.ServeTLS(l, "", "") }()
+		return l.Addr().String(), nil
+	case "h3":
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return "", err
+		}
+		srv := &http3.Server{Handler: s.dohHandler("h3"), TLSConfig: cfg}
+		if err := s.addCloser(srv); err != nil {
+			_ = pc.Close()
+			return "", err
+		}
+		if err := s.addCloser(pc); err != nil {
+			return "", err
+		}
+		go func() { _ = srv.Serve(pc) }()
+		return pc.LocalAddr().String(), nil
+	default: // quic
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return "", err
+		}
+		ln, err := quic.Listen(pc, cfg, &quic.Config{MaxIdleTimeout: idleTimeout})
+		if err != nil {
+			_ = pc.Close()
+			return "", err
+		}
+		if err := s.addCloser(ln); err != nil {
+			_ = pc.Close()
+			return "", err
+		}
+		if err := s.addCloser(pc); err != nil {
+			return "", err
+		}
+		go s.serveDoQ(ln)
+		return pc.LocalAddr().String(), nil
+	}
+}
+
+// dohHandler answers RFC 8484 GET (?dns=) and POST requests. Reset aborts the
+// HTTP stream; Drop holds the request until the client gives up.
+func (s *Server) dohHandler(scheme string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DoHPath, func(w http.ResponseWriter, req *http.Request) {
+		var (
+			buf []byte
+			err error
+		)
+		switch req.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			buf, err = io.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r := new(dns.Msg)
+		if err == nil {
+			err = r.Unpack(buf)
+		}
+		if err != nil {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		out, reset := s.respond(scheme, r, buf)
+		switch {
+		case reset:
+			panic(http.ErrAbortHandler)
+		case out == nil:
+			select {
+			case <-req.Context().Done():
+			case <-s.done:
+			}
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(out)
+	})
+	return mux
+}
+
+// serveDoQ answers one query per bidirectional stream (RFC 9250). Reset closes
+// the whole QUIC connection.
+func (s *Server) serveDoQ(ln *quic.Listener) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		closer := &quicCloser{conn}
+		if !s.track(closer) {
+			_ = closer.Close()
+			return
+		}
+		go func() {
+			defer s.untrack(closer)
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go s.serveDoQStream(conn, stream)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveDoQStream(conn quic.Connection, stream quic.Stream) {
+	_ = stream.SetReadDeadline(time.Now().Add(idleTimeout))
+	r, wire, err := readPrefixed(stream)
+	if err != nil {
+		stream.CancelRead(quic.StreamErrorCode(0x2)) // DOQ_PROTOCOL_ERROR
+		stream.CancelWrite(quic.StreamErrorCode(0x2))
+		return
+	}
+	out, reset := s.respond("quic", r, wire)
+	switch {
+	case reset:
+		_ = conn.CloseWithError(quic.ApplicationErrorCode(0x1), "dnstest: reset") // DOQ_INTERNAL_ERROR
+	case out == nil:
+		select {
+		case <-stream.Context().Done():
+		case <-s.done:
+		}
+	default:
+		_, _ = stream.Write(prefixed(out))
+		_ = stream.Close()
+	}
+}
+
+// readPrefixed reads one length-prefixed message, returning it parsed and raw.
+func readPrefixed(r io.Reader) (*dns.Msg, []byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, err
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return nil, nil, err
+	}
+	return m, buf, nil
+}
+
+func prefixed(b []byte) []byte {
+	out := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(out, uint16(len(b)))
+	copy(out[2:], b)
+	return out
+}
+
+// abort closes c with SO_LINGER 0 so the peer sees a TCP RST.
+func abort(c net.Conn) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+// quicCloser lets Close tear down QUIC connections; it is a pointer so it can
+// be tracked in a map.
+type quicCloser struct{ conn quic.Connection }
+
+func (q *quicCloser) Close() error { return q.conn.CloseWithError(0, "") }
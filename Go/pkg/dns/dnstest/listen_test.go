@@ -0,0 +1,55 @@
+package dnstest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testZone = `$TTL 300
+@        SOA   ns1 hostmaster 1 3600 600 86400 60
+@        NS    ns1
+ns1      A     192.0.2.53
+www      A     192.0.2.1
+`
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	z, err := ParseZoneString(testZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// A delayed reply for a client that already hung up must be dropped, not sent
+// on a closed channel (which would crash the test binary).
+func TestDelayedReplyAfterClientClose(t *testing.T) {
+	s := newTestServer(t)
+	addr, err := s.listenStream("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetScript(Always(Fault{Delay: 100 * time.Millisecond}))
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.test.", dns.TypeA)
+	buf, _ := m.Pack()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Write(prefixed(buf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	time.Sleep(300 * time.Millisecond)
+	if n := s.Queries(); n != 3 {
+		t.Fatalf("server saw %d queries, want 3", n)
+	}
+}
@@ -0,0 +1,180 @@
+package dnstest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Server answers authoritatively from its zones on loopback listeners for any
+// scheme NetTest speaks, optionally misbehaving as directed by a Script.
+type Server struct {
+	zones []*Zone
+	done  chan struct{}
+
+	mu         sync.Mutex
+	script     Script
+	signer     Signer
+	queries    int
+	handshakes int
+	addr       string
+	urls       map[string]string
+	closers    []io.Closer
+	conns      map[io.Closer]struct{}
+	closed     bool
+}
+
+// NewServer creates a server for zones; queries outside all of them (or all
+// queries, when there are no zones) get REFUSED.
+func NewServer(zones ...*Zone) *Server {
+	return &Server{
+		zones: zones,
+		done:  make(chan struct{}),
+		urls:  make(map[string]string),
+		conns: make(map[io.Closer]struct{}),
+	}
+}
+
+// Start listens on addr (default "127.0.0.1:0") over UDP and TCP on the same
+// port and returns once both are serving. Addr reports the bound address.
+func (s *Server) Start(addr string) error {
+	u, err := s.Listen("udp", addr)
+	if err != nil {
+		return err
+	}
+	bound := strings.TrimPrefix(u, "udp://")
+	if _, err := s.Listen("tcp", bound); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.addr = bound
+	s.mu.Unlock()
+	return nil
+}
+
+// Addr is the "host:port" of the UDP/TCP listeners opened by Start.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// URL is the server URL of the most recent listener for scheme, in the form
+// accepted by DnsRequestOptions.Server, or "" if there is none.
+func (s *Server) URL(scheme string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.urls[scheme]
+}
+
+// SetScript replaces the fault script; nil answers every query normally.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+}
+
+// SetSigner makes the server check signed queries and sign their replies with
+// sg; nil answers without signatures.
+func (s *Server) SetSigner(sg Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signer = sg
+}
+
+// Queries is the number of well-formed queries received so far.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Close stops all listeners, aborts open connections and releases delayed or
+// dropped replies.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	closers := s.closers
+	for c := range s.conns {
+		closers = append(closers, c)
+	}
+	s.closers, s.conns = nil, nil
+	s.mu.Unlock()
+
+	var first error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && first == nil && !errors.Is(err, net.ErrClosed) {
+			first = err
+		}
+	}
+	return first
+}
+
+// Zone returns the most specific zone containing name, or nil.
+func (s *Server) Zone(name string) *Zone {
+	var best *Zone
+	for _, z := range s.zones {
+		if z.Contains(name) && (best == nil || dns.CountLabel(z.Origin) > dns.CountLabel(best.Origin)) {
+			best = z
+		}
+	}
+	return best
+}
+
+// Exchange answers m in-process, without a socket and without faults.
+func (s *Server) Exchange(m *dns.Msg) *dns.Msg {
+	if len(m.Question) == 1 {
+		if z := s.Zone(m.Question[0].Name); z != nil {
+			return z.Answer(m)
+		}
+	}
+	resp := new(dns.Msg)
+	resp.SetRcode(m, dns.RcodeRefused)
+	if len(m.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+	}
+	return resp
+}
+
+// ServeDNS makes the server usable as a dns.Handler, e.g. behind a dns.Server
+// managed by the caller. Scripts apply except for Reset, which drops. A Signer
+// sees the query re-packed, as the raw message is not available here.
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	network := "tcp"
+	if strings.HasPrefix(w.RemoteAddr().Network(), "udp") {
+		network = "udp"
+	}
+	wire, err := r.Pack()
+	if err != nil {
+		return
+	}
+	if out, _ := s.respond(network, r, wire); out != nil {
+		_, _ = w.Write(out)
+	}
+}
+
+// track registers c to be closed by Close; it reports false if already closed.
+func (s *Server) track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
@@ -0,0 +1,128 @@
+package dnstest
+
+import (
+	"crypto"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Signer checks the signature of a query and signs the reply, like a server
+// holding the client's TSIG or SIG(0) key.
+type Signer interface {
+	// Sign returns the wire form of resp, the reply to req received as wire.
+	Sign(req *dns.Msg, wire []byte, resp *dns.Msg) ([]byte, error)
+}
+
+// streamSigner is a Signer that can also sign a reply of several messages.
+type streamSigner interface {
+	signStream(req *dns.Msg, wire []byte, resps []*dns.Msg) ([][]byte, error)
+}
+
+// pack signs resp with the server's signer, if any.
+func (s *Server) pack(r *dns.Msg, wire []byte, resp *dns.Msg) ([]byte, error) {
+	s.mu.Lock()
+	sg := s.signer
+	s.mu.Unlock()
+	if sg == nil {
+		return resp.Pack()
+	}
+	return sg.Sign(r, wire, resp)
+}
+
+// TSIG signs replies to queries signed with its key (RFC 8945). A query that
+// fails to verify gets NOTAUTH with the TSIG error, unsigned; unsigned queries
+// get unsigned replies.
+type TSIG struct {
+	Name      string // key name, e.g. "key.example."
+	Algorithm string // default dns.HmacSHA256
+	Secret    string // base64
+}
+
+func (k TSIG) Sign(req *dns.Msg, wire []byte, resp *dns.Msg) ([]byte, error) {
+	out, err := k.signStream(req, wire, []*dns.Msg{resp})
+	if err != nil {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+// signStream signs a reply of several messages, such as a zone transfer, each
+// MAC covering the one before it (RFC 8945 §5.3.1). A query that fails to
+// verify gets a single NOTAUTH message.
+func (k TSIG) signStream(req *dns.Msg, wire []byte, resps []*dns.Msg) ([][]byte, error) {
+	t := req.IsTsig()
+	out := make([][]byte, len(resps))
+	if t == nil {
+		for i, resp := range resps {
+			b, err := resp.Pack()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = b
+		}
+		return out, nil
+	}
+	name, alg := dns.CanonicalName(k.Name), dns.HmacSHA256
+	if k.Algorithm != "" {
+		alg = dns.CanonicalName(k.Algorithm)
+	}
+	var code uint16
+	switch {
+	case dns.CanonicalName(t.Hdr.Name) != name:
+		code = dns.RcodeBadKey
+	case dns.TsigVerify(wire, k.Secret, "", false) != nil:
+		code = dns.RcodeBadSig
+	}
+	if code != 0 {
+		resp := resps[0].Copy()
+		resp.Rcode, resp.Answer, resp.Ns = dns.RcodeNotAuth, nil, nil
+		resp.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+		resp.Extra[len(resp.Extra)-1].(*dns.TSIG).Error = code
+		b, err := resp.Pack()
+		return [][]byte{b}, err
+	}
+	mac := t.MAC
+	for i, resp := range resps {
+		resp.SetTsig(name, alg, 300, time.Now().Unix())
+		b, next, err := dns.TsigGenerate(resp, k.Secret, mac, i > 0)
+		if err != nil {
+			return nil, err
+		}
+		out[i], mac = b, next
+	}
+	return out, nil
+}
+
+// SIG0 signs replies to queries carrying a SIG(0) that verifies against
+// ClientKey (RFC 2931). Other signed queries get NOTAUTH, unsigned; unsigned
+// queries get unsigned replies.
+type SIG0 struct {
+	Key       *dns.KEY      // the server's public key
+	Signer    crypto.Signer // and its private half
+	ClientKey *dns.KEY
+}
+
+func (k SIG0) Sign(req *dns.Msg, wire []byte, resp *dns.Msg) ([]byte, error) {
+	if len(req.Extra) == 0 {
+		return resp.Pack()
+	}
+	sig, ok := req.Extra[len(req.Extra)-1].(*dns.SIG)
+	if !ok {
+		return resp.Pack()
+	}
+	if k.ClientKey == nil || sig.Verify(k.ClientKey, wire) != nil || !sig.ValidityPeriod(time.Now()) {
+		resp = resp.Copy()
+		resp.Rcode, resp.Answer, resp.Ns = dns.RcodeNotAuth, nil, nil
+		return resp.Pack()
+	}
+	now := time.Now()
+	out := &dns.SIG{RRSIG: dns.RRSIG{
+		Algorithm:  k.Key.Algorithm,
+		SignerName: k.Key.Hdr.Name,
+		KeyTag:     k.Key.KeyTag(),
+		Inception:  uint32(now.Add(-5 * time.Minute).Unix()),
+		Expiration: uint32(now.Add(5 * time.Minute).Unix()),
+	}}
+	return out.Sign(k.Signer, resp)
+}
@@ -0,0 +1,74 @@
+package dnstest
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// transferChunk is the number of records per transfer message, kept small so
+// that test zones of a few dozen records span several messages.
+const transferChunk = 16
+
+// transfer answers r over a stream when it asks for an AXFR or IXFR of one of
+// the server's zones; ok is false for any other query. IXFR has no history to
+// send differences from: a client at the current serial (or later) gets the
+// single SOA, any other the full zone (RFC 1995 §4). Scripts apply Delay, Drop
+// and Reset to the whole transfer.
+func (s *Server) transfer(network string, r *dns.Msg, wire []byte) (msgs [][]byte, reset, ok bool) {
+	if len(r.Question) != 1 {
+		return nil, false, false
+	}
+	q := r.Question[0]
+	if q.Qtype != dns.TypeAXFR && q.Qtype != dns.TypeIXFR {
+		return nil, false, false
+	}
+	z := s.Zone(q.Name)
+	if z == nil || !strings.EqualFold(z.Origin, q.Name) {
+		return nil, false, false
+	}
+	f := s.fault(network, r)
+	if !s.wait(f.Delay) {
+		return nil, false, true
+	}
+	switch {
+	case f.Reset:
+		return nil, true, true
+	case f.Drop:
+		return nil, false, true
+	}
+
+	rrs := z.Transfer()
+	if q.Qtype == dns.TypeIXFR && len(r.Ns) == 1 {
+		if soa, isSOA := r.Ns[0].(*dns.SOA); isSOA && int32(soa.Serial-z.soa.Serial) >= 0 {
+			rrs = rrs[:1]
+		}
+	}
+	var resps []*dns.Msg
+	for i := 0; i < len(rrs); i += transferChunk {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = rrs[i:min(i+transferChunk, len(rrs))]
+		resps = append(resps, m)
+	}
+
+	s.mu.Lock()
+	sg := s.signer
+	s.mu.Unlock()
+	if ss, isStream := sg.(streamSigner); isStream {
+		msgs, err := ss.signStream(r, wire, resps)
+		if err != nil {
+			return nil, false, true
+		}
+		return msgs, false, true
+	}
+	for _, m := range resps {
+		out, err := s.pack(r, wire, m)
+		if err != nil {
+			return nil, false, true
+		}
+		msgs = append(msgs, out)
+	}
+	return msgs, false, true
+}
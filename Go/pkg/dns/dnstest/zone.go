@@ -0,0 +1,273 @@
+// Package dnstest provides an in-process authoritative DNS server for repeatable,
+// offline testing of DNS clients. It listens on loopback for every scheme NetTest
+// speaks (udp, tcp, tls, https, quic, h3) and can be scripted per query to delay,
+// drop, truncate or corrupt replies, answer with a wrong ID or an error rcode,
+// reset connections or fail TLS handshakes.
+//
+//	srv := dnstest.NewServer(zone)
+//	srv.SetScript(dnstest.Sequence(dnstest.Fault{Rcode: dns.RcodeServerFailure}, dnstest.Fault{Drop: true}))
+//	url, _ := srv.Listen("tls", "")
+//	defer srv.Close()
+package dnstest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain bounds CNAME chasing inside a zone, guarding against loops.
+const maxCNAMEChain = 8
+
+// Zone is one authoritative zone loaded from RFC 1035 master file text.
+type Zone struct {
+	Origin string
+
+	soa   *dns.SOA
+	names map[string][]dns.RR // lower-cased owner name -> records
+}
+
+// ParseZone reads a zone in master file format. origin is used for relative names
+// and as the zone apex; when empty, the apex is taken from the SOA record (the text
+// must then use absolute names or $ORIGIN). file is only used in error messages and $INCLUDE.
+func ParseZone(r io.Reader, origin, file string) (*Zone, error) {
+	if origin != "" {
+		origin = strings.ToLower(dns.Fqdn(origin))
+	}
+	z := &Zone{Origin: origin, names: make(map[string][]dns.RR)}
+	zp := dns.NewZoneParser(r, origin, file)
+	zp.SetIncludeAllowed(file != "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		if soa, ok := rr.(*dns.SOA); ok {
+			if z.soa != nil {
+				return nil, fmt.Errorf("%s: more than one SOA record", file)
+			}
+			z.soa = soa
+		}
+		z.names[name] = append(z.names[name], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.soa == nil {
+		return nil, fmt.Errorf("%s: zone %s has no SOA record", file, origin)
+	}
+	apex := strings.ToLower(z.soa.Hdr.Name)
+	if z.Origin == "" {
+		z.Origin = apex
+	}
+	if apex != z.Origin {
+		return nil, fmt.Errorf("%s: SOA at %s, want zone apex %s", file, z.soa.Hdr.Name, z.Origin)
+	}
+	for name := range z.names {
+		if !dns.IsSubDomain(z.Origin, name) {
+			return nil, fmt.Errorf("%s: record %s is outside zone %s", file, name, z.Origin)
+		}
+	}
+	return z, nil
+}
+
+// LoadZoneFile parses the zone file at path.
+func LoadZoneFile(path, origin string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseZone(f, origin, path)
+}
+
+// ParseZoneString parses zone text held in memory.
+func ParseZoneString(text, origin string) (*Zone, error) {
+	return ParseZone(strings.NewReader(text), origin, "")
+}
+
+// Contains reports whether name is at or below the zone apex.
+func (z *Zone) Contains(name string) bool {
+	return dns.IsSubDomain(z.Origin, strings.ToLower(dns.Fqdn(name)))
+}
+
+// Answer builds the authoritative response to r (RFC 1034 §4.3.2): referrals at
+// delegation points, CNAME chains within the zone, wildcard synthesis, and
+// NXDOMAIN/NODATA with the SOA in the authority section.
+func (z *Zone) Answer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return m
+	}
+	q := r.Question[0]
+	if !z.Contains(q.Name) {
+		m.Rcode = dns.RcodeRefused
+		return m
+	}
+	m.Authoritative = true
+
+	name := strings.ToLower(q.Name)
+	for i := 0; ; i++ {
+		if cut := z.delegation(name); cut != "" {
+			// Referral: the child zone is not ours to answer.
+			if len(m.Answer) == 0 {
+				m.Authoritative = false
+			}
+			m.Ns = append(m.Ns, z.names[cut]...)
+			m.Extra = append(m.Extra, z.glue(z.names[cut])...)
+			return m
+		}
+		rrs, owner, ok := z.lookup(name)
+		if !ok {
+			if len(m.Answer) == 0 {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return m
+		}
+		var match, cname []dns.RR
+		for _, rr := range rrs {
+			switch t := rr.Header().Rrtype; {
+			case t == q.Qtype || (q.Qtype == dns.TypeANY && t != dns.TypeRRSIG):
+				match = append(match, rr)
+			case t == dns.TypeCNAME:
+				cname = append(cname, rr)
+			}
+		}
+		switch {
+		case len(match) > 0:
+			m.Answer = append(m.Answer, synthesize(match, owner)...)
+			return m
+		case len(cname) > 0 && i < maxCNAMEChain:
+			m.Answer = append(m.Answer, synthesize(cname[:1], owner)...)
+			target := strings.ToLower(cname[0].(*dns.CNAME).Target)
+			if !z.Contains(target) {
+				// Out-of-zone target: the resolver continues from here.
+				return m
+			}
+			name = target
+		default:
+			// NODATA
+			m.Ns = append(m.Ns, z.negativeSOA())
+			return m
+		}
+	}
+}
+
+// Transfer lists the zone as AXFR sends it (RFC 5936 §2.2): the SOA, every
+// other record in name order, then the SOA again.
+func (z *Zone) Transfer() []dns.RR {
+	names := make([]string, 0, len(z.names))
+	for name := range z.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := []dns.RR{z.soa}
+	for _, name := range names {
+		for _, rr := range z.names[name] {
+			if rr != dns.RR(z.soa) {
+				out = append(out, rr)
+			}
+		}
+	}
+	return append(out, z.soa)
+}
+
+// lookup returns the records for name, synthesising from a wildcard (RFC 4592) when
+// name does not exist. owner is the name the returned records should carry.
+// ok is false only for NXDOMAIN; an empty non-terminal exists with no records.
+func (z *Zone) lookup(name string) (rrs []dns.RR, owner string, ok bool) {
+	if rrs, exists := z.names[name]; exists {
+		return rrs, name, true
+	}
+	if z.hasDescendants(name) {
+		return nil, name, true
+	}
+	// The closest encloser is the longest existing ancestor; only its wildcard applies.
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		if !dns.IsSubDomain(z.Origin, encloser) {
+			break
+		}
+		if _, exists := z.names[encloser]; !exists && !z.hasDescendants(encloser) {
+			continue
+		}
+		if wild, exists := z.names["*."+encloser]; exists {
+			return wild, name, true
+		}
+		break
+	}
+	return nil, name, false
+}
+
+// hasDescendants reports whether name is an empty non-terminal.
+func (z *Zone) hasDescendants(name string) bool {
+	for n := range z.names {
+		if n != name && dns.IsSubDomain(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// delegation returns the highest zone cut at or above name (below the apex), if any.
+func (z *Zone) delegation(name string) string {
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		cut := dns.Fqdn(strings.Join(labels[i:], "."))
+		if cut == z.Origin || !dns.IsSubDomain(z.Origin, cut) {
+			continue
+		}
+		for _, rr := range z.names[cut] {
+			if rr.Header().Rrtype == dns.TypeNS {
+				return cut
+			}
+		}
+	}
+	return ""
+}
+
+// glue returns in-zone address records for the NS targets.
+func (z *Zone) glue(ns []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range ns {
+		n, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		for _, a := range z.names[strings.ToLower(n.Ns)] {
+			if t := a.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// negativeSOA is the SOA for negative answers, its TTL capped by MINIMUM (RFC 2308 §3).
+func (z *Zone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
+// synthesize copies rrs with owner set to name (a no-op unless they came from a wildcard).
+func synthesize(rrs []dns.RR, name string) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if strings.EqualFold(rr.Header().Name, name) {
+			out = append(out, rr)
+			continue
+		}
+		c := dns.Copy(rr)
+		c.Header().Name = name
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+package dnstest
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const answerZone = `$TTL 300
+@              SOA    ns1 hostmaster 1 3600 600 86400 60
+@              NS     ns1
+ns1            A      192.0.2.53
+www            A      192.0.2.1
+www            TXT    "hello"
+alias          CNAME  mid
+mid            CNAME  www
+ext            CNAME  www.example.org.
+loop1          CNAME  loop2
+loop2          CNAME  loop1
+*.wild         A      192.0.2.10
+host.sub.wild  A      192.0.2.11
+a.b.ent        A      192.0.2.12
+sub            NS     ns.sub
+ns.sub         A      192.0.2.54
+ns.sub         AAAA   2001:db8::54
+`
+
+func answerFor(t *testing.T, z *Zone, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	return z.Answer(q)
+}
+
+func TestZoneAnswer(t *testing.T) {
+	z, err := ParseZoneString(answerZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		rcode int
+		aa    bool
+		// answer owners in order; ns and extra are record counts
+		answer []string
+		ns     int
+		extra  int
+	}{
+		{"exact", "www.example.test.", dns.TypeA, dns.RcodeSuccess, true, []string{"www.example.test."}, 0, 0},
+		{"case insensitive", "WWW.Example.Test.", dns.TypeA, dns.RcodeSuccess, true, []string{"www.example.test."}, 0, 0},
+		{"any", "www.example.test.", dns.TypeANY, dns.RcodeSuccess, true, []string{"www.example.test.", "www.example.test."}, 0, 0},
+		{"nodata", "www.example.test.", dns.TypeAAAA, dns.RcodeSuccess, true, nil, 1, 0},
+		{"nxdomain", "nope.example.test.", dns.TypeA, dns.RcodeNameError, true, nil, 1, 0},
+		{"out of zone", "www.example.org.", dns.TypeA, dns.RcodeRefused, false, nil, 0, 0},
+		{"cname chain", "alias.example.test.", dns.TypeA, dns.RcodeSuccess, true,
+			[]string{"alias.example.test.", "mid.example.test.", "www.example.test."}, 0, 0},
+		{"cname query", "alias.example.test.", dns.TypeCNAME, dns.RcodeSuccess, true, []string{"alias.example.test."}, 0, 0},
+		{"cname out of zone", "ext.example.test.", dns.TypeA, dns.RcodeSuccess, true, []string{"ext.example.test."}, 0, 0},
+		{"wildcard", "foo.wild.example.test.", dns.TypeA, dns.RcodeSuccess, true, []string{"foo.wild.example.test."}, 0, 0},
+		{"wildcard deep", "a.b.wild.example.test.", dns.TypeA, dns.RcodeSuccess, true, []string{"a.b.wild.example.test."}, 0, 0},
+		{"wildcard nodata", "foo.wild.example.test.", dns.TypeMX, dns.RcodeSuccess, true, nil, 1, 0},
+		// sub.wild exists as an empty non-terminal, so it is the closest encloser and has no wildcard
+		{"wildcard blocked by encloser", "x.sub.wild.example.test.", dns.TypeA, dns.RcodeNameError, true, nil, 1, 0},
+		{"empty non-terminal", "b.ent.example.test.", dns.TypeA, dns.RcodeSuccess, true, nil, 1, 0},
+		{"empty non-terminal parent", "ent.example.test.", dns.TypeA, dns.RcodeSuccess, true, nil, 1, 0},
+		{"below empty non-terminal", "c.b.ent.example.test.", dns.TypeA, dns.RcodeNameError, true, nil, 1, 0},
+		{"referral", "www.sub.example.test.", dns.TypeA, dns.RcodeSuccess, false, nil, 1, 2},
+		{"referral at cut", "sub.example.test.", dns.TypeNS, dns.RcodeSuccess, false, nil, 1, 2},
+		{"referral for glue name", "ns.sub.example.test.", dns.TypeA, dns.RcodeSuccess, false, nil, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := answerFor(t, z, tt.qname, tt.qtype)
+			if m.Rcode != tt.rcode {
+				t.Fatalf("rcode %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if m.Authoritative != tt.aa {
+				t.Fatalf("AA=%v, want %v", m.Authoritative, tt.aa)
+			}
+			if len(m.Answer) != len(tt.answer) {
+				t.Fatalf("answer %v, want owners %v", m.Answer, tt.answer)
+			}
+			for i, rr := range m.Answer {
+				if rr.Header().Name != tt.answer[i] {
+					t.Fatalf("answer[%d] owner %s, want %s", i, rr.Header().Name, tt.answer[i])
+				}
+			}
+			if len(m.Ns) != tt.ns || len(m.Extra) != tt.extra {
+				t.Fatalf("authority %d, additional %d records; want %d, %d", len(m.Ns), len(m.Extra), tt.ns, tt.extra)
+			}
+		})
+	}
+}
+
+// Wildcard answers carry the query name but leave the zone's records untouched.
+func TestZoneWildcardDoesNotMutate(t *testing.T) {
+	z, err := ParseZoneString(answerZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerFor(t, z, "foo.wild.example.test.", dns.TypeA)
+	if got := z.names["*.wild.example.test."][0].Header().Name; got != "*.wild.example.test." {
+		t.Fatalf("wildcard owner rewritten to %s", got)
+	}
+}
+
+func TestZoneCNAMELoop(t *testing.T) {
+	z, err := ParseZoneString(answerZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := answerFor(t, z, "loop1.example.test.", dns.TypeA)
+	if len(m.Answer) != maxCNAMEChain {
+		t.Fatalf("loop followed for %d CNAMEs, want %d", len(m.Answer), maxCNAMEChain)
+	}
+	if m.Rcode != dns.RcodeSuccess || len(m.Ns) != 1 {
+		t.Fatalf("loop ended with rcode %s and %d authority records", dns.RcodeToString[m.Rcode], len(m.Ns))
+	}
+}
+
+// A CNAME to a missing in-zone name keeps the chain and reports NOERROR (RFC 6604).
+func TestZoneCNAMEToMissing(t *testing.T) {
+	z, err := ParseZoneString(answerZone+"dangling CNAME gone\n", "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := answerFor(t, z, "dangling.example.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 || len(m.Ns) != 1 {
+		t.Fatalf("got rcode %s, %d answers, %d authority", dns.RcodeToString[m.Rcode], len(m.Answer), len(m.Ns))
+	}
+}
+
+func TestZoneReferralGlue(t *testing.T) {
+	z, err := ParseZoneString(answerZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := answerFor(t, z, "www.sub.example.test.", dns.TypeA)
+	ns, ok := m.Ns[0].(*dns.NS)
+	if !ok || ns.Hdr.Name != "sub.example.test." || ns.Ns != "ns.sub.example.test." {
+		t.Fatalf("authority %v, want the sub NS set", m.Ns)
+	}
+	types := map[uint16]bool{}
+	for _, rr := range m.Extra {
+		if rr.Header().Name != "ns.sub.example.test." {
+			t.Fatalf("glue for %s", rr.Header().Name)
+		}
+		types[rr.Header().Rrtype] = true
+	}
+	if !types[dns.TypeA] || !types[dns.TypeAAAA] {
+		t.Fatalf("glue %v, want A and AAAA", m.Extra)
+	}
+}
+
+// Negative answers carry the SOA with its TTL capped by MINIMUM (RFC 2308 §3).
+func TestZoneNegativeSOATTL(t *testing.T) {
+	for _, tt := range []struct {
+		zone string
+		ttl  uint32
+	}{
+		{answerZone, 60},
+		{"$TTL 30\n@ SOA ns1 hostmaster 1 3600 600 86400 60\n", 30},
+	} {
+		z, err := ParseZoneString(tt.zone, "example.test.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, qname := range []string{"nope.example.test.", "example.test."} {
+			m := answerFor(t, z, qname, dns.TypeMX)
+			if len(m.Ns) != 1 {
+				t.Fatalf("%s: %d authority records, want the SOA", qname, len(m.Ns))
+			}
+			soa, ok := m.Ns[0].(*dns.SOA)
+			if !ok || soa.Hdr.Ttl != tt.ttl {
+				t.Fatalf("%s: authority %v, want SOA with TTL %d", qname, m.Ns[0], tt.ttl)
+			}
+		}
+		if z.soa.Hdr.Ttl < tt.ttl {
+			t.Fatal("zone SOA modified")
+		}
+	}
+}
+
+func TestZoneTransfer(t *testing.T) {
+	z, err := ParseZoneString(answerZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs := z.Transfer()
+	if len(rrs) != 16+1 {
+		t.Fatalf("%d records, want the 16 of the zone and the closing SOA", len(rrs))
+	}
+	first, ok1 := rrs[0].(*dns.SOA)
+	last, ok2 := rrs[len(rrs)-1].(*dns.SOA)
+	if !ok1 || !ok2 || first != last {
+		t.Fatalf("transfer framed by %v and %v, want the SOA", rrs[0], rrs[len(rrs)-1])
+	}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			t.Fatalf("SOA inside the transfer: %v", rr)
+		}
+	}
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	for name, text := range map[string]string{
+		"two SOAs":     "@ 60 SOA ns1 h 1 1 1 1 1\n@ 60 SOA ns1 h 2 1 1 1 1\n",
+		"out of zone":  "@ 60 SOA ns1 h 1 1 1 1 1\nwww.example.org. 60 A 192.0.2.1\n",
+		"syntax":       "@ 60 SOA ns1 h 1 1 1 1 1\nwww 60 A not-an-ip\n",
+		"SOA not apex": "sub 60 SOA ns1 h 1 1 1 1 1\n",
+	} {
+		if _, err := ParseZoneString(text, "example.test."); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"nettest/pkg/dns/tracing"
+	"nettest/pkg/dns/transport"
+
+	"github.com/miekg/dns"
+)
+
+// msgSigner signs an outgoing message into its wire form and checks the
+// signature of the response. TSIG and SIG(0) implement it.
+type msgSigner interface {
+	sign(m *dns.Msg) ([]byte, error)
+	check(resp *dns.Msg, wire []byte)
+	report(res *exchangeResult)
+}
+
+// exchangeResult is the outcome of an exchange done outside sing-dns.
+type exchangeResult struct {
+	msg  *dns.Msg
+	rtt  time.Duration
+	tsig *TsigStatus
+	sig0 *Sig0Status
+}
+
+// dialClassic connects to d.server over network, which is udp, tcp or tcp-tls
+// (default ports 53 and 853), through u's dialer stack. For tcp-tls, tlsCfg is
+// cloned and gets the server name and the "dot" ALPN token.
+func (d *DnsRequestType) dialClassic(ctx context.Context, u *upstream, network string, tlsCfg *tls.Config) (net.Conn, error) {
+	addr := GetNetAddress(d.server)
+	switch network {
+	case "udp", "tcp":
+		addr = withDefaultPort(addr, "53")
+	case "tcp-tls":
+		addr = withDefaultPort(addr, "853")
+	default:
+		return nil, fmt.Errorf("net scheme %s not supported here, want udp, tcp or tls", network)
+	}
+	if network == "udp" {
+		pd, ok := u.dialer.(transport.PacketDialer)
+		if !ok {
+			return nil, transport.ErrUDPUnsupported
+		}
+		pc, err := pd.DialPacket(ctx, "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, ok := pc.(net.Conn)
+		if !ok {
+			_ = pc.Close()
+			return nil, transport.ErrUDPUnsupported
+		}
+		return conn, nil
+	}
+	conn, err := u.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil || network != "tcp-tls" {
+		return conn, err
+	}
+	cfg := &tls.Config{}
+	if tlsCfg != nil {
+		cfg = tlsCfg.Clone()
+	}
+	cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	if d.sni != "" {
+		cfg.ServerName = d.sni
+	}
+	cfg.NextProtos = []string{"dot"}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// exchangeSigned sends m over udp, tcp, tcp-tls or https without going through
+// sing-dns, so the message goes out exactly as signed by s (which may be nil).
+// A signature that fails to verify is reported in the result, not as an error.
+func (d *DnsRequestType) exchangeSigned(m *dns.Msg, s msgSigner, insecure bool) (*exchangeResult, error) {
+	u, err := d.newUpstream()
+	if err != nil {
+		return nil, err
+	}
+	return d.exchangeWith(context.Background(), u, m, s, insecure)
+}
+
+// exchangeWith is exchangeSigned over an existing upstream. A truncated UDP
+// answer is retried over TCP with the same signed message; each attempt is a
+// dns.exchange span under ctx.
+func (d *DnsRequestType) exchangeWith(ctx context.Context, u *upstream, m *dns.Msg, s msgSigner, insecure bool) (*exchangeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	ctx = u.traceHops(ctx)
+	var (
+		wire []byte
+		err  error
+	)
+	if s != nil {
+		wire, err = s.sign(m)
+	} else {
+		wire, err = m.Pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, p, err := d.roundTrip(ctx, u, d.net, m.Id, wire, insecure)
+	if err == nil && d.net == "udp" && res.Truncated {
+		res, p, err = d.roundTrip(ctx, u, "tcp", m.Id, wire, insecure)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := &exchangeResult{msg: res, rtt: time.Since(start)}
+	if s != nil {
+		s.check(out.msg, p)
+		s.report(out)
+	}
+	return out, nil
+}
+
+// roundTrip sends wire over network and returns the response, unpacked and raw.
+func (d *DnsRequestType) roundTrip(ctx context.Context, u *upstream, network string, id uint16, wire []byte, insecure bool) (*dns.Msg, []byte, error) {
+	_, span := tracing.Start(ctx, "dns.exchange", tracing.String("network.transport", network))
+	defer span.End()
+	var (
+		p   []byte
+		err error
+	)
+	if network == "https" {
+		p, err = d.postDoH(ctx, u, wire, insecure)
+	} else {
+		p, err = d.roundTripClassic(ctx, u, network, id, wire, insecure)
+	}
+	if err != nil {
+		span.SetError(err)
+		return nil, nil, err
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(p); err != nil {
+		span.SetError(err)
+		return nil, nil, err
+	}
+	if network == "https" {
+		msg.Id = id // RFC 8484 §4.1: the ID may be 0 on the wire
+	}
+	span.SetAttributes(tracing.String("dns.rcode", dns.RcodeToString[msg.Rcode]), tracing.Bool("dns.truncated", msg.Truncated))
+	return msg, p, nil
+}
+
+// roundTripClassic writes wire over a classic connection and returns the raw
+// response with matching ID (mismatched UDP datagrams are skipped).
+func (d *DnsRequestType) roundTripClassic(ctx context.Context, u *upstream, network string, id uint16, wire []byte, insecure bool) ([]byte, error) {
+	conn, err := d.dialClassic(ctx, u, network, &tls.Config{InsecureSkipVerify: insecure})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	co := &dns.Conn{Conn: conn, UDPSize: dns.MaxMsgSize}
+	if _, err := co.Write(wire); err != nil {
+		return nil, err
+	}
+	var h dns.Header
+	for {
+		p, err := co.ReadMsgHeader(&h)
+		if err != nil {
+			return nil, err
+		}
+		if h.Id == id {
+			return p, nil
+		}
+		if network != "udp" {
+			return nil, dns.ErrId
+		}
+	}
+}
+
+// postDoH sends wire as an RFC 8484 POST through u's dialer stack.
+func (d *DnsRequestType) postDoH(ctx context.Context, u *upstream, wire []byte, insecure bool) ([]byte, error) {
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return u.dialer.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig:   &tls.Config{ServerName: d.sni, InsecureSkipVerify: insecure},
+		ForceAttemptHTTP2: true,
+	}
+	defer tr.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.server, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+}
+
+// signerOf returns s as a msgSigner, or nil when it is unset, avoiding a
+// non-nil interface around a nil pointer.
+func signerOf(tsig *tsigKey, sig0 *sig0Key) msgSigner {
+	switch {
+	case tsig != nil:
+		return tsig
+	case sig0 != nil:
+		return sig0
+	}
+	return nil
+}
+
+var errSignedScheme = errors.New("signed queries need udp, tcp, tls or https")
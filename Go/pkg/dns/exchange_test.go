@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"crypto"
+	"encoding/base64"
+	"sync"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+
+	"github.com/miekg/dns"
+)
+
+var testTsigSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+// signedRequest is a query for www.example.test signed with tsig or sig0.
+func signedRequest(server string, tsig *TsigOptions, sig0 *Sig0Options) *DnsRequestType {
+	return DnsRequestOptions{Server: server, Qname: "www.example.test", Qtype: "A", Qclass: "IN", TimeoutMs: 2000, Tsig: tsig, Sig0: sig0}.request()
+}
+
+func TestSignedTSIG(t *testing.T) {
+	s, urls := listenAll(t)
+	s.SetSigner(dnstest.TSIG{Name: "key.example.", Secret: testTsigSecret})
+	for _, scheme := range []string{"udp", "tcp", "tls", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			res, err := signedRequest(urls[scheme], &TsigOptions{Name: "key.example", Secret: testTsigSecret}, nil).Request()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.answer.Rcode != dns.RcodeSuccess || len(res.answer.Answer) != 1 {
+				t.Fatalf("answer %v", res.answer)
+			}
+			if st := res.tsig; st == nil || st.Signed != 1 || st.Messages != 1 || st.Error != "" {
+				t.Fatalf("tsig status %+v", st)
+			}
+		})
+	}
+	t.Run("bad secret", func(t *testing.T) {
+		wrong := base64.StdEncoding.EncodeToString([]byte("not the shared secret"))
+		res, err := signedRequest(urls["tcp"], &TsigOptions{Name: "key.example", Secret: wrong}, nil).Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.answer.Rcode != dns.RcodeNotAuth || res.tsig.Error != "BADSIG" || res.tsig.Signed != 0 {
+			t.Fatalf("rcode %s, tsig %+v", dns.RcodeToString[res.answer.Rcode], res.tsig)
+		}
+	})
+}
+
+func newSig0Key(t *testing.T, name string) (*dns.KEY, crypto.Signer) {
+	t.Helper()
+	k := &dns.KEY{DNSKEY: dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET},
+		Algorithm: dns.ECDSAP256SHA256,
+		Protocol:  3,
+	}}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, priv.(crypto.Signer)
+}
+
+func TestSignedSIG0(t *testing.T) {
+	s, urls := listenAll(t)
+	client, clientPriv := newSig0Key(t, "client.example.")
+	server, serverPriv := newSig0Key(t, "server.example.")
+	s.SetSigner(dnstest.SIG0{Key: server, Signer: serverPriv, ClientKey: client})
+	opts := &Sig0Options{Key: client.String(), PrivateKey: client.PrivateKeyString(clientPriv), ServerKey: server.String()}
+	for _, scheme := range []string{"udp", "tcp", "tls", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			res, err := signedRequest(urls[scheme], nil, opts).Request()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.answer.Rcode != dns.RcodeSuccess || len(res.answer.Answer) != 1 {
+				t.Fatalf("answer %v", res.answer)
+			}
+			if st := res.sig0; st == nil || !st.Signed || !st.Verified || st.Error != "" || st.KeyTag != client.KeyTag() {
+				t.Fatalf("sig0 status %+v", st)
+			}
+		})
+	}
+	t.Run("unknown key", func(t *testing.T) {
+		other, otherPriv := newSig0Key(t, "client.example.")
+		res, err := signedRequest(urls["tcp"], nil, &Sig0Options{Key: other.String(), PrivateKey: other.PrivateKeyString(otherPriv), ServerKey: server.String()}).Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.answer.Rcode != dns.RcodeNotAuth || res.sig0.Signed || res.sig0.Error != "response not signed" {
+			t.Fatalf("rcode %s, sig0 %+v", dns.RcodeToString[res.answer.Rcode], res.sig0)
+		}
+	})
+}
+
+// A truncated signed answer is fetched again over TCP, and the client subnet
+// goes out inside the signed message.
+func TestSignedTruncatedRetriesOverTCP(t *testing.T) {
+	z, err := dnstest.ParseZoneString(testZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dnstest.NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSigner(dnstest.TSIG{Name: "key.example.", Secret: testTsigSecret})
+	var (
+		mu   sync.Mutex
+		seen []dnstest.Query
+	)
+	truncate := dnstest.OnNet(dnstest.Always(dnstest.Fault{Truncate: true}), "udp")
+	s.SetScript(func(q dnstest.Query) dnstest.Fault {
+		mu.Lock()
+		seen = append(seen, q)
+		mu.Unlock()
+		return truncate(q)
+	})
+
+	req := signedRequest("udp://"+s.Addr(), &TsigOptions{Name: "key.example", Secret: testTsigSecret}, nil)
+	req.clientSubnet = "198.51.100.0/24"
+	res, err := req.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.answer.Truncated || len(res.answer.Answer) != 1 {
+		t.Fatalf("answer %v", res.answer)
+	}
+	if res.tsig.Signed != 1 || res.tsig.Error != "" {
+		t.Fatalf("tsig status %+v", res.tsig)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 || seen[0].Net != "udp" || seen[1].Net != "tcp" {
+		t.Fatalf("server saw %d queries, want udp then tcp", len(seen))
+	}
+	opt := seen[1].Msg.IsEdns0()
+	if opt == nil {
+		t.Fatal("no EDNS in the signed query")
+	}
+	var ecs *dns.EDNS0_SUBNET
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			ecs = e
+		}
+	}
+	if ecs == nil || ecs.SourceNetmask != 24 || ecs.Address.String() != "198.51.100.0" {
+		t.Fatalf("client subnet %v", ecs)
+	}
+}
+
+func TestSignedRejectsCache(t *testing.T) {
+	req := signedRequest("udp://127.0.0.1:53", &TsigOptions{Name: "key.example", Secret: testTsigSecret}, nil)
+	req.cache = true
+	if _, err := req.Request(); err == nil {
+		t.Fatal("signed query with cache succeeded")
+	}
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"nettest/pkg/dns/dnstest"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// checkErrJSON checks the error object handed to FFI callers for err.
+func checkErrJSON(t *testing.T, err error) {
+	t.Helper()
+	var out struct {
+		Code    int      `json:"code"`
+		Message string   `json:"message"`
+		Type    string   `json:"type"`
+		Where   string   `json:"where"`
+		Causes  []string `json:"causes"`
+	}
+	if jerr := json.Unmarshal([]byte(utils.BuildErrJSON(err)), &out); jerr != nil {
+		t.Fatalf("BuildErrJSON: %v", jerr)
+	}
+	if out.Code != -1 || out.Message != err.Error() || out.Type == "" || out.Where == "" {
+		t.Fatalf("BuildErrJSON: %+v for %v", out, err)
+	}
+	if len(out.Causes) == 0 || out.Causes[0] != err.Error() {
+		t.Fatalf("BuildErrJSON causes %q for %v", out.Causes, err)
+	}
+}
+
+// Each fault, over every listener, must end in the expected error class; an
+// empty class means the query succeeds and check inspects the reply.
+func TestRequestFaults(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	tests := []struct {
+		name  string
+		fault dnstest.Fault
+		class map[string]string // by scheme; schemes left out do not apply
+		check func(m *dns.Msg) bool
+	}{
+		{
+			name:  "delay",
+			fault: dnstest.Fault{Delay: 2 * timeout},
+			class: map[string]string{"udp": "timeout", "tcp": "timeout", "tls": "timeout", "https": "timeout", "quic": "timeout", "h3": "timeout"},
+		},
+		{
+			name:  "drop",
+			fault: dnstest.Fault{Drop: true},
+			class: map[string]string{"udp": "timeout", "tcp": "timeout", "tls": "timeout", "https": "timeout", "quic": "timeout", "h3": "timeout"},
+		},
+		{
+			// UDP has nothing to reset and drops instead. The HTTP/2 stream
+			// error type is unexported, so DoH resets stay unclassified.
+			name:  "reset",
+			fault: dnstest.Fault{Reset: true},
+			class: map[string]string{"udp": "timeout", "tcp": "reset", "tls": "reset", "https": "other", "quic": "quic", "h3": "quic"},
+		},
+		{
+			// sing-dns closes the UDP socket on a reply it cannot unpack,
+			// cancelling the exchange without the parse error.
+			name:  "malformed",
+			fault: dnstest.Fault{Malformed: true},
+			class: map[string]string{"udp": "closed", "tcp": "bad_response", "tls": "bad_response", "https": "bad_response", "quic": "bad_response", "h3": "bad_response"},
+		},
+		{
+			// UDP matches replies by ID and keeps waiting; the other schemes
+			// pair the reply with its connection or stream.
+			name:  "wrong id",
+			fault: dnstest.Fault{WrongID: true},
+			class: map[string]string{"udp": "timeout", "tcp": "", "tls": "", "https": "", "quic": "", "h3": ""},
+			check: func(m *dns.Msg) bool { return len(m.Answer) == 1 },
+		},
+		{
+			name:  "truncate",
+			fault: dnstest.Fault{Truncate: true},
+			class: map[string]string{"tcp": "", "tls": "", "https": "", "quic": "", "h3": ""},
+			check: func(m *dns.Msg) bool { return m.Truncated && len(m.Answer) == 0 },
+		},
+		{
+			name:  "rcode",
+			fault: dnstest.Fault{Rcode: dns.RcodeServerFailure},
+			class: map[string]string{"udp": "", "tcp": "", "tls": "", "https": "", "quic": "", "h3": ""},
+			check: func(m *dns.Msg) bool { return m.Rcode == dns.RcodeServerFailure && len(m.Answer) == 0 },
+		},
+		{
+			name:  "handshake",
+			fault: dnstest.Fault{HandshakeFail: true},
+			class: map[string]string{"tls": "tls", "https": "tls", "quic": "quic", "h3": "quic"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, urls := listenAll(t)
+			s.SetScript(dnstest.Always(tt.fault))
+			for _, scheme := range testSchemes {
+				want, ok := tt.class[scheme]
+				if !ok {
+					continue
+				}
+				r := testRequest(urls[scheme], "www.example.test", "A")
+				r.timeout = timeout
+				start := time.Now()
+				res, err := r.Request()
+				if want == "" {
+					if err != nil {
+						t.Fatalf("%s: %v", scheme, err)
+					}
+					if !tt.check(res.answer) {
+						t.Fatalf("%s: unexpected reply\n%v", scheme, res.answer)
+					}
+					continue
+				}
+				if err == nil {
+					t.Fatalf("%s: query succeeded, want a %s error", scheme, want)
+				}
+				if got := errorClass(err); got != want {
+					t.Fatalf("%s: error class %s, want %s (%v)", scheme, got, want, err)
+				}
+				if d := time.Since(start); d > timeout+time.Second {
+					t.Fatalf("%s: failed after %v with a %v timeout", scheme, d, timeout)
+				}
+				checkErrJSON(t, err)
+			}
+		})
+	}
+}
+
+// A truncated UDP reply is retried over TCP on the same port.
+func TestRequestTruncatedFallsBackToTCP(t *testing.T) {
+	z, err := dnstest.ParseZoneString(testZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dnstest.NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetScript(dnstest.OnNet(dnstest.Always(dnstest.Fault{Truncate: true}), "udp"))
+	res, err := testRequest(s.URL("udp"), "www.example.test", "A").Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.answer.Truncated || len(res.answer.Answer) != 1 {
+		t.Fatalf("got a truncated or empty reply\n%v", res.answer)
+	}
+	if n := s.Queries(); n != 2 {
+		t.Fatalf("server saw %d queries, want the UDP one and its TCP retry", n)
+	}
+}
@@ -0,0 +1,486 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/dnstest"
+	"nettest/pkg/dns/singdns"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// defaultForwarderLogSize is how many recent queries a forwarder keeps when log_size is unset.
+const defaultForwarderLogSize = 1000
+
+// ForwarderOptions configures a local DNS forwarder. The embedded request options
+// describe the upstream (server, proxies, bootstrap, sni, client_subnet, ...);
+// qname/qtype/qclass are ignored. When Zone is set, answers come from it instead.
+type ForwarderOptions struct {
+	DnsRequestOptions
+	// Listen is the local address, e.g. "127.0.0.1:5353"; port 0 picks a free port.
+	Listen string `json:"listen"`
+	// Network is "udp", "tcp", "tls", "https", "h3", "quic" or empty for udp+tcp on the same port.
+	Network string `json:"network"`
+	// Listeners opens several endpoints at once and overrides Listen/Network.
+	Listeners []ForwarderListener `json:"listeners"`
+	LogSize   int                 `json:"log_size"`
+
+	// CertFile/KeyFile are PEM files for the encrypted listeners; when empty a
+	// self-signed certificate is generated for TLSHosts (default localhost, 127.0.0.1, ::1).
+	CertFile string   `json:"cert_file"`
+	KeyFile  string   `json:"key_file"`
+	TLSHosts []string `json:"tls_hosts"`
+
+	// Zone is RFC 1035 zone file text answered authoritatively instead of
+	// forwarding; ZoneOrigin defaults to the SOA owner. Text without an SOA gets
+	// one made up at ZoneOrigin, or at the root when that is empty.
+	Zone       string `json:"zone"`
+	ZoneOrigin string `json:"zone_origin"`
+}
+
+// ForwarderListener is one listening endpoint.
+type ForwarderListener struct {
+	Network string `json:"network"` // udp, tcp, tls (DoT), https (DoH over HTTP/2), h3 (DoH over HTTP/3), quic (DoQ)
+	Listen  string `json:"listen"`
+	Path    string `json:"path"` // DoH path, default "/dns-query"
+}
+
+// ForwarderLogEntry is one forwarded query.
+type ForwarderLogEntry struct {
+	Time    time.Time     `json:"time"`
+	Client  string        `json:"client"`
+	Network string        `json:"network"`
+	Qname   string        `json:"qname"`
+	Qtype   string        `json:"qtype"`
+	Rcode   string        `json:"rcode"`
+	Answers int           `json:"answers"`
+	Latency time.Duration `json:"latency"`
+	Cache   string        `json:"cache,omitempty"` // hit, miss or stale when the upstream has "cache":true
+	Error   string        `json:"error,omitempty"`
+}
+
+// ForwarderSummary summarises a forwarder since it started.
+type ForwarderSummary struct {
+	ID         string            `json:"id"`
+	Listen     []string          `json:"listen"`
+	Upstream   string            `json:"upstream"`
+	Started    time.Time         `json:"started"`
+	Uptime     time.Duration     `json:"uptime"`
+	Queries    uint64            `json:"queries"`
+	Errors     uint64            `json:"errors"`
+	Rcodes     map[string]uint64 `json:"rcodes"`
+	AvgLatency time.Duration     `json:"avg_latency"`
+	MaxLatency time.Duration     `json:"max_latency"`
+}
+
+// Forwarder answers local queries by forwarding them to one upstream transport,
+// or from a static zone.
+type Forwarder struct {
+	id       string
+	upstream string
+	answer   func(ctx context.Context, r *dns.Msg) (*dns.Msg, error)
+	timeout  time.Duration
+	cancel   context.CancelFunc
+	closers  []io.Closer
+	listen   []string
+	cert     *tls.Certificate
+	tlsCfg   *tls.Config
+	started  time.Time
+
+	mu      sync.Mutex
+	log     []ForwarderLogEntry // ring buffer
+	next    int
+	queries uint64
+	errors  uint64
+	rcodes  map[string]uint64
+	total   time.Duration
+	max     time.Duration
+}
+
+var forwarders = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*Forwarder
+}{byID: make(map[string]*Forwarder)}
+
+// StartForwarder builds the upstream transport (or loads the zone) and starts listening.
+func StartForwarder(opts ForwarderOptions) (*Forwarder, error) {
+	if opts.LogSize <= 0 {
+		opts.LogSize = defaultForwarderLogSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		timeout: 5 * time.Second,
+		cancel:  cancel,
+		started: time.Now(),
+		log:     make([]ForwarderLogEntry, 0, opts.LogSize),
+		rcodes:  make(map[string]uint64),
+	}
+	if err := f.setupAnswer(ctx, opts); err != nil {
+		f.shutdown()
+		return nil, err
+	}
+	if err := f.listenAll(opts); err != nil {
+		f.shutdown()
+		return nil, err
+	}
+
+	forwarders.mu.Lock()
+	forwarders.seq++
+	f.id = fmt.Sprintf("fwd-%d", forwarders.seq)
+	forwarders.byID[f.id] = f
+	forwarders.mu.Unlock()
+	return f, nil
+}
+
+func (f *Forwarder) setupAnswer(ctx context.Context, opts ForwarderOptions) error {
+	if strings.TrimSpace(opts.Zone) != "" {
+		z, err := parseForwarderZone(opts.Zone, opts.ZoneOrigin)
+		if err != nil {
+			return err
+		}
+		srv := dnstest.NewServer(z)
+		f.upstream = "zone:" + z.Origin
+		f.answer = func(_ context.Context, r *dns.Msg) (*dns.Msg, error) { return srv.Exchange(r), nil }
+		return nil
+	}
+	if strings.TrimSpace(opts.Server) == "" {
+		return errors.New("empty server")
+	}
+	req := opts.request()
+	if req.net == "mdns" {
+		return errors.New("mdns cannot be used as a forwarder upstream")
+	}
+	up, err := req.newUpstream()
+	if err != nil {
+		return err
+	}
+	t, err := req.newTransport(ctx, up.dialer)
+	if err != nil {
+		return err
+	}
+	f.closers = append(f.closers, t)
+	f.upstream = req.server
+	f.timeout = up.timeout
+	f.answer = t.Exchange
+	return nil
+}
+
+// parseForwarderZone parses zone text, adding an apex SOA when it has none so
+// plain record lists keep working as they did before zones required one.
+func parseForwarderZone(text, origin string) (*dnstest.Zone, error) {
+	apex := "."
+	if origin != "" {
+		apex = strings.ToLower(dns.Fqdn(origin))
+	}
+	zp := dns.NewZoneParser(strings.NewReader(text), apex, "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return dnstest.ParseZoneString(text, origin)
+		}
+	}
+	if zp.Err() != nil {
+		return dnstest.ParseZoneString(text, origin)
+	}
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: apex, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:      "invalid.",
+		Mbox:    "hostmaster.invalid.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	}
+	return dnstest.ParseZoneString(soa.String()+"\n"+text, apex)
+}
+
+// listenAll opens every listener. A TCP-based and a UDP-based listener given the same
+// listen string with port 0 share the port picked for the first, e.g. default udp+tcp.
+func (f *Forwarder) listenAll(opts ForwarderOptions) error {
+	listeners := opts.Listeners
+	if len(listeners) == 0 {
+		listen := opts.Listen
+		if listen == "" {
+			listen = "127.0.0.1:5353"
+		}
+		switch n := strings.ToLower(strings.TrimSpace(opts.Network)); n {
+		case "":
+			listeners = []ForwarderListener{{Network: "udp", Listen: listen}, {Network: "tcp", Listen: listen}}
+		default:
+			listeners = []ForwarderListener{{Network: n, Listen: listen}}
+		}
+	}
+	// listen string -> "udp"/"tcp" -> bound address
+	bound := make(map[string]map[string]string)
+	for _, l := range listeners {
+		network := strings.ToLower(strings.TrimSpace(l.Network))
+		if l.Listen == "" {
+			return fmt.Errorf("%s listener: empty listen address", network)
+		}
+		family := "tcp"
+		switch network {
+		case "udp", "h3", "quic":
+			family = "udp"
+		}
+		other := map[string]string{"udp": "tcp", "tcp": "udp"}[family]
+		addr := l.Listen
+		b := bound[l.Listen]
+		if b == nil {
+			b = make(map[string]string)
+			bound[l.Listen] = b
+		}
+		if _, taken := b[family]; !taken && b[other] != "" && strings.HasSuffix(addr, ":0") {
+			addr = b[other]
+		}
+		var (
+			actual string
+			err    error
+		)
+		switch network {
+		case "udp":
+			actual, err = f.listenUDP(addr)
+		case "tcp":
+			actual, err = f.listenTCP(addr, nil)
+		case "tls", "https", "h3", "quic":
+			var cfg *tls.Config
+			if cfg, err = f.tlsConfig(opts); err == nil {
+				actual, err = f.listenEncrypted(network, addr, l.Path, cfg)
+			}
+		default:
+			err = fmt.Errorf("invalid forwarder network %q, want udp, tcp, tls, https, h3 or quic", l.Network)
+		}
+		if err != nil {
+			return err
+		}
+		if _, taken := b[family]; !taken {
+			b[family] = actual
+		}
+	}
+	return nil
+}
+
+func (f *Forwarder) listenUDP(addr string) (string, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	f.serve(&dns.Server{PacketConn: pc, Handler: f.handler("udp")}, "udp://"+pc.LocalAddr().String())
+	return pc.LocalAddr().String(), nil
+}
+
+// listenTCP serves plain TCP, or DoT when cfg is set.
+func (f *Forwarder) listenTCP(addr string, cfg *tls.Config) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	actual := l.Addr().String()
+	if cfg != nil {
+		f.serve(&dns.Server{Listener: tls.NewListener(l, cfg), Net: "tcp-tls", Handler: f.handler("tls")}, "tls://"+actual)
+		return actual, nil
+	}
+	f.serve(&dns.Server{Listener: l, Handler: f.handler("tcp")}, "tcp://"+actual)
+	return actual, nil
+}
+
+func (f *Forwarder) serve(srv *dns.Server, addr string) {
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	f.closers = append(f.closers, closerFunc(srv.Shutdown))
+	f.listen = append(f.listen, addr)
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+}
+
+type closerFunc func() error
+
+func (c closerFunc) Close() error { return c() }
+
+func (f *Forwarder) handler(network string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := f.handle(network, w.RemoteAddr().String(), r)
+		if network == "udp" {
+			size := dns.MinMsgSize
+			if opt := r.IsEdns0(); opt != nil {
+				size = int(opt.UDPSize())
+			}
+			resp.Truncate(size)
+		}
+		_ = w.WriteMsg(resp)
+	})
+}
+
+// handle answers r from the upstream or zone, answering SERVFAIL on failure, and logs it.
+func (f *Forwarder) handle(network, client string, r *dns.Msg) *dns.Msg {
+	entry := ForwarderLogEntry{Time: time.Now(), Client: client, Network: network}
+	if len(r.Question) > 0 {
+		entry.Qname = r.Question[0].Name
+		entry.Qtype = dns.TypeToString[r.Question[0].Qtype]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	ctx, trace := singdns.WithCacheTrace(ctx)
+	start := time.Now()
+	resp, err := f.answer(ctx, r.Copy())
+	entry.Latency = time.Since(start)
+	entry.Cache = string(trace.Status)
+	if err != nil {
+		entry.Error = err.Error()
+		resp = new(dns.Msg)
+		resp.SetRcode(r, dns.RcodeServerFailure)
+	}
+	resp.Id = r.Id
+	entry.Rcode = dns.RcodeToString[resp.Rcode]
+	entry.Answers = len(resp.Answer)
+	f.record(entry)
+	return resp
+}
+
+func (f *Forwarder) record(e ForwarderLogEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+	if e.Error != "" {
+		f.errors++
+	}
+	f.rcodes[e.Rcode]++
+	f.total += e.Latency
+	if e.Latency > f.max {
+		f.max = e.Latency
+	}
+	if len(f.log) < cap(f.log) {
+		f.log = append(f.log, e)
+		return
+	}
+	f.log[f.next] = e
+	f.next = (f.next + 1) % len(f.log)
+}
+
+// Stats returns counters and the recent query log, oldest first.
+func (f *Forwarder) Stats() (ForwarderSummary, []ForwarderLogEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := ForwarderSummary{
+		ID:         f.id,
+		Listen:     f.listen,
+		Upstream:   f.upstream,
+		Started:    f.started,
+		Uptime:     time.Since(f.started),
+		Queries:    f.queries,
+		Errors:     f.errors,
+		Rcodes:     make(map[string]uint64, len(f.rcodes)),
+		MaxLatency: f.max,
+	}
+	for k, v := range f.rcodes {
+		st.Rcodes[k] = v
+	}
+	if f.queries > 0 {
+		st.AvgLatency = f.total / time.Duration(f.queries)
+	}
+	log := make([]ForwarderLogEntry, 0, len(f.log))
+	log = append(log, f.log[f.next:]...)
+	log = append(log, f.log[:f.next]...)
+	return st, log
+}
+
+// Stop closes the listeners and the upstream transport.
+func (f *Forwarder) Stop() {
+	forwarders.mu.Lock()
+	delete(forwarders.byID, f.id)
+	forwarders.mu.Unlock()
+	f.shutdown()
+}
+
+func (f *Forwarder) shutdown() {
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		_ = f.closers[i].Close()
+	}
+	f.cancel()
+}
+
+func lookupForwarder(id string) (*Forwarder, error) {
+	forwarders.mu.Lock()
+	defer forwarders.mu.Unlock()
+	f := forwarders.byID[id]
+	if f == nil {
+		return nil, fmt.Errorf("no forwarder with id %q", id)
+	}
+	return f, nil
+}
+
+// ForwarderStartJson starts a local forwarder and returns its id and listen addresses,
+// plus the certificate when encrypted listeners are open.
+// Fields: listen, network, listeners, log_size, cert_file, key_file, tls_hosts, zone, zone_origin,
+// plus the upstream fields of DnsRequestJson.
+// Example: {"listen":"127.0.0.1:5353","server":"https://1.1.1.1/dns-query","socks5":"127.0.0.1:1080"}
+// Example: {"listeners":[{"network":"tls","listen":"127.0.0.1:8853"},{"network":"https","listen":"127.0.0.1:8443"},{"network":"quic","listen":"127.0.0.1:8853"}],"zone":"$ORIGIN example.test.\n@ 60 IN SOA ns1 host 1 3600 600 86400 60\nwww 60 IN A 192.0.2.1"}
+func ForwarderStartJson(jsonStr string) string {
+	var opts ForwarderOptions
+	if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	f, err := StartForwarder(opts)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	data := map[string]interface{}{
+		"id":       f.id,
+		"listen":   f.listen,
+		"upstream": f.upstream,
+	}
+	if f.cert != nil {
+		// Lets clients trust a generated certificate or pin it.
+		data["cert_pem"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.cert.Certificate[0]}))
+		data["cert_spki_sha256"] = spkiPin(f.cert.Leaf)
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
+
+// ForwarderStop stops the forwarder and returns its final stats.
+func ForwarderStop(id string) string {
+	f, err := lookupForwarder(id)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	f.Stop()
+	st, _ := f.Stats()
+	jsonData, err := json.Marshal(st)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
+
+// ForwarderStats returns the forwarder's counters and recent query log.
+func ForwarderStats(id string) string {
+	f, err := lookupForwarder(id)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	st, log := f.Stats()
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"stats": st,
+		"log":   log,
+	})
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
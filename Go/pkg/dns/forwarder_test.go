@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/quic-go"
+	"github.com/sagernet/quic-go/http3"
+)
+
+func TestForwarder(t *testing.T) {
+	f, err := StartForwarder(ForwarderOptions{
+		DnsRequestOptions: DnsRequestOptions{Server: "udp://" + startDNS(t)},
+		Listen:            "127.0.0.1:0",
+		LogSize:           2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	if len(f.listen) != 2 {
+		t.Fatalf("listening on %q, want udp and tcp", f.listen)
+	}
+	names := []string{"a.example.test.", "b.example.test.", "c.example.test."}
+	for i, name := range names {
+		network, addr, _ := strings.Cut(f.listen[i%2], "://")
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		c := &dns.Client{Net: network}
+		m, _, err := c.Exchange(q, addr)
+		if err != nil {
+			t.Fatalf("%s over %s: %v", name, network, err)
+		}
+		if m.Id != q.Id || len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+			t.Fatalf("%s over %s: %v", name, network, m)
+		}
+	}
+
+	st, log := f.Stats()
+	if st.Queries != 3 || st.Errors != 0 || st.Rcodes["NOERROR"] != 3 || st.MaxLatency < st.AvgLatency {
+		t.Fatalf("stats %+v", st)
+	}
+	// The log keeps the newest LogSize entries, oldest first.
+	if len(log) != 2 || log[0].Qname != names[1] || log[1].Qname != names[2] || log[1].Network != "udp" {
+		t.Fatalf("log %+v", log)
+	}
+
+	var stopped ForwarderSummary
+	if err := json.Unmarshal([]byte(ForwarderStop(f.id)), &stopped); err != nil || stopped.Queries != 3 {
+		t.Fatalf("stop: %+v, %v", stopped, err)
+	}
+	if out := ForwarderStats(f.id); !strings.Contains(out, "error") {
+		t.Fatalf("stats of a stopped forwarder: %s", out)
+	}
+}
+
+func TestForwarderUpstreamFailure(t *testing.T) {
+	f, err := StartForwarder(ForwarderOptions{
+		DnsRequestOptions: DnsRequestOptions{Server: "tcp://127.0.0.1:1"},
+		Listen:            "127.0.0.1:0",
+		Network:           "udp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	q := new(dns.Msg)
+	q.SetQuestion("example.test.", dns.TypeA)
+	m, err := dns.Exchange(q, strings.TrimPrefix(f.listen[0], "udp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("rcode %s, want SERVFAIL", dns.RcodeToString[m.Rcode])
+	}
+	if st, log := f.Stats(); st.Errors != 1 || len(log) != 1 || log[0].Error == "" {
+		t.Fatalf("stats %+v, log %+v", st, log)
+	}
+
+	if _, err := StartForwarder(ForwarderOptions{DnsRequestOptions: DnsRequestOptions{Server: "udp://127.0.0.1:53"}, Listen: "127.0.0.1:0", Network: "sctp"}); err == nil {
+		t.Fatal("unknown network accepted")
+	}
+}
+
+// Zone text without an SOA is still answered, with a made-up apex SOA.
+func TestParseForwarderZone(t *testing.T) {
+	tests := []struct {
+		text, origin, apex, qname string
+	}{
+		{"example.test. 60 IN A 192.0.2.1", "", ".", "example.test."},
+		{"www 60 IN A 192.0.2.1", "example.test", "example.test.", "www.example.test."},
+		{"$ORIGIN example.test.\n@ 60 IN SOA ns1 host 1 3600 600 86400 60\nwww 60 IN A 192.0.2.1", "", "example.test.", "www.example.test."},
+	}
+	for _, tt := range tests {
+		z, err := parseForwarderZone(tt.text, tt.origin)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		if z.Origin != tt.apex {
+			t.Fatalf("%q: apex %s, want %s", tt.text, z.Origin, tt.apex)
+		}
+		q := new(dns.Msg)
+		q.SetQuestion(tt.qname, dns.TypeA)
+		if m := z.Answer(q); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			t.Fatalf("%q: rcode %s, %d answers", tt.text, dns.RcodeToString[m.Rcode], len(m.Answer))
+		}
+		q.SetQuestion("nope.example.test.", dns.TypeA)
+		if m := z.Answer(q); m.Rcode != dns.RcodeNameError || len(m.Ns) != 1 {
+			t.Fatalf("%q: missing name got rcode %s, %d authority", tt.text, dns.RcodeToString[m.Rcode], len(m.Ns))
+		}
+	}
+	if _, err := parseForwarderZone("www 60 IN A not-an-ip", "example.test"); err == nil {
+		t.Fatal("bad record parsed without error")
+	}
+}
+
+// Every encrypted listener answers from the zone with the generated certificate.
+func TestForwarderEncrypted(t *testing.T) {
+	f, err := StartForwarder(ForwarderOptions{
+		Listeners: []ForwarderListener{
+			{Network: "tls", Listen: "127.0.0.1:0"},
+			{Network: "https", Listen: "127.0.0.1:0"},
+			{Network: "h3", Listen: "127.0.0.1:0", Path: "/q"},
+			{Network: "quic", Listen: "127.0.0.1:0"},
+		},
+		Zone:       "www 60 IN A 192.0.2.1",
+		ZoneOrigin: "example.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	roots := x509.NewCertPool()
+	roots.AddCert(f.cert.Leaf)
+	tlsCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.test.", dns.TypeA)
+	packed, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doh := func(client *http.Client, url string) *dns.Msg {
+		resp, err := client.Post(url, "application/dns-message", bytes.NewReader(packed))
+		if err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		m := new(dns.Msg)
+		if resp.StatusCode != http.StatusOK || m.Unpack(body) != nil {
+			t.Fatalf("%s: %s %q", url, resp.Status, body)
+		}
+		return m
+	}
+	h2 := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg, ForceAttemptHTTP2: true}, Timeout: 5 * time.Second}
+	h3 := &http3.Transport{TLSClientConfig: tlsCfg}
+	defer h3.Close()
+
+	for _, l := range f.listen {
+		scheme, rest, _ := strings.Cut(l, "://")
+		var m *dns.Msg
+		switch scheme {
+		case "tls":
+			c := &dns.Client{Net: "tcp-tls", TLSConfig: tlsCfg, Timeout: 5 * time.Second}
+			if m, _, err = c.Exchange(q, rest); err != nil {
+				t.Fatalf("%s: %v", l, err)
+			}
+		case "https":
+			m = doh(h2, l)
+		case "h3":
+			m = doh(&http.Client{Transport: h3, Timeout: 5 * time.Second}, "https://"+rest)
+		case "quic":
+			m = doqExchange(t, rest, tlsCfg, packed)
+		default:
+			t.Fatalf("unexpected listener %s", l)
+		}
+		if m.Id != q.Id || !m.Authoritative || len(m.Answer) != 1 {
+			t.Fatalf("%s: %v", l, m)
+		}
+	}
+	st, log := f.Stats()
+	if !strings.HasPrefix(st.Upstream, "zone") || st.Queries != 4 || len(log) != 4 {
+		t.Fatalf("stats %+v", st)
+	}
+	var networks []string
+	for _, e := range log {
+		networks = append(networks, e.Network)
+	}
+	sort.Strings(networks)
+	if got := strings.Join(networks, ","); got != "h3,https,quic,tls" {
+		t.Fatalf("logged networks %s", got)
+	}
+}
+
+// doqExchange sends one length-prefixed query on a fresh stream (RFC 9250).
+func doqExchange(t *testing.T, addr string, cfg *tls.Config, packed []byte) *dns.Msg {
+	t.Helper()
+	c := cfg.Clone()
+	c.NextProtos = []string{"doq"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseWithError(0, "")
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write(append([]byte{byte(len(packed) >> 8), byte(len(packed))}, packed...)); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	_ = stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var n [2]byte
+	if _, err := io.ReadFull(stream, n[:]); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, int(n[0])<<8|int(n[1]))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatal(err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/quic-go"
+	"github.com/sagernet/quic-go/http3"
+)
+
+// defaultDoHPath is where DoH listeners answer when no path is configured (RFC 8484).
+const defaultDoHPath = "/dns-query"
+
+// tlsConfig loads the configured certificate, or generates a self-signed one, once per forwarder.
+func (f *Forwarder) tlsConfig(opts ForwarderOptions) (*tls.Config, error) {
+	if f.tlsCfg != nil {
+		return f.tlsCfg, nil
+	}
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	switch {
+	case opts.CertFile != "" || opts.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	default:
+		cert, err = selfSignedCert(opts.TLSHosts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("forwarder certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("forwarder certificate: %w", err)
+		}
+	}
+	f.cert = &cert
+	f.tlsCfg = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	return f.tlsCfg, nil
+}
+
+// selfSignedCert creates an ECDSA P-256 certificate valid for hosts (names or IPs).
+func selfSignedCert(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"NetTest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// spkiPin is the base64 SHA-256 of the certificate's public key (RFC 7469 style).
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (f *Forwarder) listenEncrypted(network, addr, path string, cfg *tls.Config) (string, error) {
+	if path == "" {
+		path = defaultDoHPath
+	}
+	switch network {
+	case "tls":
+		return f.listenTCP(addr, cfg)
+	case "https":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return "", err
+		}
+		c := cfg.Clone()
+		c.NextProtos = []string{"h2", "http/1.1"}
+		srv := &http.Server{Handler: f.dohHandler("https", path), TLSConfig: c, ReadHeaderTimeout: 10 * time.Second}
+		f.closers = append(f.closers, srv)
+		go func() { _ = srv.ServeTLS(l, "", "") }()
+		f.listen = append(f.listen, "https://"+l.Addr().String()+path)
+		return l.Addr().String(), nil
+	case "h3":
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return "", err
+		}
+		srv := &http3.Server{Handler: f.dohHandler("h3", path), TLSConfig: http3.ConfigureTLSConfig(cfg)}
+		f.closers = append(f.closers, srv, pc)
+		go func() { _ = srv.Serve(pc) }()
+		f.listen = append(f.listen, "h3://"+pc.LocalAddr().String()+path)
+		return pc.LocalAddr().String(), nil
+	case "quic":
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return "", err
+		}
+		c := cfg.Clone()
+		c.NextProtos = []string{"doq"}
+		ln, err := quic.Listen(pc, c, &quic.Config{MaxIdleTimeout: 30 * time.Second})
+		if err != nil {
+			_ = pc.Close()
+			return "", err
+		}
+		f.closers = append(f.closers, ln, pc)
+		go f.serveDoQ(ln)
+		f.listen = append(f.listen, "quic://"+pc.LocalAddr().String())
+		return pc.LocalAddr().String(), nil
+	}
+	return "", fmt.Errorf("invalid encrypted network %q", network)
+}
+
+// dohHandler answers RFC 8484 GET (?dns=) and POST (application/dns-message) requests.
+func (f *Forwarder) dohHandler(network, path string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		var (
+			buf []byte
+			err error
+		)
+		switch req.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if ct := req.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/dns-message") {
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			buf, err = io.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r := new(dns.Msg)
+		if err == nil {
+			err = r.Unpack(buf)
+		}
+		if err != nil {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		out, err := f.handle(network, req.RemoteAddr, r).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(out)
+	})
+	return mux
+}
+
+// serveDoQ answers one query per bidirectional stream, each prefixed by a
+// 2-byte length (RFC 9250 §4.2).
+func (f *Forwarder) serveDoQ(ln *quic.Listener) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go f.serveDoQStream(conn.RemoteAddr().String(), stream)
+			}
+		}()
+	}
+}
+
+func (f *Forwarder) serveDoQStream(client string, stream quic.Stream) {
+	defer stream.Close()
+	_ = stream.SetDeadline(time.Now().Add(f.timeout + 5*time.Second))
+	var n uint16
+	if err := binary.Read(stream, binary.BigEndian, &n); err != nil {
+		return
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(0x2)) // DOQ_PROTOCOL_ERROR
+		return
+	}
+	out, err := f.handle("quic", client, r).Pack()
+	if err != nil {
+		return
+	}
+	b := make([]byte, 2+len(out))
+	binary.BigEndian.PutUint16(b, uint16(len(out)))
+	copy(b[2:], out)
+	_, _ = stream.Write(b)
+}
@@ -0,0 +1,293 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/history"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// historySink is an open history file that every Request is appended to.
+type historySink struct {
+	id      string
+	w       history.Writer
+	answers bool // keep answer records, not only their count
+	source  string
+	errs    uint64
+	lastErr string
+}
+
+var historySinks = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*historySink
+}{byID: make(map[string]*historySink)}
+
+// recordHistory appends one Request to every open history sink. The sinks are
+// written outside the registry lock so a slow disk does not hold up other
+// queries, opens or closes.
+func recordHistory(d *DnsRequestType, start time.Time, res *DnsResultType, err error) {
+	if d.server == "" {
+		return
+	}
+	historySinks.mu.Lock()
+	sinks := make([]*historySink, 0, len(historySinks.byID))
+	for _, s := range historySinks.byID {
+		sinks = append(sinks, s)
+	}
+	historySinks.mu.Unlock()
+	if len(sinks) == 0 {
+		return
+	}
+	r := history.Record{
+		Time:   start,
+		Source: d.id,
+		Server: redactURL(d.server),
+		Scheme: d.net,
+		Qname:  d.qname,
+		Qtype:  d.qtype,
+	}
+	if r.Source == "" {
+		r.Source = "query"
+	}
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case res == nil || res.answer == nil:
+		r.Error = "empty response"
+	default:
+		r.RTT = res.rtt
+		r.Rcode = dns.RcodeToString[res.answer.Rcode]
+		r.Answers = len(res.answer.Answer)
+	}
+	for _, s := range sinks {
+		if s.source != "" && s.source != r.Source {
+			continue
+		}
+		rec := r
+		if s.answers && res != nil && res.answer != nil {
+			for _, rr := range res.answer.Answer {
+				rec.Records = append(rec.Records, rr.String())
+			}
+		}
+		if werr := s.w.Append(rec); werr != nil {
+			historySinks.mu.Lock()
+			s.errs++
+			s.lastErr = werr.Error()
+			historySinks.mu.Unlock()
+		}
+	}
+}
+
+// HistoryOptions opens a history file.
+type HistoryOptions struct {
+	Path string `json:"path"`
+	// Format is csv, jsonl or db (bbolt); empty picks it from the extension.
+	Format  string `json:"format"`
+	Answers bool   `json:"answers"` // also keep the answer records
+	// Source only records results from this source: "query" for one-off
+	// requests or a monitor id. Empty records everything.
+	Source string `json:"source"`
+}
+
+// HistoryOpenJson starts appending every query and monitor probe result to a
+// file and returns the sink id. Fields: path, format, answers, source.
+// Example: {"path":"/var/lib/nettest/history.db"}
+// Example: {"path":"results.csv","source":"mon-1"}
+func HistoryOpenJson(jsonStr string) string {
+	var opts HistoryOptions
+	if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	s, err := openHistory(opts)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q,"path":%q,"format":%q}`, s.id, s.w.Path(), s.w.Format())
+}
+
+func openHistory(opts HistoryOptions) (*historySink, error) {
+	path, err := filepath.Abs(opts.Path)
+	if err != nil || opts.Path == "" {
+		return nil, errors.New("empty history path")
+	}
+	historySinks.mu.Lock()
+	defer historySinks.mu.Unlock()
+	for _, s := range historySinks.byID {
+		if s.w.Path() == path {
+			return nil, fmt.Errorf("%s is already open as %s", path, s.id)
+		}
+	}
+	w, err := history.Open(path, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	historySinks.seq++
+	s := &historySink{id: fmt.Sprintf("hist-%d", historySinks.seq), w: w, answers: opts.Answers, source: opts.Source}
+	historySinks.byID[s.id] = s
+	return s, nil
+}
+
+// HistoryClose stops recording to a sink and closes its file.
+func HistoryClose(id string) string {
+	historySinks.mu.Lock()
+	s := historySinks.byID[id]
+	delete(historySinks.byID, id)
+	historySinks.mu.Unlock()
+	if s == nil {
+		return utils.BuildErrJSON(fmt.Errorf("no history with id %q", id))
+	}
+	if err := s.w.Close(); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	historySinks.mu.Lock()
+	errs := s.errs
+	historySinks.mu.Unlock()
+	return fmt.Sprintf(`{"id":%q,"closed":true,"write_errors":%d}`, id, errs)
+}
+
+// HistoryQuery selects records from an open sink (id) or any history file (path).
+type HistoryQuery struct {
+	ID     string `json:"id"`
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	history.Filter
+	// LastMs selects the window ending now, instead of from/to.
+	LastMs int64 `json:"last_ms"`
+	// Series only: bucket width, or the number of buckets to split the range into.
+	StepMs int64 `json:"step_ms"`
+	Points int   `json:"points"`
+}
+
+// query resolves q's sink and time range and returns the matching records.
+func (q *HistoryQuery) query() ([]history.Record, error) {
+	if q.LastMs > 0 {
+		q.To = time.Now()
+		q.From = q.To.Add(-time.Duration(q.LastMs) * time.Millisecond)
+	}
+	historySinks.mu.Lock()
+	var w history.Reader
+	if s := historySinks.byID[q.ID]; s != nil {
+		w = s.w
+	} else if q.ID != "" {
+		historySinks.mu.Unlock()
+		return nil, fmt.Errorf("no history with id %q", q.ID)
+	} else if path, err := filepath.Abs(q.Path); err == nil && q.Path != "" {
+		for _, s := range historySinks.byID {
+			if s.w.Path() == path {
+				w = s.w
+			}
+		}
+	}
+	historySinks.mu.Unlock()
+	if w == nil {
+		if q.Path == "" {
+			return nil, errors.New("id or path required")
+		}
+		var err error
+		if w, err = history.OpenReadOnly(q.Path, q.Format); err != nil {
+			return nil, err
+		}
+		defer w.Close()
+	}
+	return w.Query(q.Filter)
+}
+
+// HistoryQueryJson returns matching records, oldest first.
+// Fields: id or path (+format), from, to (RFC 3339), last_ms, server, domain, source, errors_only, limit.
+// Example: {"id":"hist-1","domain":"example.com","last_ms":3600000}
+// Example: {"path":"history.db","server":"udp://8.8.8.8:53","from":"2024-05-01T00:00:00Z","to":"2024-05-02T00:00:00Z"}
+func HistoryQueryJson(jsonStr string) string {
+	var q HistoryQuery
+	if err := json.Unmarshal([]byte(jsonStr), &q); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	records, err := q.query()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+	return marshalResult(map[string]interface{}{"records": records})
+}
+
+// HistorySeriesJson downsamples matching records into buckets of step_ms (or
+// the range split into points buckets, default 100) for charts. The step is
+// widened when the range would need more than 10000 buckets.
+// Fields: those of HistoryQueryJson plus step_ms, points; limit is ignored.
+// Example: {"id":"hist-1","server":"https://1.1.1.1/dns-query","last_ms":86400000,"points":288}
+func HistorySeriesJson(jsonStr string) string {
+	var q HistoryQuery
+	if err := json.Unmarshal([]byte(jsonStr), &q); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	q.Limit = 0
+	records, err := q.query()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	from, to := q.From, q.To
+	if from.IsZero() && len(records) > 0 {
+		from = records[0].Time
+	}
+	if to.IsZero() && len(records) > 0 {
+		to = records[len(records)-1].Time.Add(time.Nanosecond)
+	}
+	step := time.Duration(q.StepMs) * time.Millisecond
+	if step <= 0 {
+		if q.Points <= 0 {
+			q.Points = 100
+		}
+		step = to.Sub(from) / time.Duration(q.Points)
+		if step < time.Millisecond {
+			step = time.Millisecond
+		}
+		step = step.Round(time.Millisecond)
+	}
+	if min := history.MinStep(from, to); step < min {
+		// Too many buckets for the range: widen the step, reported in step_ms.
+		step = min
+	}
+	points, err := history.Downsample(records, from, to, step)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	if points == nil {
+		points = []history.Point{}
+	}
+	return marshalResult(map[string]interface{}{
+		"step_ms": step.Milliseconds(),
+		"points":  points,
+	})
+}
+
+// HistoryListJson returns the open sinks.
+func HistoryListJson() string {
+	historySinks.mu.Lock()
+	defer historySinks.mu.Unlock()
+	list := make([]map[string]interface{}, 0, len(historySinks.byID))
+	for _, s := range historySinks.byID {
+		e := map[string]interface{}{"id": s.id, "path": s.w.Path(), "format": s.w.Format(), "write_errors": s.errs}
+		if s.lastErr != "" {
+			e["last_error"] = s.lastErr
+		}
+		if s.source != "" {
+			e["source"] = s.source
+		}
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i]["id"].(string), list[j]["id"].(string)
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+	return marshalResult(map[string]interface{}{"history": list})
+}
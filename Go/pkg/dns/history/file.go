@@ -0,0 +1,176 @@
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var csvHeader = []string{"time", "source", "server", "scheme", "qname", "qtype", "rcode", "answers", "rtt_ms", "error", "records"}
+
+// fileWriter appends one line per record; queries scan the whole file.
+type fileWriter struct {
+	path   string
+	format string
+	encode func(w io.Writer, r Record) error
+	decode func(line []byte) (Record, bool)
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openFile(path, format string) (*fileWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{path: path, format: format, f: f}, nil
+}
+
+func openJSONL(path string) (Writer, error) {
+	w, err := openFile(path, FormatJSONL)
+	if err != nil {
+		return nil, err
+	}
+	w.encode = func(out io.Writer, r Record) error {
+		return json.NewEncoder(out).Encode(r)
+	}
+	w.decode = decodeJSONL
+	return w, nil
+}
+
+func decodeJSONL(line []byte) (Record, bool) {
+	var r Record
+	return r, json.Unmarshal(line, &r) == nil
+}
+
+// openFileReadOnly checks that path exists; Query opens it afresh each time.
+func openFileReadOnly(path, format string) (Reader, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	return &fileWriter{path: path, format: format, decode: decodeJSONL}, nil
+}
+
+func openCSV(path string) (Writer, error) {
+	w, err := openFile(path, FormatCSV)
+	if err != nil {
+		return nil, err
+	}
+	if st, err := w.f.Stat(); err == nil && st.Size() == 0 {
+		cw := csv.NewWriter(w.f)
+		cw.Write(csvHeader)
+		cw.Flush()
+	}
+	w.encode = func(out io.Writer, r Record) error {
+		cw := csv.NewWriter(out)
+		cw.Write([]string{
+			r.Time.Format(time.RFC3339Nano), r.Source, r.Server, r.Scheme, r.Qname, r.Qtype, r.Rcode,
+			strconv.Itoa(r.Answers), strconv.FormatFloat(float64(r.RTT)/float64(time.Millisecond), 'f', 3, 64),
+			r.Error, strings.Join(r.Records, "\n"),
+		})
+		cw.Flush()
+		return cw.Error()
+	}
+	return w, nil
+}
+
+func (w *fileWriter) Path() string   { return w.path }
+func (w *fileWriter) Format() string { return w.format }
+
+func (w *fileWriter) Append(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	bw := bufio.NewWriter(w.f)
+	if err := w.encode(bw, r); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (w *fileWriter) Query(f Filter) ([]Record, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	in, err := os.Open(w.path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var out []Record
+	add := func(r Record) {
+		if f.match(r) {
+			out = append(out, r)
+		}
+	}
+	if w.format == FormatCSV {
+		err = scanCSV(in, add)
+	} else {
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64<<10), 16<<20)
+		for sc.Scan() {
+			if r, ok := w.decode(sc.Bytes()); ok {
+				add(r)
+			}
+		}
+		err = sc.Err()
+	}
+	return limit(out, f.Limit), err
+}
+
+// scanCSV reads records written by the CSV writer, skipping the header and
+// rows it cannot parse.
+func scanCSV(in io.Reader, fn func(Record)) error {
+	cr := csv.NewReader(in)
+	cr.FieldsPerRecord = -1
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(row) < len(csvHeader) || row[0] == csvHeader[0] {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, row[0])
+		if err != nil {
+			continue
+		}
+		answers, _ := strconv.Atoi(row[7])
+		ms, _ := strconv.ParseFloat(row[8], 64)
+		r := Record{
+			Time: t, Source: row[1], Server: row[2], Scheme: row[3], Qname: row[4], Qtype: row[5], Rcode: row[6],
+			Answers: answers, RTT: time.Duration(ms * float64(time.Millisecond)), Error: row[9],
+		}
+		if row[10] != "" {
+			r.Records = strings.Split(row[10], "\n")
+		}
+		fn(r)
+	}
+}
+
+func (w *fileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,237 @@
+// Package history keeps query and probe results in a CSV file, a JSON Lines
+// file or an embedded bbolt database, and reads them back by time range,
+// server and domain, raw or downsampled into fixed-width buckets for charts.
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record is one query or probe result.
+type Record struct {
+	Time    time.Time     `json:"time"`
+	Source  string        `json:"source"` // "query", or the id of the monitor that sent it
+	Server  string        `json:"server"`
+	Scheme  string        `json:"scheme"`
+	Qname   string        `json:"qname"`
+	Qtype   string        `json:"qtype"`
+	Rcode   string        `json:"rcode,omitempty"`
+	Answers int           `json:"answers"`
+	RTT     time.Duration `json:"rtt"`
+	Error   string        `json:"error,omitempty"`
+	Records []string      `json:"records,omitempty"` // answer RRs, when the writer keeps them
+}
+
+// Filter selects records. Zero fields match everything.
+type Filter struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Server string    `json:"server"`
+	// Domain matches the qname itself and every name below it.
+	Domain     string `json:"domain"`
+	Source     string `json:"source"`
+	ErrorsOnly bool   `json:"errors_only"`
+	// Limit keeps only the newest Limit matches.
+	Limit int `json:"limit"`
+}
+
+func (f Filter) match(r Record) bool {
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.Time.Before(f.To) {
+		return false
+	}
+	if f.Server != "" && r.Server != f.Server {
+		return false
+	}
+	if f.Source != "" && r.Source != f.Source {
+		return false
+	}
+	if f.ErrorsOnly && r.Error == "" {
+		return false
+	}
+	if f.Domain != "" {
+		d := strings.ToLower(strings.TrimSuffix(f.Domain, "."))
+		q := strings.ToLower(strings.TrimSuffix(r.Qname, "."))
+		if q != d && !strings.HasSuffix(q, "."+d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reader reads records back from one file.
+type Reader interface {
+	// Query returns the matching records, oldest first.
+	Query(f Filter) ([]Record, error)
+	Close() error
+}
+
+// Writer appends records to one file and reads them back.
+type Writer interface {
+	Reader
+	Append(r Record) error
+	Path() string
+	Format() string
+}
+
+// Formats accepted by Open.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatDB    = "db"
+)
+
+// formatOf validates format, taking it from the extension of path when empty:
+// .csv, .jsonl/.ndjson, anything else is a database.
+func formatOf(path, format string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty history path")
+	}
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			return FormatCSV, nil
+		case ".jsonl", ".ndjson":
+			return FormatJSONL, nil
+		}
+		return FormatDB, nil
+	}
+	switch format {
+	case FormatCSV, FormatJSONL, FormatDB:
+		return format, nil
+	case "bolt":
+		return FormatDB, nil
+	}
+	return "", fmt.Errorf("invalid history format %q, want csv, jsonl or db", format)
+}
+
+// Open opens or creates path for appending. An empty format is taken from the
+// extension: .csv, .jsonl/.ndjson, anything else is a database.
+func Open(path, format string) (Writer, error) {
+	format, err := formatOf(path, format)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatCSV:
+		return openCSV(path)
+	case FormatJSONL:
+		return openJSONL(path)
+	}
+	return openStore(path)
+}
+
+// OpenReadOnly opens an existing history file for queries only; it never
+// creates or modifies path. format is as for Open.
+func OpenReadOnly(path, format string) (Reader, error) {
+	format, err := formatOf(path, format)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatDB {
+		return openStoreReadOnly(path)
+	}
+	return openFileReadOnly(path, format)
+}
+
+// limit keeps the newest n of records, which are oldest first.
+func limit(records []Record, n int) []Record {
+	if n > 0 && len(records) > n {
+		return records[len(records)-n:]
+	}
+	return records
+}
+
+// Point is one bucket of a downsampled series. Latency figures cover the
+// answered queries only.
+type Point struct {
+	Time     time.Time     `json:"time"` // bucket start
+	Count    int           `json:"count"`
+	Failures int           `json:"failures"`
+	Loss     float64       `json:"loss"`
+	AvgRTT   time.Duration `json:"avg_rtt"`
+	MinRTT   time.Duration `json:"min_rtt"`
+	MaxRTT   time.Duration `json:"max_rtt"`
+	P50RTT   time.Duration `json:"p50_rtt"`
+	P95RTT   time.Duration `json:"p95_rtt"`
+}
+
+// MaxPoints bounds the number of buckets Downsample will allocate.
+const MaxPoints = 10000
+
+// MinStep is the narrowest whole-millisecond step Downsample accepts for
+// from..to. One bucket is left for aligning from down to the step.
+func MinStep(from, to time.Time) time.Duration {
+	d := to.Sub(from)
+	if d <= 0 {
+		return time.Millisecond
+	}
+	step := (d + MaxPoints - 2) / (MaxPoints - 1)
+	return (step + time.Millisecond - 1).Truncate(time.Millisecond)
+}
+
+// Downsample buckets records into step-wide points from from to to. Empty
+// buckets are kept so charts show gaps; a zero from or to is taken from the
+// records. It fails rather than return more than MaxPoints buckets.
+func Downsample(records []Record, from, to time.Time, step time.Duration) ([]Point, error) {
+	if len(records) == 0 && (from.IsZero() || to.IsZero()) {
+		return nil, nil
+	}
+	if from.IsZero() {
+		from = records[0].Time
+	}
+	if to.IsZero() {
+		to = records[len(records)-1].Time.Add(time.Nanosecond)
+	}
+	if step <= 0 || !from.Before(to) {
+		return nil, nil
+	}
+	from = from.Truncate(step)
+	n := (to.Sub(from) + step - 1) / step
+	if n > MaxPoints {
+		return nil, fmt.Errorf("%v in steps of %v is %d points, more than %d", to.Sub(from), step, n, MaxPoints)
+	}
+	points := make([]Point, n)
+	rtts := make([][]time.Duration, n)
+	for i := range points {
+		points[i].Time = from.Add(time.Duration(i) * step)
+	}
+	for _, r := range records {
+		i := int(r.Time.Sub(from) / step)
+		if r.Time.Before(from) || i >= len(points) {
+			continue
+		}
+		points[i].Count++
+		if r.Error != "" {
+			points[i].Failures++
+			continue
+		}
+		rtts[i] = append(rtts[i], r.RTT)
+	}
+	for i := range points {
+		p := &points[i]
+		if p.Count > 0 {
+			p.Loss = float64(p.Failures) / float64(p.Count)
+		}
+		v := rtts[i]
+		if len(v) == 0 {
+			continue
+		}
+		sort.Slice(v, func(a, b int) bool { return v[a] < v[b] })
+		var sum time.Duration
+		for _, d := range v {
+			sum += d
+		}
+		p.AvgRTT = sum / time.Duration(len(v))
+		p.MinRTT, p.MaxRTT = v[0], v[len(v)-1]
+		p.P50RTT = v[(len(v)-1)*50/100]
+		p.P95RTT = v[(len(v)-1)*95/100]
+	}
+	return points, nil
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownsampleBounded(t *testing.T) {
+	to := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+	if _, err := Downsample(nil, from, to, time.Millisecond); err == nil {
+		t.Fatal("86.4M buckets accepted")
+	}
+	for _, d := range []time.Duration{time.Second, time.Hour, 24 * time.Hour, 365 * 24 * time.Hour} {
+		// Unaligned ranges need the extra bucket MinStep leaves room for.
+		from := to.Add(-d).Add(7 * time.Millisecond)
+		step := MinStep(from, to)
+		points, err := Downsample(nil, from, to, step)
+		if err != nil {
+			t.Fatalf("%v: %v", d, err)
+		}
+		if len(points) > MaxPoints || step%time.Millisecond != 0 {
+			t.Fatalf("%v: %d points of %v", d, len(points), step)
+		}
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: from, RTT: 10 * time.Millisecond},
+		{Time: from.Add(time.Second), RTT: 30 * time.Millisecond},
+		{Time: from.Add(2 * time.Second), Error: "timeout"},
+		{Time: from.Add(5 * time.Minute), RTT: 5 * time.Millisecond},
+	}
+	points, err := Downsample(records, from, from.Add(10*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 10 {
+		t.Fatalf("%d points, want 10", len(points))
+	}
+	p := points[0]
+	if p.Count != 3 || p.Failures != 1 || p.AvgRTT != 20*time.Millisecond || p.MinRTT != 10*time.Millisecond || p.MaxRTT != 30*time.Millisecond {
+		t.Fatalf("first bucket %+v", p)
+	}
+	if points[1].Count != 0 || points[5].Count != 1 {
+		t.Fatalf("gaps not kept: %+v", points)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	rec := Record{Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Source: "query", Server: "udp://192.0.2.53:53", Qname: "example.test.", Qtype: "A"}
+	for _, name := range []string{"h.csv", "h.jsonl", "h.db"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if _, err := OpenReadOnly(path, ""); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("missing file: %v, want ErrNotExist", err)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Fatal("OpenReadOnly created the file")
+			}
+			w, err := Open(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Append(rec); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			before, _ := os.Stat(path)
+			r, err := OpenReadOnly(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.Query(Filter{Domain: "example.test"})
+			r.Close()
+			if err != nil || len(got) != 1 || got[0].Server != rec.Server {
+				t.Fatalf("query: %v, %+v", err, got)
+			}
+			if after, _ := os.Stat(path); after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+				t.Fatal("file modified by a read-only query")
+			}
+		})
+	}
+}
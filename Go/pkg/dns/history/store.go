@@ -0,0 +1,105 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var resultsBucket = []byte("results")
+
+// store keeps records in a bbolt file keyed by time, so range queries only
+// read the part of the file they need.
+type store struct {
+	path string
+	db   *bolt.DB
+}
+
+func openStore(path string) (Writer, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{path: path, db: db}, nil
+}
+
+// openStoreReadOnly opens an existing database without creating it or its
+// bucket; bbolt takes a shared lock, so other readers are not blocked.
+func openStoreReadOnly(path string) (Reader, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &store{path: path, db: db}, nil
+}
+
+func (s *store) Path() string   { return s.path }
+func (s *store) Format() string { return FormatDB }
+
+// timeKey is the big-endian UnixNano of t followed by seq, so keys sort by
+// time and records with the same timestamp do not collide.
+func timeKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func (s *store) Append(r Record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(timeKey(r.Time, seq), v)
+	})
+}
+
+func (s *store) Query(f Filter) ([]Record, error) {
+	var out []Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(resultsBucket)
+		if b == nil {
+			// Not a history database, or one never written to.
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.First()
+		if !f.From.IsZero() {
+			k, v = c.Seek(timeKey(f.From, 0))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !f.To.IsZero() && int64(binary.BigEndian.Uint64(k)) >= f.To.UnixNano() {
+				break
+			}
+			var r Record
+			if json.Unmarshal(v, &r) != nil || !f.match(r) {
+				continue
+			}
+			out = append(out, r)
+		}
+		return nil
+	})
+	return limit(out, f.Limit), err
+}
+
+func (s *store) Close() error { return s.db.Close() }
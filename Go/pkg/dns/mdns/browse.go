@@ -0,0 +1,202 @@
+package mdns
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ServicesName is the DNS-SD meta-query that enumerates service types (RFC 6763 §9).
+const ServicesName = "_services._dns-sd._udp.local."
+
+// ServiceInstance is one resolved DNS-SD instance.
+type ServiceInstance struct {
+	Name      string   `json:"name"`
+	Service   string   `json:"service"`
+	Host      string   `json:"host"`
+	Port      uint16   `json:"port"`
+	Priority  uint16   `json:"priority"`
+	Weight    uint16   `json:"weight"`
+	Text      []string `json:"txt"`
+	Addrs     []string `json:"addrs"`
+	Responder string   `json:"responder"`
+}
+
+// BrowseResult lists the service types found and every instance resolved under them.
+type BrowseResult struct {
+	Services  []string           `json:"services"`
+	Instances []*ServiceInstance `json:"instances"`
+}
+
+// recordSet collects every record seen from any responder, so additional-section
+// records can satisfy later lookups without another round trip.
+type recordSet struct {
+	mu   sync.Mutex
+	rrs  []dns.RR
+	from map[string]string // owner name -> first responder address
+}
+
+func (s *recordSet) add(responses []*Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range responses {
+		for _, sec := range [][]dns.RR{r.Msg.Answer, r.Msg.Ns, r.Msg.Extra} {
+			for _, rr := range sec {
+				rr = dns.Copy(rr)
+				rr.Header().Class &^= cacheFlushBit
+				s.rrs = append(s.rrs, rr)
+				name := strings.ToLower(rr.Header().Name)
+				if _, ok := s.from[name]; !ok && r.From != nil {
+					s.from[name] = r.From.String()
+				}
+			}
+		}
+	}
+}
+
+func (s *recordSet) find(name string, qtype uint16) []dns.RR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []dns.RR
+	for _, rr := range s.rrs {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, name) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+func (s *recordSet) responder(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.from[strings.ToLower(name)]
+}
+
+// Browse enumerates service types (or only service, when non-empty) and resolves the
+// SRV, TXT and address records of every instance. Each round of queries costs one window.
+func Browse(ctx context.Context, opts Options, service string) (*BrowseResult, error) {
+	set := &recordSet{from: make(map[string]string)}
+	lookup := func(name string, qtype uint16) ([]dns.RR, error) {
+		if rrs := set.find(name, qtype); len(rrs) > 0 {
+			return rrs, nil
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(name), qtype)
+		responses, err := Query(ctx, opts, m)
+		if err != nil {
+			return nil, err
+		}
+		set.add(responses)
+		return set.find(name, qtype), nil
+	}
+
+	var services []string
+	if service != "" {
+		services = []string{dns.Fqdn(service)}
+	} else {
+		rrs, err := lookup(ServicesName, dns.TypePTR)
+		if err != nil {
+			return nil, err
+		}
+		services = ptrTargets(rrs)
+	}
+
+	result := &BrowseResult{Services: make([]string, 0, len(services)), Instances: []*ServiceInstance{}}
+	for _, s := range services {
+		result.Services = append(result.Services, trimDot(s))
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for _, svc := range services {
+		wg.Add(1)
+		go func(svc string) {
+			defer wg.Done()
+			instances, err := resolveService(lookup, set, svc)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			result.Instances = append(result.Instances, instances...)
+		}(svc)
+	}
+	wg.Wait()
+	if firstErr != nil && len(result.Instances) == 0 {
+		return nil, firstErr
+	}
+
+	sort.Slice(result.Instances, func(i, j int) bool {
+		a, b := result.Instances[i], result.Instances[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+func resolveService(lookup func(string, uint16) ([]dns.RR, error), set *recordSet, svc string) ([]*ServiceInstance, error) {
+	rrs, err := lookup(svc, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	var out []*ServiceInstance
+	for _, name := range ptrTargets(rrs) {
+		inst := &ServiceInstance{Name: trimDot(name), Service: trimDot(svc), Text: []string{}, Addrs: []string{}}
+		if srvs, err := lookup(name, dns.TypeSRV); err == nil && len(srvs) > 0 {
+			srv := srvs[0].(*dns.SRV)
+			inst.Host = trimDot(srv.Target)
+			inst.Port = srv.Port
+			inst.Priority = srv.Priority
+			inst.Weight = srv.Weight
+		}
+		if txts, err := lookup(name, dns.TypeTXT); err == nil {
+			for _, rr := range txts {
+				inst.Text = append(inst.Text, rr.(*dns.TXT).Txt...)
+			}
+		}
+		if inst.Host != "" {
+			host := dns.Fqdn(inst.Host)
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				addrs, _ := lookup(host, qtype)
+				for _, rr := range addrs {
+					switch a := rr.(type) {
+					case *dns.A:
+						inst.Addrs = appendUnique(inst.Addrs, a.A.String())
+					case *dns.AAAA:
+						inst.Addrs = appendUnique(inst.Addrs, a.AAAA.String())
+					}
+				}
+			}
+		}
+		inst.Responder = set.responder(name)
+		out = append(out, inst)
+	}
+	return out, nil
+}
+
+func ptrTargets(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		if p, ok := rr.(*dns.PTR); ok {
+			out = appendUnique(out, p.Ptr)
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return list
+		}
+	}
+	return append(list, v)
+}
@@ -0,0 +1,215 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// cacheFlushBit is the top bit of the rrclass field in mDNS answers (RFC 6762 §10.2).
+const cacheFlushBit = 1 << 15
+
+// DefaultWindow is how long responses are collected when Options.Window is zero.
+const DefaultWindow = time.Second
+
+var (
+	GroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	GroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// Options controls where a multicast query is sent and how long responses are collected.
+type Options struct {
+	// Interface name, e.g. "eth0"; empty lets the system pick the multicast interface.
+	Interface string
+	// Use ff02::fb instead of 224.0.0.251.
+	IPv6 bool
+	// Collection window; zero uses DefaultWindow.
+	Window time.Duration
+}
+
+// Response is a single answer received from one responder.
+type Response struct {
+	From net.Addr
+	RTT  time.Duration
+	Msg  *dns.Msg
+}
+
+// ParseServer parses "mdns://[iface][?ipv6=1&window=2s]" into Options.
+func ParseServer(server string) (Options, error) {
+	var opts Options
+	u, err := url.Parse(server)
+	if err != nil {
+		return opts, err
+	}
+	if u.Scheme != "mdns" {
+		return opts, fmt.Errorf("not an mdns server: %s", server)
+	}
+	opts.Interface = u.Host
+	q := u.Query()
+	if v := q.Get("ipv6"); v != "" {
+		if opts.IPv6, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("invalid ipv6 flag: %w", err)
+		}
+	}
+	if v := q.Get("window"); v != "" {
+		if opts.Window, err = parseWindow(v); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// parseWindow accepts a Go duration ("1500ms") or a bare number of milliseconds.
+func parseWindow(v string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(v); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window: %w", err)
+	}
+	return d, nil
+}
+
+// Query sends m as a one-shot multicast query (RFC 6762 §5.1) from an ephemeral port
+// and returns every response received within the window.
+func Query(ctx context.Context, opts Options, m *dns.Msg) ([]*Response, error) {
+	if m == nil {
+		return nil, errors.New("nil dns message")
+	}
+	q := m.Copy()
+	q.RecursionDesired = false
+	buf, err := q.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack query: %w", err)
+	}
+
+	var ifi *net.Interface
+	if opts.Interface != "" {
+		ifi, err = net.InterfaceByName(opts.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %w", opts.Interface, err)
+		}
+	}
+
+	network, group := "udp4", *GroupIPv4
+	if opts.IPv6 {
+		network, group = "udp6", *GroupIPv6
+		if ifi != nil {
+			group.Zone = ifi.Name
+		}
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+	defer conn.Close()
+	if opts.IPv6 {
+		p := ipv6.NewPacketConn(conn)
+		_ = p.SetMulticastHopLimit(255)
+		if ifi != nil {
+			if err := p.SetMulticastInterface(ifi); err != nil {
+				return nil, fmt.Errorf("set multicast interface: %w", err)
+			}
+		}
+	} else {
+		p := ipv4.NewPacketConn(conn)
+		_ = p.SetMulticastTTL(255)
+		if ifi != nil {
+			if err := p.SetMulticastInterface(ifi); err != nil {
+				return nil, fmt.Errorf("set multicast interface: %w", err)
+			}
+		}
+	}
+
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	start := time.Now()
+	deadline := start.Add(window)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.WriteTo(buf, &group); err != nil {
+		return nil, fmt.Errorf("send query: %w", err)
+	}
+
+	var out []*Response
+	b := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(b)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			return out, err
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(b[:n]); err != nil {
+			continue
+		}
+		// Legacy unicast responses repeat the query ID; ignore unrelated traffic.
+		if !r.Response || r.Id != q.Id {
+			continue
+		}
+		out = append(out, &Response{From: from, RTT: time.Since(start), Msg: r})
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return out, ctx.Err()
+	}
+	return out, nil
+}
+
+// Merge folds all responses into one message so callers can render them like a unicast answer.
+// Duplicate records announced by several responders are kept once.
+func Merge(query *dns.Msg, responses []*Response) *dns.Msg {
+	out := new(dns.Msg)
+	if query != nil {
+		out.SetReply(query)
+	}
+	out.RecursionDesired = false
+	seen := make(map[string]bool)
+	add := func(dst []dns.RR, rrs []dns.RR) []dns.RR {
+		for _, rr := range rrs {
+			// Drop the cache-flush bit so the class renders as IN; the
+			// responses keep their records as received.
+			rr = dns.Copy(rr)
+			rr.Header().Class &^= cacheFlushBit
+			k := rr.String()
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			dst = append(dst, rr)
+		}
+		return dst
+	}
+	for i, r := range responses {
+		if i == 0 {
+			out.Authoritative = r.Msg.Authoritative
+			out.Rcode = r.Msg.Rcode
+		}
+		out.Answer = add(out.Answer, r.Msg.Answer)
+		out.Ns = add(out.Ns, r.Msg.Ns)
+		out.Extra = add(out.Extra, r.Msg.Extra)
+	}
+	return out
+}
+
+// trimDot is used when presenting names to callers.
+func trimDot(s string) string { return strings.TrimSuffix(s, ".") }
@@ -0,0 +1,113 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		server string
+		want   Options
+		err    bool
+	}{
+		{"mdns://", Options{}, false},
+		{"mdns://eth0", Options{Interface: "eth0"}, false},
+		{"mdns://eth0?ipv6=1&window=2s", Options{Interface: "eth0", IPv6: true, Window: 2 * time.Second}, false},
+		{"mdns://?window=1500", Options{Window: 1500 * time.Millisecond}, false},
+		{"udp://224.0.0.251", Options{}, true},
+		{"mdns://?ipv6=maybe", Options{}, true},
+		{"mdns://?window=soon", Options{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseServer(tt.server)
+		if (err != nil) != tt.err {
+			t.Fatalf("%s: error %v, want error %v", tt.server, err, tt.err)
+		}
+		if err == nil && got != tt.want {
+			t.Fatalf("%s: %+v, want %+v", tt.server, got, tt.want)
+		}
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// flush returns rr with the cache-flush bit set, as responders send unique records.
+func flush(rr dns.RR) dns.RR {
+	rr.Header().Class |= cacheFlushBit
+	return rr
+}
+
+func TestMerge(t *testing.T) {
+	a := flush(mustRR(t, "printer.local. 120 IN A 192.0.2.7"))
+	srv := mustRR(t, "Office._ipp._tcp.local. 120 IN SRV 0 0 631 printer.local.")
+	responses := []*Response{
+		{From: &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5353}, Msg: &dns.Msg{
+			MsgHdr: dns.MsgHdr{Response: true, Authoritative: true},
+			Answer: []dns.RR{a}, Extra: []dns.RR{srv},
+		}},
+		{From: &net.UDPAddr{IP: net.ParseIP("192.0.2.8"), Port: 5353}, Msg: &dns.Msg{
+			MsgHdr: dns.MsgHdr{Response: true},
+			Answer: []dns.RR{flush(mustRR(t, "printer.local. 120 IN A 192.0.2.7"))},
+		}},
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("printer.local.", dns.TypeA)
+	m := Merge(q, responses)
+	if len(m.Answer) != 1 || len(m.Extra) != 1 {
+		t.Fatalf("merged %d answers, %d extra; want the duplicate A kept once", len(m.Answer), len(m.Extra))
+	}
+	if m.Answer[0].Header().Class != dns.ClassINET {
+		t.Fatalf("merged class %#x, want IN", m.Answer[0].Header().Class)
+	}
+	if !m.Authoritative || m.Id != q.Id || m.RecursionDesired {
+		t.Fatalf("merged header %+v", m.MsgHdr)
+	}
+	if a.Header().Class&cacheFlushBit == 0 {
+		t.Fatal("Merge cleared the cache-flush bit in the response it was given")
+	}
+}
+
+// Records learned from one round answer later lookups without touching the responses.
+func TestResolveServiceFromRecordSet(t *testing.T) {
+	a := flush(mustRR(t, "printer.local. 120 IN A 192.0.2.7"))
+	msg := &dns.Msg{
+		Answer: []dns.RR{mustRR(t, "_ipp._tcp.local. 4500 IN PTR Office._ipp._tcp.local.")},
+		Extra: []dns.RR{
+			flush(mustRR(t, "Office._ipp._tcp.local. 120 IN SRV 0 0 631 printer.local.")),
+			flush(mustRR(t, `Office._ipp._tcp.local. 4500 IN TXT "rp=ipp/print" "ty=Office"`)),
+			a,
+			flush(mustRR(t, "printer.local. 120 IN AAAA 2001:db8::7")),
+		},
+	}
+	set := &recordSet{from: make(map[string]string)}
+	set.add([]*Response{{From: &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5353}, Msg: msg}})
+	if a.Header().Class&cacheFlushBit == 0 {
+		t.Fatal("recordSet.add cleared the cache-flush bit in the response it was given")
+	}
+	lookup := func(name string, qtype uint16) ([]dns.RR, error) { return set.find(name, qtype), nil }
+	got, err := resolveService(lookup, set, "_ipp._tcp.local.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("%d instances, want 1", len(got))
+	}
+	inst := got[0]
+	if inst.Name != "Office._ipp._tcp.local" || inst.Host != "printer.local" || inst.Port != 631 {
+		t.Fatalf("instance %+v", inst)
+	}
+	if len(inst.Text) != 2 || len(inst.Addrs) != 2 || inst.Responder != "192.0.2.7:5353" {
+		t.Fatalf("instance text %q, addrs %q, responder %q", inst.Text, inst.Addrs, inst.Responder)
+	}
+}
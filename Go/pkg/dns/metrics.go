@@ -0,0 +1,328 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"nettest/pkg/dns/metrics"
+	"nettest/pkg/dns/transport"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+	"github.com/sagernet/quic-go"
+	"github.com/sagernet/quic-go/http3"
+)
+
+var (
+	queryDuration = metrics.Default.Histogram("nettest_dns_query_duration_seconds",
+		"Round-trip time of answered DNS queries.", nil, "server", "scheme")
+	querySuccess = metrics.Default.Counter("nettest_dns_query_success",
+		"DNS queries that got a response.", "server", "scheme")
+	queryFailures = metrics.Default.Counter("nettest_dns_query_failures",
+		"DNS queries that got no response, by error class.", "server", "scheme", "class")
+	responseRcodes = metrics.Default.Counter("nettest_dns_responses",
+		"DNS responses by rcode.", "server", "scheme", "rcode")
+	handshakeDuration = metrics.Default.Histogram("nettest_dns_handshake_duration_seconds",
+		"Connection setup time by phase: connect (TCP/UDP dial including proxies), proxy (each proxy hop) and tls (dial to the server's first TLS or QUIC flight), and by result (ok or error).",
+		nil, "server", "scheme", "phase", "result")
+	monitorState = metrics.Default.Gauge("nettest_monitor_state",
+		"1 for the monitor's current state, 0 for the others.", "monitor", "name", "server", "state")
+)
+
+// maxServerLabels bounds the distinct values of the server label. Queries to
+// servers beyond it are counted under "other", so one-off lookups against many
+// resolvers cannot grow the registry without limit.
+const maxServerLabels = 256
+
+var serverLabels = struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// serverLabel is the server label for a query to server.
+func serverLabel(server string) string {
+	s := normalizeServer(server)
+	serverLabels.mu.Lock()
+	defer serverLabels.mu.Unlock()
+	if serverLabels.seen[s] {
+		return s
+	}
+	if len(serverLabels.seen) >= maxServerLabels {
+		return "other"
+	}
+	serverLabels.seen[s] = true
+	return s
+}
+
+// normalizeServer reduces a server URL to scheme://host:port, plus the path for
+// DoH, dropping credentials, query strings and letter case, so that spellings
+// of the same resolver share one series.
+func normalizeServer(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redactURL(server)
+	}
+	out := strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+	switch u.Scheme {
+	case "https", "https3":
+		out += u.EscapedPath()
+	}
+	return out
+}
+
+// observeQuery records one Request in the metrics registry.
+func observeQuery(d *DnsRequestType, res *DnsResultType, err error) {
+	if d.server == "" {
+		return
+	}
+	server, scheme := serverLabel(d.server), d.net
+	if err != nil || res == nil || res.answer == nil {
+		queryFailures.Inc(server, scheme, errorClass(err))
+		return
+	}
+	querySuccess.Inc(server, scheme)
+	queryDuration.Observe(res.rtt.Seconds(), server, scheme)
+	rcode := dns.RcodeToString[res.answer.Rcode]
+	if rcode == "" {
+		rcode = fmt.Sprint(res.answer.Rcode)
+	}
+	responseRcodes.Inc(server, scheme, rcode)
+}
+
+// observeHandshakes records the connection setup of one query, whether or not
+// it got an answer: each proxy hop up to the one that failed, each dial, and
+// on encrypted schemes the wait for the server's first flight. end is when the
+// exchange finished, which bounds a flight that never came.
+func observeHandshakes(d *DnsRequestType, result *DnsResultType, end time.Time, err error) {
+	server, scheme := serverLabel(d.server), d.net
+	for _, hop := range result.proxyHops {
+		if hop.Error != "" {
+			// Later hops only report the failure of this one.
+			handshakeDuration.Observe(hop.Handshake.Seconds(), server, scheme, "proxy", "error")
+			break
+		}
+		if hop.Elapsed > 0 {
+			handshakeDuration.Observe(hop.Handshake.Seconds(), server, scheme, "proxy", "ok")
+		}
+	}
+	if result.dial == nil {
+		return
+	}
+	for _, dt := range result.dial.Snapshot() {
+		if dt.Error != "" {
+			handshakeDuration.Observe(dt.Connected.Sub(dt.Start).Seconds(), server, scheme, "connect", "error")
+			continue
+		}
+		handshakeDuration.Observe(dt.Connected.Sub(dt.Start).Seconds(), server, scheme, "connect", "ok")
+		switch {
+		case !encryptedScheme(scheme):
+		case !dt.FirstByte.IsZero():
+			handshakeDuration.Observe(dt.FirstByte.Sub(dt.Connected).Seconds(), server, scheme, "tls", "ok")
+		case err != nil:
+			handshakeDuration.Observe(end.Sub(dt.Connected).Seconds(), server, scheme, "tls", "error")
+		}
+	}
+}
+
+func encryptedScheme(scheme string) bool {
+	switch scheme {
+	case "tcp-tls", "tls", "https", "quic", "https3":
+		return true
+	}
+	return false
+}
+
+// observeMonitorState marks state as the monitor's current one.
+func observeMonitorState(m *Monitor, state string) {
+	for _, s := range []string{MonitorUnknown, MonitorUp, MonitorDegraded, MonitorDown} {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		monitorState.Set(v, m.id, m.name, normalizeServer(m.opts.Server), s)
+	}
+}
+
+func forgetMonitorState(m *Monitor) {
+	for _, s := range []string{MonitorUnknown, MonitorUp, MonitorDegraded, MonitorDown} {
+		monitorState.Delete(m.id, m.name, normalizeServer(m.opts.Server), s)
+	}
+}
+
+// redactURL drops credentials from a server URL used as a label.
+func redactURL(s string) string {
+	if i := strings.Index(s, "@"); i >= 0 {
+		if j := strings.Index(s, "://"); j >= 0 && j < i {
+			return s[:j+3] + s[i+1:]
+		}
+	}
+	return s
+}
+
+// ErrorClass names the kind of failure err is, as in the class label of
+// nettest_dns_query_failures_total: timeout, refused, tls, proxy and so on.
+func ErrorClass(err error) string { return errorClass(err) }
+
+// errorClass buckets a query error for the failure counter.
+func errorClass(err error) string {
+	var (
+		netErr     net.Error
+		opErr      *net.OpError
+		dnsErr     *net.DNSError
+		msgErr     *dns.Error
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		appErr     *quic.ApplicationError
+		quicErr    *quic.TransportError
+		versionErr *quic.VersionNegotiationError
+		h3Err      *http3.Error
+	)
+	switch {
+	case err == nil:
+		return "empty_response"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.Canceled):
+		// sing-dns cancels a UDP exchange when its socket fails, e.g. on ICMP
+		// port unreachable, without keeping the socket error.
+		return "closed"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+	case errors.Is(err, transport.ErrSocks5AuthFailed), errors.Is(err, transport.ErrHttpProxyAuthFailed):
+		return "proxy_auth"
+	case errors.Is(err, transport.ErrUDPUnsupported), errors.Is(err, transport.ErrSocks5UDPUnsupported):
+		return "proxy"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostErr):
+		return "tls"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// crypto/tls reports alerts from the peer this way; the alert type is unexported.
+		return "tls"
+	case errors.As(err, &appErr), errors.As(err, &quicErr), errors.As(err, &versionErr),
+		errors.As(err, &h3Err):
+		return "quic"
+	case errors.As(err, &dnsErr), errors.Is(err, transport.ErrBootstrapNoAddress):
+		return "resolve"
+	case errors.As(err, &msgErr):
+		// Every unpack failure (short read, bad rdata, compression loops, ...) is a *dns.Error.
+		return "bad_response"
+	}
+	return "other"
+}
+
+// MetricsText returns the current metrics in the Prometheus text format.
+func MetricsText() string {
+	var b strings.Builder
+	metrics.Default.Write(&b, false)
+	return b.String()
+}
+
+// MetricsServer is an HTTP endpoint exposing the metrics registry.
+type MetricsServer struct {
+	id  string
+	url string
+	srv *http.Server
+}
+
+var metricsServers = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*MetricsServer
+}{byID: make(map[string]*MetricsServer)}
+
+// MetricsServeOptions configures a metrics endpoint.
+type MetricsServeOptions struct {
+	Listen string `json:"listen"` // default "127.0.0.1:9153"; port 0 picks a free port
+	Path   string `json:"path"`   // default "/metrics"
+}
+
+// ServeMetrics starts an HTTP server exposing the registry at opts.Path.
+func ServeMetrics(opts MetricsServeOptions) (*MetricsServer, error) {
+	if opts.Listen == "" {
+		opts.Listen = "127.0.0.1:9153"
+	}
+	if opts.Path == "" {
+		opts.Path = "/metrics"
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		opts.Path = "/" + opts.Path
+	}
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(opts.Path, metrics.Default)
+	s := &MetricsServer{
+		url: "http://" + ln.Addr().String() + opts.Path,
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+			ErrorLog:          log.New(io.Discard, "", 0),
+		},
+	}
+	go s.srv.Serve(ln)
+
+	metricsServers.mu.Lock()
+	metricsServers.seq++
+	s.id = fmt.Sprintf("metrics-%d", metricsServers.seq)
+	metricsServers.byID[s.id] = s
+	metricsServers.mu.Unlock()
+	return s, nil
+}
+
+// MetricsServeJson starts a /metrics endpoint and returns its id and URL.
+// Fields: listen, path.
+// Example: {"listen":"0.0.0.0:9153"}
+func MetricsServeJson(jsonStr string) string {
+	var opts MetricsServeOptions
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+			return utils.BuildErrJSON(err)
+		}
+	}
+	s, err := ServeMetrics(opts)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q,"url":%q}`, s.id, s.url)
+}
+
+// MetricsStop shuts a metrics endpoint down.
+func MetricsStop(id string) string {
+	metricsServers.mu.Lock()
+	s := metricsServers.byID[id]
+	delete(metricsServers.byID, id)
+	metricsServers.mu.Unlock()
+	if s == nil {
+		return utils.BuildErrJSON(fmt.Errorf("no metrics server with id %q", id))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q,"stopped":true}`, id)
+}
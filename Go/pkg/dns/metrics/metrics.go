@@ -0,0 +1,289 @@
+// Package metrics is a small registry of labelled counters, gauges and
+// histograms that renders the Prometheus text exposition format (0.0.4) or
+// OpenMetrics 1.0, depending on what the scraper accepts.
+//
+//	queries := metrics.Default.Counter("nettest_dns_queries", "DNS queries sent.", "server", "result")
+//	queries.Inc("udp://8.8.8.8:53", "success")
+//	http.Handle("/metrics", metrics.Default)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds, from 1ms to 10s.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Default is the registry the rest of NetTest records into.
+var Default = NewRegistry()
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	byName   map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*family)}
+}
+
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k kind) String() string {
+	return [...]string{"counter", "gauge", "histogram"}[k]
+}
+
+// family is one metric name with its series, keyed by joined label values.
+type family struct {
+	name    string // without the _total suffix for counters
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64  // counter or gauge
+	counts []uint64 // histogram, per bucket (not cumulative)
+	sum    float64  // histogram
+	count  uint64   // histogram
+}
+
+func (r *Registry) register(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.byName[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic("metrics: " + name + " registered twice with different shapes")
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: k, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.families = append(r.families, f)
+	r.byName[name] = f
+	return f
+}
+
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s := f.series[key]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing value per label set. Its name is
+// given without the _total suffix, which is added when rendering.
+type Counter struct{ f *family }
+
+// Counter registers (or returns the already registered) counter family.
+func (r *Registry) Counter(name, help string, labels ...string) Counter {
+	return Counter{r.register(strings.TrimSuffix(name, "_total"), help, kindCounter, nil, labels)}
+}
+
+// Inc adds one.
+func (c Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// Add adds v, which must not be negative.
+func (c Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.f.get(values).value += v
+	c.f.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ f *family }
+
+// Gauge registers (or returns the already registered) gauge family.
+func (r *Registry) Gauge(name, help string, labels ...string) Gauge {
+	return Gauge{r.register(name, help, kindGauge, nil, labels)}
+}
+
+// Set sets the value.
+func (g Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	g.f.get(values).value = v
+	g.f.mu.Unlock()
+}
+
+// Delete drops the series so it is no longer exported.
+func (g Gauge) Delete(values ...string) {
+	g.f.mu.Lock()
+	delete(g.f.series, strings.Join(values, "\xff"))
+	g.f.mu.Unlock()
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct{ f *family }
+
+// Histogram registers (or returns the already registered) histogram family;
+// nil buckets means DefBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return Histogram{r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+// Observe records v.
+func (h Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(values)
+	s.sum += v
+	s.count++
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(h.f.buckets) {
+		s.counts[i]++
+	}
+}
+
+// Reset drops every series of every family.
+func (r *Registry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		f.mu.Lock()
+		f.series = make(map[string]*series)
+		f.mu.Unlock()
+	}
+}
+
+// Write renders every family, in the OpenMetrics format when openMetrics is set.
+func (r *Registry) Write(w io.Writer, openMetrics bool) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw, openMetrics)
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the registry, negotiating OpenMetrics via the Accept header.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+	}
+	r.Write(w, openMetrics)
+}
+
+func (f *family) write(w *bufio.Writer, openMetrics bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := f.name
+	if f.kind == kindCounter && !openMetrics {
+		name += "_total" // the text format names the family after its sample
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(f.help), name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		switch f.kind {
+		case kindCounter:
+			writeSample(w, f.name+"_total", f.labels, s.values, "", "", s.value)
+		case kindGauge:
+			writeSample(w, f.name, f.labels, s.values, "", "", s.value)
+		case kindHistogram:
+			var cum uint64
+			for i, b := range f.buckets {
+				cum += s.counts[i]
+				writeSample(w, f.name+"_bucket", f.labels, s.values, "le", formatFloat(b), float64(cum))
+			}
+			writeSample(w, f.name+"_bucket", f.labels, s.values, "le", "+Inf", float64(s.count))
+			writeSample(w, f.name+"_sum", f.labels, s.values, "", "", s.sum)
+			writeSample(w, f.name+"_count", f.labels, s.values, "", "", float64(s.count))
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry, openMetrics bool) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.Write(&b, openMetrics); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	queries := r.Counter("dns_queries_total", "Queries sent.", "server")
+	up := r.Gauge("up", "Whether the target is up.\nOne line per target.", "target")
+	rtt := r.Histogram("rtt_seconds", "Round-trip time.", []float64{1, 0.1}, "server")
+
+	queries.Inc("udp://b")
+	queries.Add(2, "udp://a")
+	queries.Add(-1, "udp://a") // ignored
+	up.Set(1, `path\with "quotes"`+"\nand a newline")
+	rtt.Observe(0.05, "udp://a")
+	rtt.Observe(0.1, "udp://a") // on a bound: le is inclusive
+	rtt.Observe(0.5, "udp://a")
+	rtt.Observe(3, "udp://a")
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{"text", false, `# HELP dns_queries_total Queries sent.
+# TYPE dns_queries_total counter
+dns_queries_total{server="udp://a"} 2
+dns_queries_total{server="udp://b"} 1
+# HELP up Whether the target is up.\nOne line per target.
+# TYPE up gauge
+up{target="path\\with \"quotes\"\nand a newline"} 1
+# HELP rtt_seconds Round-trip time.
+# TYPE rtt_seconds histogram
+rtt_seconds_bucket{server="udp://a",le="0.1"} 2
+rtt_seconds_bucket{server="udp://a",le="1"} 3
+rtt_seconds_bucket{server="udp://a",le="+Inf"} 4
+rtt_seconds_sum{server="udp://a"} 3.65
+rtt_seconds_count{server="udp://a"} 4
+`},
+		{"openmetrics", true, `# HELP dns_queries Queries sent.
+# TYPE dns_queries counter
+dns_queries_total{server="udp://a"} 2
+dns_queries_total{server="udp://b"} 1
+# HELP up Whether the target is up.\nOne line per target.
+# TYPE up gauge
+up{target="path\\with \"quotes\"\nand a newline"} 1
+# HELP rtt_seconds Round-trip time.
+# TYPE rtt_seconds histogram
+rtt_seconds_bucket{server="udp://a",le="0.1"} 2
+rtt_seconds_bucket{server="udp://a",le="1"} 3
+rtt_seconds_bucket{server="udp://a",le="+Inf"} 4
+rtt_seconds_sum{server="udp://a"} 3.65
+rtt_seconds_count{server="udp://a"} 4
+# EOF
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(t, r, tt.openMetrics); got != tt.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesLifecycle(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("state", "State.", "name")
+	g.Set(1, "a")
+	g.Set(0, "b")
+	g.Delete("a")
+	if got := render(t, r, false); strings.Contains(got, `name="a"`) || !strings.Contains(got, `state{name="b"} 0`) {
+		t.Fatalf("after Delete:\n%s", got)
+	}
+	if again := r.Gauge("state", "State.", "name"); again.f != g.f {
+		t.Fatal("registering the same family twice gave a new one")
+	}
+	r.Reset()
+	if got := render(t, r, false); got != "# HELP state State.\n# TYPE state gauge\n" {
+		t.Fatalf("after Reset:\n%s", got)
+	}
+	for name, f := range map[string]func(){
+		"shape":  func() { r.Counter("state", "State.", "name") },
+		"labels": func() { g.Set(1, "a", "b") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: no panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits", "Hits.").Inc()
+	for _, tt := range []struct {
+		accept, contentType, sample, tail string
+	}{
+		{"", contentTypeText, "# TYPE hits_total counter", "hits_total 1\n"},
+		{"application/openmetrics-text; version=1.0.0,text/plain;q=0.5", contentTypeOpenMetrics, "# TYPE hits counter", "# EOF\n"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		body := w.Body.String()
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("accept %q: content type %q", tt.accept, ct)
+		}
+		if !strings.Contains(body, tt.sample) || !strings.HasSuffix(body, tt.tail) {
+			t.Errorf("accept %q:\n%s", tt.accept, body)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// Monitor states. A monitor starts unknown and takes the state of its first
+// probe; after that it only moves once enough consecutive probes agree.
+const (
+	MonitorUnknown  = "unknown"
+	MonitorUp       = "up"
+	MonitorDegraded = "degraded"
+	MonitorDown     = "down"
+)
+
+// MonitorOptions configures a monitor that repeatedly sends one query. The
+// embedded request options describe the query and upstream (server, qname,
+// proxies, bootstrap, ...), as for DnsRequestJson.
+type MonitorOptions struct {
+	DnsRequestOptions
+	Name string `json:"name"` // label shown in events; defaults to the server
+	// IntervalMs is the time between probes (default 30000); each wait is moved
+	// by a random amount of up to JitterMs either way (default a tenth of it).
+	IntervalMs int `json:"interval_ms"`
+	JitterMs   int `json:"jitter_ms"`
+	History    int `json:"history"` // results kept, default 100
+	// ExpectRcodes are the rcodes that count as a working resolver (default
+	// NOERROR and NXDOMAIN); anything else, or no answer, is a failure.
+	ExpectRcodes []string `json:"expect_rcodes"`
+	// A working probe is degraded when slower than DegradedRttMs, or when at least
+	// DegradedLossPercent of the last Window probes failed. 0 disables either.
+	DegradedRttMs       int `json:"degraded_rtt_ms"`
+	DegradedLossPercent int `json:"degraded_loss_percent"`
+	Window              int `json:"window"` // default 10
+	// Fall consecutive failures mark the monitor down (default 3); Rise
+	// consecutive probes agreeing on up or degraded move it there (default 2).
+	Fall int `json:"fall"`
+	Rise int `json:"rise"`
+	// ReportResults also emits a "result" event for every probe.
+	ReportResults bool `json:"report_results"`
+}
+
+// MonitorResult is one probe.
+type MonitorResult struct {
+	Time    time.Time     `json:"time"`
+	RTT     time.Duration `json:"rtt"`
+	Rcode   string        `json:"rcode,omitempty"`
+	Answers int           `json:"answers"`
+	Status  string        `json:"status"` // up, degraded or down as judged from this probe alone
+	Error   string        `json:"error,omitempty"`
+}
+
+// MonitorEvent is emitted on every state change, and per probe when asked for.
+type MonitorEvent struct {
+	Monitor string         `json:"monitor"`
+	Name    string         `json:"name"`
+	Type    string         `json:"type"` // up, degraded, down or result
+	From    string         `json:"from,omitempty"`
+	To      string         `json:"to,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Time    time.Time      `json:"time"`
+	Result  *MonitorResult `json:"result,omitempty"`
+}
+
+// MonitorSummary is a monitor's current state and counters.
+type MonitorSummary struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Server     string        `json:"server"`
+	Qname      string        `json:"qname"`
+	Qtype      string        `json:"qtype"`
+	State      string        `json:"state"`
+	Since      time.Time     `json:"since"` // time of the last state change
+	Probes     uint64        `json:"probes"`
+	Failures   uint64        `json:"failures"`
+	Loss       float64       `json:"loss"` // failed share of the last Window probes
+	AvgRTT     time.Duration `json:"avg_rtt"`
+	LastRTT    time.Duration `json:"last_rtt"`
+	LastError  string        `json:"last_error,omitempty"`
+	IntervalMs int           `json:"interval_ms"`
+}
+
+// Monitor probes one resolver on a schedule until stopped.
+type Monitor struct {
+	id     string
+	name   string
+	opts   MonitorOptions
+	expect map[int]bool
+	emit   func(MonitorEvent)
+	done   chan struct{}
+	exited chan struct{}
+	stop   sync.Once
+
+	mu       sync.Mutex
+	history  []MonitorResult // ring buffer
+	next     int
+	state    string
+	since    time.Time
+	pending  string // state the recent probes point to
+	streak   int    // consecutive probes pointing to pending
+	probes   uint64
+	failures uint64
+	total    time.Duration
+	okProbes uint64
+}
+
+var monitors = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*Monitor
+}{byID: make(map[string]*Monitor)}
+
+// StartMonitor validates opts and starts probing; emit receives every event
+// from the monitor's goroutine and may be nil.
+func StartMonitor(opts MonitorOptions, emit func(MonitorEvent)) (*Monitor, error) {
+	if strings.TrimSpace(opts.Server) == "" {
+		return nil, errors.New("empty server")
+	}
+	if opts.Qname == "" {
+		return nil, errors.New("empty qname")
+	}
+	if opts.Qtype == "" {
+		opts.Qtype = "A"
+	}
+	if opts.Qclass == "" {
+		opts.Qclass = "IN"
+	}
+	if opts.IntervalMs <= 0 {
+		opts.IntervalMs = 30000
+	}
+	if opts.JitterMs <= 0 {
+		opts.JitterMs = opts.IntervalMs / 10
+	}
+	if opts.JitterMs > opts.IntervalMs/2 {
+		opts.JitterMs = opts.IntervalMs / 2
+	}
+	if opts.History <= 0 {
+		opts.History = 100
+	}
+	if opts.Window <= 0 {
+		opts.Window = 10
+	}
+	if opts.Window > opts.History {
+		opts.Window = opts.History
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = 3
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = 2
+	}
+	if len(opts.ExpectRcodes) == 0 {
+		opts.ExpectRcodes = []string{"NOERROR", "NXDOMAIN"}
+	}
+	expect := make(map[int]bool, len(opts.ExpectRcodes))
+	for _, s := range opts.ExpectRcodes {
+		rc, ok := dns.StringToRcode[strings.ToUpper(s)]
+		if !ok {
+			return nil, fmt.Errorf("unknown rcode %q", s)
+		}
+		expect[rc] = true
+	}
+	req := opts.request()
+	if req.net == "mdns" {
+		return nil, errors.New("mdns servers cannot be monitored")
+	}
+	if emit == nil {
+		emit = func(MonitorEvent) {}
+	}
+	m := &Monitor{
+		name:    opts.Name,
+		opts:    opts,
+		expect:  expect,
+		emit:    emit,
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+		history: make([]MonitorResult, 0, opts.History),
+		state:   MonitorUnknown,
+		since:   time.Now(),
+	}
+	if m.name == "" {
+		m.name = opts.Server
+	}
+
+	monitors.mu.Lock()
+	monitors.seq++
+	m.id = fmt.Sprintf("mon-%d", monitors.seq)
+	monitors.byID[m.id] = m
+	monitors.mu.Unlock()
+
+	observeMonitorState(m, m.state)
+	go m.run()
+	return m, nil
+}
+
+// Stop ends the schedule, waits for a running probe to finish and removes the
+// monitor from the list. Later calls wait for the first one and do nothing.
+func (m *Monitor) Stop() {
+	m.stop.Do(func() {
+		monitors.mu.Lock()
+		if monitors.byID[m.id] == m {
+			delete(monitors.byID, m.id)
+		}
+		monitors.mu.Unlock()
+		close(m.done)
+		<-m.exited
+		forgetMonitorState(m)
+	})
+}
+
+// run probes once after a random share of the jitter, so monitors added
+// together spread out, then every interval plus or minus the jitter.
+func (m *Monitor) run() {
+	defer close(m.exited)
+	interval := time.Duration(m.opts.IntervalMs) * time.Millisecond
+	jitter := time.Duration(m.opts.JitterMs) * time.Millisecond
+	wait := time.Duration(rand.Int63n(int64(jitter) + 1))
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		m.probe()
+		wait = interval - jitter + time.Duration(rand.Int63n(2*int64(jitter)+1))
+	}
+}
+
+func (m *Monitor) probe() {
+	req := m.opts.request()
+	req.id = m.id // history records the monitor as the source
+	res := MonitorResult{Time: time.Now()}
+	out, err := req.Request()
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case out.answer == nil:
+		res.Error = "empty response"
+	default:
+		res.RTT = out.rtt
+		res.Rcode = dns.RcodeToString[out.answer.Rcode]
+		res.Answers = len(out.answer.Answer)
+		if !m.expect[out.answer.Rcode] {
+			res.Error = "unexpected rcode " + res.Rcode
+		}
+	}
+	m.record(res)
+}
+
+// record judges res, stores it and moves the state when the streak allows.
+func (m *Monitor) record(res MonitorResult) {
+	m.mu.Lock()
+	m.probes++
+	if res.Error != "" {
+		m.failures++
+	} else {
+		m.okProbes++
+		m.total += res.RTT
+	}
+	if len(m.history) < cap(m.history) {
+		m.history = append(m.history, res)
+	} else {
+		m.history[m.next] = res
+		m.next = (m.next + 1) % len(m.history)
+	}
+
+	var reason string
+	loss := m.lossLocked()
+	switch {
+	case res.Error != "":
+		res.Status, reason = MonitorDown, res.Error
+	case m.opts.DegradedRttMs > 0 && res.RTT > time.Duration(m.opts.DegradedRttMs)*time.Millisecond:
+		res.Status, reason = MonitorDegraded, fmt.Sprintf("rtt %v over %dms", res.RTT.Round(time.Millisecond), m.opts.DegradedRttMs)
+	case m.opts.DegradedLossPercent > 0 && loss*100 >= float64(m.opts.DegradedLossPercent):
+		res.Status, reason = MonitorDegraded, fmt.Sprintf("%.0f%% loss over the last %d probes", loss*100, m.windowLocked())
+	default:
+		res.Status, reason = MonitorUp, "probe answered"
+	}
+	m.history[m.newestLocked(0)].Status = res.Status
+
+	if res.Status == m.pending {
+		m.streak++
+	} else {
+		m.pending, m.streak = res.Status, 1
+	}
+	need := m.opts.Rise
+	if res.Status == MonitorDown {
+		need = m.opts.Fall
+	}
+	var events []MonitorEvent
+	if m.opts.ReportResults {
+		r := res
+		events = append(events, MonitorEvent{Type: "result", Time: res.Time, Result: &r})
+	}
+	if res.Status != m.state && (m.state == MonitorUnknown || m.streak >= need) {
+		r := res
+		events = append(events, MonitorEvent{Type: res.Status, From: m.state, To: res.Status, Reason: reason, Time: res.Time, Result: &r})
+		m.state, m.since = res.Status, res.Time
+		observeMonitorState(m, m.state)
+	}
+	m.mu.Unlock()
+
+	for _, ev := range events {
+		ev.Monitor, ev.Name = m.id, m.name
+		m.emit(ev)
+	}
+}
+
+// windowLocked is how many of the newest results the loss is computed over.
+func (m *Monitor) windowLocked() int {
+	if len(m.history) < m.opts.Window {
+		return len(m.history)
+	}
+	return m.opts.Window
+}
+
+// newestLocked is the history index of the i-th newest result.
+func (m *Monitor) newestLocked(i int) int {
+	if len(m.history) < cap(m.history) {
+		return len(m.history) - 1 - i
+	}
+	return (m.next - 1 - i + 2*len(m.history)) % len(m.history)
+}
+
+// lossLocked is the failed share of the newest Window results.
+func (m *Monitor) lossLocked() float64 {
+	n := m.windowLocked()
+	if n == 0 {
+		return 0
+	}
+	failed := 0
+	for i := 0; i < n; i++ {
+		if m.history[m.newestLocked(i)].Error != "" {
+			failed++
+		}
+	}
+	return float64(failed) / float64(n)
+}
+
+// Summary returns the current state and counters.
+func (m *Monitor) Summary() MonitorSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MonitorSummary{
+		ID:         m.id,
+		Name:       m.name,
+		Server:     m.opts.Server,
+		Qname:      m.opts.Qname,
+		Qtype:      m.opts.Qtype,
+		State:      m.state,
+		Since:      m.since,
+		Probes:     m.probes,
+		Failures:   m.failures,
+		Loss:       m.lossLocked(),
+		IntervalMs: m.opts.IntervalMs,
+	}
+	if m.okProbes > 0 {
+		s.AvgRTT = m.total / time.Duration(m.okProbes)
+	}
+	if len(m.history) > 0 {
+		last := m.history[m.newestLocked(0)]
+		s.LastRTT, s.LastError = last.RTT, last.Error
+	}
+	return s
+}
+
+// History returns the kept results, oldest first.
+func (m *Monitor) History() []MonitorResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]MonitorResult, 0, len(m.history))
+	out = append(out, m.history[m.next:]...)
+	return append(out, m.history[:m.next]...)
+}
+
+func lookupMonitor(id string) (*Monitor, error) {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	m := monitors.byID[id]
+	if m == nil {
+		return nil, fmt.Errorf("no monitor with id %q", id)
+	}
+	return m, nil
+}
+
+// MonitorAddJson starts a monitor and returns its id; emit receives each event
+// as JSON. Fields: name, interval_ms, jitter_ms, history, expect_rcodes,
+// degraded_rtt_ms, degraded_loss_percent, window, fall, rise, report_results,
+// plus the query fields of DnsRequestJson.
+// Example: {"name":"Cloudflare DoH","server":"https://1.1.1.1/dns-query","qname":"example.com","interval_ms":10000,"degraded_rtt_ms":300}
+// Example: {"server":"udp://8.8.8.8","qname":"example.com","socks5":"127.0.0.1:1080","fall":2,"rise":3,"degraded_loss_percent":20,"window":20}
+func MonitorAddJson(jsonStr string, emit func(string)) string {
+	var opts MonitorOptions
+	if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	var onEvent func(MonitorEvent)
+	if emit != nil {
+		onEvent = func(ev MonitorEvent) {
+			jsonData, err := json.Marshal(ev)
+			if err != nil {
+				emit(utils.BuildErrJSON(err))
+				return
+			}
+			emit(string(jsonData))
+		}
+	}
+	m, err := StartMonitor(opts, onEvent)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q}`, m.id)
+}
+
+// MonitorRemove stops a monitor; no events are emitted after it returns.
+func MonitorRemove(id string) string {
+	m, err := lookupMonitor(id)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	m.Stop()
+	return fmt.Sprintf(`{"id":%q,"removed":true}`, id)
+}
+
+// MonitorListJson returns the summary of every monitor, ordered by id.
+func MonitorListJson() string {
+	monitors.mu.Lock()
+	list := make([]*Monitor, 0, len(monitors.byID))
+	for _, m := range monitors.byID {
+		list = append(list, m)
+	}
+	monitors.mu.Unlock()
+	summaries := make([]MonitorSummary, 0, len(list))
+	for _, m := range list {
+		summaries = append(summaries, m.Summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if len(summaries[i].ID) != len(summaries[j].ID) {
+			return len(summaries[i].ID) < len(summaries[j].ID)
+		}
+		return summaries[i].ID < summaries[j].ID
+	})
+	jsonData, err := json.Marshal(map[string]interface{}{"monitors": summaries})
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
+
+// MonitorHistoryJson returns a monitor's summary and kept results, oldest first.
+func MonitorHistoryJson(id string) string {
+	m, err := lookupMonitor(id)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"monitor": m.Summary(),
+		"history": m.History(),
+	})
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stop may race with MonitorRemove or run twice; the monitor leaves the list once.
+func TestMonitorStopTwice(t *testing.T) {
+	m, err := StartMonitor(MonitorOptions{
+		DnsRequestOptions: DnsRequestOptions{Server: "udp://127.0.0.1:1", Qname: "example.test"},
+		IntervalMs:        60000,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Stop()
+		}()
+	}
+	wg.Wait()
+	if list := MonitorListJson(); strings.Contains(list, m.id) {
+		t.Fatalf("stopped monitor still listed: %s", list)
+	}
+	if out := MonitorRemove(m.id); !strings.Contains(out, "no monitor") {
+		t.Fatalf("MonitorRemove after Stop: %s", out)
+	}
+}
+
+// newTestMonitor is a monitor with StartMonitor's defaults that is never
+// scheduled; results are fed to record directly.
+func newTestMonitor(t *testing.T, opts MonitorOptions) (*Monitor, *[]MonitorEvent) {
+	if opts.History == 0 {
+		opts.History = 100
+	}
+	if opts.Window == 0 {
+		opts.Window = 10
+	}
+	if opts.Fall == 0 {
+		opts.Fall = 3
+	}
+	if opts.Rise == 0 {
+		opts.Rise = 2
+	}
+	events := new([]MonitorEvent)
+	m := &Monitor{
+		id:      "mon-test",
+		name:    "test",
+		opts:    opts,
+		emit:    func(ev MonitorEvent) { *events = append(*events, ev) },
+		history: make([]MonitorResult, 0, opts.History),
+		state:   MonitorUnknown,
+	}
+	t.Cleanup(func() { forgetMonitorState(m) })
+	return m, events
+}
+
+func ok(rtt time.Duration) MonitorResult { return MonitorResult{RTT: rtt, Rcode: "NOERROR"} }
+
+var failed = MonitorResult{Error: "i/o timeout"}
+
+func TestMonitorHysteresis(t *testing.T) {
+	up := ok(10 * time.Millisecond)
+	tests := []struct {
+		name    string
+		results []MonitorResult
+		states  string // state after each result
+		changes string // state-change events
+	}{
+		{"first probe decides", []MonitorResult{failed}, "down", "unknown>down"},
+		{"fall", []MonitorResult{up, failed, failed, failed}, "up up up down", "unknown>up up>down"},
+		{"rise", []MonitorResult{failed, up, up}, "down down up", "unknown>down down>up"},
+		{"broken streak", []MonitorResult{up, failed, failed, up, failed, failed, up}, "up up up up up up up", "unknown>up"},
+		{"flapping back", []MonitorResult{up, failed, failed, failed, up, failed, up, up}, "up up up down down down down up", "unknown>up up>down down>up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, events := newTestMonitor(t, MonitorOptions{})
+			var states []string
+			for _, r := range tt.results {
+				m.record(r)
+				states = append(states, m.Summary().State)
+			}
+			var changes []string
+			for _, ev := range *events {
+				changes = append(changes, ev.From+">"+ev.To)
+			}
+			if got := strings.Join(states, " "); got != tt.states {
+				t.Errorf("states %q, want %q", got, tt.states)
+			}
+			if got := strings.Join(changes, " "); got != tt.changes {
+				t.Errorf("changes %q, want %q", got, tt.changes)
+			}
+		})
+	}
+}
+
+func TestMonitorDegradedByRTT(t *testing.T) {
+	m, events := newTestMonitor(t, MonitorOptions{DegradedRttMs: 100, ReportResults: true})
+	m.record(ok(20 * time.Millisecond))
+	m.record(ok(150 * time.Millisecond))
+	if s := m.Summary(); s.State != MonitorUp {
+		t.Fatalf("state %s after one slow probe, want up", s.State)
+	}
+	m.record(ok(150 * time.Millisecond))
+	if s := m.Summary(); s.State != MonitorDegraded {
+		t.Fatalf("state %s, want degraded", s.State)
+	}
+	var last MonitorEvent
+	var results int
+	for _, ev := range *events {
+		if ev.Type == "result" {
+			results++
+		} else {
+			last = ev
+		}
+	}
+	if results != 3 {
+		t.Fatalf("%d result events, want 3", results)
+	}
+	if last.To != MonitorDegraded || last.Reason != "rtt 150ms over 100ms" || last.Result.Status != MonitorDegraded {
+		t.Fatalf("event %+v", last)
+	}
+	if h := m.History(); h[1].Status != MonitorDegraded || h[0].Status != MonitorUp {
+		t.Fatalf("history statuses %s, %s", h[0].Status, h[1].Status)
+	}
+}
+
+func TestMonitorLoss(t *testing.T) {
+	m, events := newTestMonitor(t, MonitorOptions{DegradedLossPercent: 50, Window: 4, Rise: 1})
+	for _, r := range []MonitorResult{ok(10 * time.Millisecond), failed, ok(30 * time.Millisecond)} {
+		m.record(r)
+	}
+	s := m.Summary()
+	// The window holds ok, failed, ok: a third lost, under the threshold.
+	if s.State != MonitorUp || s.Probes != 3 || s.Failures != 1 || s.Loss != 1.0/3 {
+		t.Fatalf("summary %+v", s)
+	}
+	if s.AvgRTT != 20*time.Millisecond || s.LastRTT != 30*time.Millisecond {
+		t.Fatalf("avg rtt %v last %v: failures must not count", s.AvgRTT, s.LastRTT)
+	}
+	m.record(failed)
+	m.record(ok(10 * time.Millisecond)) // window: ok, failed, ok, failed, ok -> newest 4 half lost
+	if s := m.Summary(); s.Loss != 0.5 || s.State != MonitorDegraded {
+		t.Fatalf("summary %+v", s)
+	}
+	ev := (*events)[len(*events)-1]
+	if ev.Reason != "50% loss over the last 4 probes" {
+		t.Fatalf("reason %q", ev.Reason)
+	}
+	for i := 0; i < 4; i++ {
+		m.record(ok(10 * time.Millisecond))
+	}
+	if s := m.Summary(); s.Loss != 0 || s.State != MonitorUp || s.Failures != 2 {
+		t.Fatalf("summary %+v", s)
+	}
+}
+
+func TestMonitorHistoryWraps(t *testing.T) {
+	m, _ := newTestMonitor(t, MonitorOptions{History: 3, Window: 3})
+	for i := 1; i <= 7; i++ {
+		r := ok(time.Duration(i) * time.Millisecond)
+		if i == 6 {
+			r = failed
+		}
+		m.record(r)
+		var rtts []time.Duration
+		for _, h := range m.History() {
+			rtts = append(rtts, h.RTT)
+		}
+		if len(rtts) != min(i, 3) {
+			t.Fatalf("after %d: %d results kept", i, len(rtts))
+		}
+	}
+	h := m.History()
+	if h[0].RTT != 5*time.Millisecond || h[1].Error == "" || h[2].RTT != 7*time.Millisecond {
+		t.Fatalf("history %+v, want 5ms, failure, 7ms oldest first", h)
+	}
+	if h[1].Status != MonitorDown || h[2].Status != MonitorUp {
+		t.Fatalf("statuses %s %s", h[1].Status, h[2].Status)
+	}
+	s := m.Summary()
+	if s.Probes != 7 || s.LastRTT != 7*time.Millisecond || s.LastError != "" || s.Loss != 1.0/3 {
+		t.Fatalf("summary %+v", s)
+	}
+}
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"nettest/pkg/dns/mdns"
+	"nettest/pkg/dns/tracing"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// requestMulticast sends the query to the mDNS group and merges every answer received
+// within the window. Multicast cannot be tunnelled, so proxies are rejected.
+func (d *DnsRequestType) requestMulticast(ctx context.Context, msg *dns.Msg) (*DnsResultType, error) {
+	if strings.TrimSpace(d.socks5Proxy) != "" {
+		return nil, errors.New("mdns does not support proxies")
+	}
+	opts, err := mdns.ParseServer(d.server)
+	if err != nil {
+		return nil, err
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = mdns.DefaultWindow
+	}
+	ctx, cancel := context.WithTimeout(ctx, window+time.Second)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "dns.exchange", tracing.String("network.transport", "udp"))
+	start := time.Now()
+	responses, err := mdns.Query(ctx, opts, msg)
+	span.SetAttributes(tracing.Int("mdns.responders", len(responses)))
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range responses {
+		tracing.Record(ctx, "mdns.response", start, start.Add(r.RTT), nil,
+			tracing.String("server.address", r.From.String()),
+			tracing.String("dns.rcode", dns.RcodeToString[r.Msg.Rcode]),
+			tracing.Int("dns.answer.count", len(r.Msg.Answer)))
+	}
+	if len(responses) == 0 {
+		return nil, errors.New("no mdns responses within " + window.String())
+	}
+	return &DnsResultType{
+		id:         d.id,
+		rtt:        time.Since(start),
+		answer:     mdns.Merge(msg, responses),
+		responders: responses,
+	}, nil
+}
+
+// DnsSdBrowseJson browses DNS-SD services on the local link.
+// Fields: interface, ipv6, service (e.g. "_ipp._tcp.local"; empty enumerates all types), window_ms.
+// Example: {"interface":"eth0","service":"_ipp._tcp.local","window_ms":1500}
+func DnsSdBrowseJson(jsonStr string) string {
+	var in struct {
+		Interface string `json:"interface"`
+		IPv6      bool   `json:"ipv6"`
+		Service   string `json:"service"`
+		WindowMs  int    `json:"window_ms"`
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	opts := mdns.Options{
+		Interface: in.Interface,
+		IPv6:      in.IPv6,
+		Window:    time.Duration(in.WindowMs) * time.Millisecond,
+	}
+	start := time.Now()
+	res, err := mdns.Browse(context.Background(), opts, strings.TrimSpace(in.Service))
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	data := map[string]interface{}{
+		"elapsed":   time.Since(start),
+		"services":  res.Services,
+		"instances": res.Instances,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"nettest/pkg/dns/profile"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// RunProfile runs p's suites through the same query path as
+// DnsRequestWithOptions and returns the aggregated report. Results also go to
+// metrics and open history files, with "profile" as the history source.
+func RunProfile(ctx context.Context, p *profile.Profile, opts profile.RunOptions) (*profile.Report, error) {
+	return profile.Run(ctx, p, opts, profileQuery)
+}
+
+func profileQuery(ctx context.Context, t profile.Target) (*dns.Msg, time.Duration, error) {
+	opts := DnsRequestOptions{
+		Server:       t.Server.URL,
+		Qname:        t.Query.Name,
+		Qtype:        t.Query.Type,
+		Qclass:       t.Query.Class,
+		ClientSubnet: t.Query.Subnet,
+		Bootstrap:    t.Server.Bootstrap,
+		Resolve:      t.Server.Resolve,
+		IPVersion:    t.Server.IPVersion,
+		Cache:        t.Cache,
+		TimeoutMs:    t.TimeoutMs,
+	}
+	if t.Server.TLS != nil {
+		opts.SNI = t.Server.TLS.SNI
+	}
+	if px := t.Proxy; px != nil {
+		opts.Proxy = px.URL
+		opts.ProxyChain = px.Chain
+		opts.ProxyReuse = px.Reuse
+		opts.ProxyInsecure = px.TLS != nil && px.TLS.Insecure
+	}
+	req := opts.request()
+	req.id = "profile"
+	res, err := req.RequestContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.answer, res.rtt, nil
+}
+
+// ProfileSource is a profile given as a file path or inline YAML/JSON text.
+type ProfileSource struct {
+	Path string `json:"path"`
+	Text string `json:"text"`
+}
+
+func (s ProfileSource) load() (*profile.Profile, error) {
+	switch {
+	case s.Path != "":
+		return profile.Load(s.Path)
+	case s.Text != "":
+		return profile.Parse([]byte(s.Text))
+	}
+	return nil, errors.New("path or text required")
+}
+
+// ProfileLoadJson parses and validates a profile and returns it with defaults
+// filled in, so frontends can show its server groups. Fields: path or text.
+// Example: {"path":"profiles/default.yaml"}
+func ProfileLoadJson(jsonStr string) string {
+	var src ProfileSource
+	if err := json.Unmarshal([]byte(jsonStr), &src); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	p, err := src.load()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return marshalResult(map[string]interface{}{"profile": p})
+}
+
+// RunProfileJson runs a profile and returns its report.
+// Fields: path or text, suites (names, default all), servers (group or
+// "group/name" entries that further limit every suite).
+// Example: {"path":"profiles/default.yaml","suites":["resolve"]}
+// Example: {"text":"servers: {lan: [{url: 'udp://192.168.1.1'}]}\nsuites: [{queries: [{name: example.com}]}]"}
+func RunProfileJson(jsonStr string) string {
+	var req struct {
+		ProfileSource
+		profile.RunOptions
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &req); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	p, err := req.load()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	rep, err := RunProfile(context.Background(), p, req.RunOptions)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return marshalResult(rep)
+}
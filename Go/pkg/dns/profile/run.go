@@ -0,0 +1,351 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Target is one query against one server with the profile's references and
+// defaults resolved.
+type Target struct {
+	Suite     string
+	Server    Ref
+	Proxy     *Proxy // nil dials directly
+	Query     Query
+	TimeoutMs int
+	Cache     bool
+}
+
+// QueryFunc sends t's query once and returns the response and its RTT.
+type QueryFunc func(ctx context.Context, t Target) (*dns.Msg, time.Duration, error)
+
+// RunOptions narrows a run to some suites or servers.
+type RunOptions struct {
+	Suites []string `json:"suites,omitempty"` // suite names; empty runs all
+	// Servers further limits every suite to these group or "group/name" entries.
+	Servers []string `json:"servers,omitempty"`
+}
+
+// Report is the aggregated outcome of a run. A check is one query against
+// one server, repeated; it passes when every run passed.
+type Report struct {
+	Profile string          `json:"profile"`
+	Started time.Time       `json:"started"`
+	Elapsed time.Duration   `json:"elapsed"`
+	Checks  int             `json:"checks"`
+	Passed  int             `json:"passed"`
+	Failed  int             `json:"failed"`
+	Runs    int             `json:"runs"`
+	Suites  []*SuiteReport  `json:"suites"`
+	Servers []*ServerReport `json:"servers"`
+}
+
+// SuiteReport holds a suite's checks in query then server order.
+type SuiteReport struct {
+	Name    string    `json:"name"`
+	Checks  int       `json:"checks"`
+	Passed  int       `json:"passed"`
+	Failed  int       `json:"failed"`
+	Results []*Result `json:"results"`
+}
+
+// Result is one check.
+type Result struct {
+	Server string `json:"server"` // group/name
+	URL    string `json:"url"`
+	Qname  string `json:"qname"`
+	Qtype  string `json:"qtype"`
+	Pass   bool   `json:"pass"`
+	Runs   int    `json:"runs"`
+	Passed int    `json:"passed"`
+	// Errors counts runs that got no response at all.
+	Errors int            `json:"errors"`
+	Rcodes map[string]int `json:"rcodes"`
+	MinRTT time.Duration  `json:"min_rtt"`
+	AvgRTT time.Duration  `json:"avg_rtt"`
+	MaxRTT time.Duration  `json:"max_rtt"`
+	// Answers of the last response, as "TYPE rdata".
+	Answers []string `json:"answers"`
+	// Failures are the distinct reasons runs failed, at most maxFailures.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// ServerReport sums a server's checks over all suites.
+type ServerReport struct {
+	Server string        `json:"server"`
+	URL    string        `json:"url"`
+	Checks int           `json:"checks"`
+	Passed int           `json:"passed"`
+	Runs   int           `json:"runs"`
+	Errors int           `json:"errors"`
+	Loss   float64       `json:"loss"` // Errors / Runs
+	AvgRTT time.Duration `json:"avg_rtt"`
+}
+
+const maxFailures = 5
+
+// Run sends every selected suite's queries to its servers, at most
+// Defaults.Concurrency checks at a time, and aggregates the results.
+func Run(ctx context.Context, p *Profile, opts RunOptions, query QueryFunc) (*Report, error) {
+	suites, err := p.suites(opts.Suites)
+	if err != nil {
+		return nil, err
+	}
+	var only map[string]bool
+	if len(opts.Servers) > 0 {
+		refs, err := p.Select(opts.Servers)
+		if err != nil {
+			return nil, err
+		}
+		only = make(map[string]bool)
+		for _, r := range refs {
+			only[r.ID()] = true
+		}
+	}
+
+	rep := &Report{Profile: p.Name, Started: time.Now()}
+	type job struct {
+		t   Target
+		res *Result
+	}
+	var jobs []job
+	for _, st := range suites {
+		sr := &SuiteReport{Name: st.Name, Results: []*Result{}}
+		rep.Suites = append(rep.Suites, sr)
+		refs, _ := p.Select(st.Servers)
+		for _, q := range st.Queries {
+			for _, ref := range refs {
+				if only != nil && !only[ref.ID()] {
+					continue
+				}
+				t := p.target(st, ref, q)
+				res := &Result{Server: ref.ID(), URL: ref.URL, Qname: q.Name, Qtype: q.Type, Rcodes: make(map[string]int), Answers: []string{}}
+				sr.Results = append(sr.Results, res)
+				jobs = append(jobs, job{t, res})
+			}
+		}
+	}
+
+	sem := make(chan struct{}, p.Defaults.Concurrency)
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(j job) {
+			defer func() { <-sem; wg.Done() }()
+			check(ctx, j.t, query, j.res)
+		}(j)
+	}
+	wg.Wait()
+	rep.Elapsed = time.Since(rep.Started)
+	rep.summarize()
+	return rep, ctx.Err()
+}
+
+// suites returns the named suites in file order, or all of them.
+func (p *Profile) suites(names []string) ([]Suite, error) {
+	if len(p.Suites) == 0 {
+		return nil, errors.New("profile has no suites")
+	}
+	if len(names) == 0 {
+		return p.Suites, nil
+	}
+	want := make(map[string]bool)
+	for _, n := range names {
+		want[n] = true
+	}
+	var out []Suite
+	for _, st := range p.Suites {
+		if want[st.Name] {
+			out = append(out, st)
+			delete(want, st.Name)
+		}
+	}
+	for n := range want {
+		return nil, fmt.Errorf("unknown suite %q", n)
+	}
+	return out, nil
+}
+
+// target applies the suite's overrides and the profile's defaults to q.
+func (p *Profile) target(st Suite, ref Ref, q Query) Target {
+	t := Target{Suite: st.Name, Server: ref, Query: q, TimeoutMs: p.Defaults.TimeoutMs, Cache: p.Defaults.Cache}
+	if st.TimeoutMs > 0 {
+		t.TimeoutMs = st.TimeoutMs
+	}
+	if t.Query.Repeat <= 0 {
+		t.Query.Repeat = st.Repeat
+	}
+	if t.Query.Repeat <= 0 {
+		t.Query.Repeat = p.Defaults.Repeat
+	}
+	proxy := ref.Proxy
+	if st.Proxy != "" {
+		proxy = st.Proxy
+	}
+	if px, ok := p.Proxies[proxy]; ok {
+		t.Proxy = &px
+	}
+	return t
+}
+
+// check runs t Repeat times, one after another, into res.
+func check(ctx context.Context, t Target, query QueryFunc, res *Result) {
+	var sum time.Duration
+	answered := 0
+	fail := func(reason string) {
+		for _, f := range res.Failures {
+			if f == reason {
+				return
+			}
+		}
+		if len(res.Failures) < maxFailures {
+			res.Failures = append(res.Failures, reason)
+		}
+	}
+	for i := 0; i < t.Query.Repeat && ctx.Err() == nil; i++ {
+		res.Runs++
+		msg, rtt, err := query(ctx, t)
+		if err == nil && msg == nil {
+			err = errors.New("empty response")
+		}
+		if err != nil {
+			res.Errors++
+			fail(err.Error())
+			continue
+		}
+		answered++
+		sum += rtt
+		if res.MinRTT == 0 || rtt < res.MinRTT {
+			res.MinRTT = rtt
+		}
+		if rtt > res.MaxRTT {
+			res.MaxRTT = rtt
+		}
+		res.Rcodes[dns.RcodeToString[msg.Rcode]]++
+		res.Answers = answers(msg)
+		reasons := t.Query.Expect.Check(msg, rtt)
+		for _, r := range reasons {
+			fail(r)
+		}
+		if len(reasons) == 0 {
+			res.Passed++
+		}
+	}
+	if answered > 0 {
+		res.AvgRTT = sum / time.Duration(answered)
+	}
+	res.Pass = res.Runs > 0 && res.Passed == res.Runs
+}
+
+func (rep *Report) summarize() {
+	byServer := make(map[string]*ServerReport)
+	rtt := make(map[string]time.Duration)
+	for _, sr := range rep.Suites {
+		for _, r := range sr.Results {
+			sr.Checks++
+			rep.Runs += r.Runs
+			if r.Pass {
+				sr.Passed++
+			} else {
+				sr.Failed++
+			}
+			s := byServer[r.Server]
+			if s == nil {
+				s = &ServerReport{Server: r.Server, URL: r.URL}
+				byServer[r.Server] = s
+				rep.Servers = append(rep.Servers, s)
+			}
+			s.Checks++
+			if r.Pass {
+				s.Passed++
+			}
+			s.Runs += r.Runs
+			s.Errors += r.Errors
+			rtt[r.Server] += r.AvgRTT * time.Duration(r.Runs-r.Errors)
+		}
+		rep.Checks += sr.Checks
+		rep.Passed += sr.Passed
+		rep.Failed += sr.Failed
+	}
+	for _, s := range rep.Servers {
+		if s.Runs > 0 {
+			s.Loss = float64(s.Errors) / float64(s.Runs)
+		}
+		if n := s.Runs - s.Errors; n > 0 {
+			s.AvgRTT = rtt[s.Server] / time.Duration(n)
+		}
+	}
+	sort.Slice(rep.Servers, func(i, j int) bool { return rep.Servers[i].Server < rep.Servers[j].Server })
+}
+
+// Check returns why msg does not meet e, or nothing when it does.
+func (e *Expect) Check(msg *dns.Msg, rtt time.Duration) []string {
+	if e == nil {
+		return nil
+	}
+	var reasons []string
+	if rcode := dns.RcodeToString[msg.Rcode]; e.Rcode != "" && rcode != e.Rcode {
+		reasons = append(reasons, fmt.Sprintf("rcode %s, want %s", rcode, e.Rcode))
+	}
+	if e.MinAnswers > 0 && len(msg.Answer) < e.MinAnswers {
+		reasons = append(reasons, fmt.Sprintf("%d answers, want at least %d", len(msg.Answer), e.MinAnswers))
+	}
+	if e.MaxRTTMs > 0 && rtt > time.Duration(e.MaxRTTMs)*time.Millisecond {
+		reasons = append(reasons, fmt.Sprintf("rtt %v over %dms", rtt.Round(time.Millisecond), e.MaxRTTMs))
+	}
+	for _, want := range e.Contains {
+		if !hasAnswer(msg.Answer, want) {
+			reasons = append(reasons, fmt.Sprintf("no answer %q", want))
+		}
+	}
+	if len(e.Answers) > 0 {
+		match := len(e.Answers) == len(msg.Answer)
+		for _, want := range e.Answers {
+			match = match && hasAnswer(msg.Answer, want)
+		}
+		if !match {
+			reasons = append(reasons, fmt.Sprintf("answers [%s], want [%s]",
+				strings.Join(answers(msg), ", "), strings.Join(e.Answers, ", ")))
+		}
+	}
+	return reasons
+}
+
+// hasAnswer matches want against each RR's rdata, with or without the type
+// in front, ignoring case and a trailing dot on names.
+func hasAnswer(rrs []dns.RR, want string) bool {
+	want = normalize(want)
+	for _, rr := range rrs {
+		data := normalize(Rdata(rr))
+		if want == data || want == normalize(dns.TypeToString[rr.Header().Rrtype]+" "+data) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Join(strings.Fields(s), " ")), ".")
+}
+
+// Rdata is rr without its owner, TTL, class and type: the form Expect answers
+// are written in, and what dig +short prints.
+func Rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+func answers(msg *dns.Msg) []string {
+	out := make([]string, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		out = append(out, dns.TypeToString[rr.Header().Rrtype]+" "+Rdata(rr))
+	}
+	return out
+}
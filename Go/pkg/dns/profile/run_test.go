@@ -0,0 +1,203 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func reply(rcode int, rrs ...string) *dns.Msg {
+	m := new(dns.Msg)
+	m.Rcode = rcode
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	return m
+}
+
+func TestExpectCheck(t *testing.T) {
+	msg := reply(dns.RcodeSuccess, "www.example.com. 300 IN CNAME Edge.Example.NET.", "edge.example.net. 60 IN A 192.0.2.1")
+	tests := []struct {
+		name   string
+		expect *Expect
+		rtt    time.Duration
+		want   []string
+	}{
+		{"nil", nil, time.Second, nil},
+		{"pass", &Expect{Rcode: "NOERROR", MinAnswers: 2, MaxRTTMs: 100, Contains: []string{"192.0.2.1"}}, 50 * time.Millisecond, nil},
+		{"rcode", &Expect{Rcode: "NXDOMAIN"}, 0, []string{"rcode NOERROR, want NXDOMAIN"}},
+		{"min answers", &Expect{MinAnswers: 3}, 0, []string{"2 answers, want at least 3"}},
+		{"slow", &Expect{MaxRTTMs: 100}, 1234 * time.Millisecond, []string{"rtt 1.234s over 100ms"}},
+		{"contains with type and case", &Expect{Contains: []string{"cname edge.example.net", "A 192.0.2.1"}}, 0, nil},
+		{"contains missing", &Expect{Contains: []string{"192.0.2.2"}}, 0, []string{`no answer "192.0.2.2"`}},
+		{"exact set any order", &Expect{Answers: []string{"192.0.2.1", "CNAME edge.example.net."}}, 0, nil},
+		{"exact set short", &Expect{Answers: []string{"192.0.2.1"}}, 0,
+			[]string{"answers [CNAME Edge.Example.NET., A 192.0.2.1], want [192.0.2.1]"}},
+		{"several", &Expect{Rcode: "SERVFAIL", MinAnswers: 5}, 0, []string{"rcode NOERROR, want SERVFAIL", "2 answers, want at least 5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.expect.Check(msg, tt.rtt); strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeQuery answers through answer, telling it how often the server was asked
+// the name before, and records every target.
+type fakeQuery struct {
+	mu      sync.Mutex
+	targets []Target
+	answer  func(t Target, n int) (*dns.Msg, time.Duration, error)
+	calls   map[string]int
+}
+
+func (f *fakeQuery) query(ctx context.Context, t Target) (*dns.Msg, time.Duration, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	key := t.Server.ID() + " " + t.Query.Name
+	n := f.calls[key]
+	f.calls[key]++
+	f.targets = append(f.targets, t)
+	f.mu.Unlock()
+	return f.answer(t, n)
+}
+
+const runProfile = `name: run
+defaults: {timeout_ms: 1000, repeat: 2, concurrency: 2}
+proxies:
+  office: {url: "socks5://10.0.0.1:1080"}
+servers:
+  g:
+    - {name: good, url: "udp://192.0.2.53"}
+    - {name: flaky, url: "udp://192.0.2.54", proxy: office}
+suites:
+  - name: resolve
+    timeout_ms: 500
+    queries:
+      - {name: a.example, expect: {rcode: NOERROR, min_answers: 1}}
+      - {name: b.example, repeat: 4}
+  - name: direct
+    proxy: direct
+    servers: [g/flaky]
+    queries: [{name: c.example}]
+`
+
+func TestRun(t *testing.T) {
+	p, err := Parse([]byte(runProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeQuery{answer: func(t Target, n int) (*dns.Msg, time.Duration, error) {
+		if t.Server.Name == "flaky" && n%2 == 1 {
+			return nil, 0, errors.New("i/o timeout")
+		}
+		if t.Server.Name == "flaky" {
+			return reply(dns.RcodeServerFailure), 30 * time.Millisecond, nil
+		}
+		return reply(dns.RcodeSuccess, t.Query.Name+". 60 IN A 192.0.2.1"), 10 * time.Millisecond, nil
+	}}
+	rep, err := Run(context.Background(), p, RunOptions{}, f.query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a: 2 runs each on good and flaky; b: 4 each; c: 2 on flaky.
+	if rep.Checks != 5 || rep.Passed != 2 || rep.Failed != 3 || rep.Runs != 14 || len(f.targets) != 14 {
+		t.Fatalf("report %d checks %d passed %d failed %d runs; %d queries", rep.Checks, rep.Passed, rep.Failed, rep.Runs, len(f.targets))
+	}
+	for _, tg := range f.targets {
+		switch {
+		case tg.Suite == "resolve" && tg.TimeoutMs != 500, tg.Suite == "direct" && tg.TimeoutMs != 1000:
+			t.Fatalf("%s timeout %d", tg.Suite, tg.TimeoutMs)
+		case tg.Suite == "direct" && tg.Proxy != nil, tg.Suite == "resolve" && tg.Server.Name == "flaky" && tg.Proxy == nil:
+			t.Fatalf("%s %s proxy %v", tg.Suite, tg.Server.Name, tg.Proxy)
+		}
+	}
+
+	resolve := rep.Suites[0]
+	if resolve.Name != "resolve" || resolve.Checks != 4 || resolve.Passed != 2 {
+		t.Fatalf("suite %+v", resolve)
+	}
+	// Query then server order.
+	var order []string
+	for _, r := range resolve.Results {
+		order = append(order, r.Qname+"@"+r.Server)
+	}
+	if got := strings.Join(order, " "); got != "a.example@g/good a.example@g/flaky b.example@g/good b.example@g/flaky" {
+		t.Fatalf("order %s", got)
+	}
+	flakyA := resolve.Results[1]
+	if flakyA.Pass || flakyA.Runs != 2 || flakyA.Errors != 1 || flakyA.Rcodes["SERVFAIL"] != 1 ||
+		strings.Join(flakyA.Failures, "; ") != "rcode SERVFAIL, want NOERROR; 0 answers, want at least 1; i/o timeout" {
+		t.Fatalf("flaky a %+v", flakyA)
+	}
+	flakyB := resolve.Results[3]
+	if flakyB.Pass || flakyB.Runs != 4 || flakyB.Errors != 2 || flakyB.AvgRTT != 30*time.Millisecond {
+		t.Fatalf("flaky b %+v", flakyB)
+	}
+	if good := resolve.Results[0]; !good.Pass || good.Answers[0] != "A 192.0.2.1" || good.MinRTT != 10*time.Millisecond {
+		t.Fatalf("good a %+v", good)
+	}
+
+	if len(rep.Servers) != 2 {
+		t.Fatalf("servers %+v", rep.Servers)
+	}
+	flaky, good := rep.Servers[0], rep.Servers[1]
+	if flaky.Server != "g/flaky" || flaky.Checks != 3 || flaky.Runs != 8 || flaky.Errors != 4 || flaky.Loss != 0.5 || flaky.AvgRTT != 30*time.Millisecond {
+		t.Fatalf("flaky %+v", flaky)
+	}
+	if good.Checks != 2 || good.Passed != 2 || good.Runs != 6 || good.Loss != 0 || good.AvgRTT != 10*time.Millisecond {
+		t.Fatalf("good %+v", good)
+	}
+}
+
+func TestRunSelection(t *testing.T) {
+	p, err := Parse([]byte(runProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(ctx context.Context, t Target) (*dns.Msg, time.Duration, error) {
+		return reply(dns.RcodeSuccess, t.Query.Name+". 60 IN A 192.0.2.1"), time.Millisecond, nil
+	}
+	rep, err := Run(context.Background(), p, RunOptions{Suites: []string{"resolve"}, Servers: []string{"g/good"}}, ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Suites) != 1 || rep.Checks != 2 || rep.Failed != 0 || len(rep.Servers) != 1 || rep.Servers[0].Server != "g/good" {
+		t.Fatalf("report %+v", rep)
+	}
+	if _, err := Run(context.Background(), p, RunOptions{Suites: []string{"nope"}}, ok); err == nil {
+		t.Fatal("unknown suite accepted")
+	}
+	if _, err := Run(context.Background(), p, RunOptions{Servers: []string{"g/nope"}}, ok); err == nil {
+		t.Fatal("unknown server accepted")
+	}
+
+	// A cancelled run stops sending and reports why.
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	var mu sync.Mutex
+	rep, err = Run(ctx, p, RunOptions{}, func(ctx context.Context, t Target) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		cancel()
+		return nil, 0, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) || rep == nil || calls > p.Defaults.Concurrency {
+		t.Fatalf("err %v, %d queries after cancel", err, calls)
+	}
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"nettest/pkg/dns/dnstest"
+	"nettest/pkg/dns/profile"
+)
+
+func TestRunProfile(t *testing.T) {
+	s, urls := listenAll(t)
+	p, err := profile.Parse([]byte(fmt.Sprintf(`servers:
+  test: [{name: udp, url: %q}, {name: tls, url: %q}]
+defaults: {timeout_ms: 10000}
+suites:
+  - queries:
+      - {name: www.example.test, expect: {answers: [192.0.2.1]}}
+      - {name: nope.example.test, expect: {rcode: NXDOMAIN}}
+`, urls["udp"], urls["tls"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := RunProfile(context.Background(), p, profile.RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checks != 4 || rep.Passed != 4 {
+		t.Fatalf("report %+v", rep.Suites[0].Results)
+	}
+
+	// Cancelling the run abandons queries still waiting on the server.
+	s.SetScript(dnstest.Always(dnstest.Fault{Drop: true}))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rep, err = RunProfile(ctx, p, profile.RunOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 3*time.Second {
+		t.Fatalf("err %v after %v", err, time.Since(start))
+	}
+	if rep.Failed != 4 {
+		t.Fatalf("report %+v", rep)
+	}
+}
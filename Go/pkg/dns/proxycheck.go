@@ -0,0 +1,269 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/transport"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// ProxyCheckOptions describes a batch of proxies to health-check.
+type ProxyCheckOptions struct {
+	Proxies       []string `json:"proxies"`
+	ProxyInsecure bool     `json:"proxy_insecure"`
+	// DNSServer is an "ip:port" queried over TCP (CONNECT) and UDP (UDP ASSOCIATE).
+	DNSServer string `json:"dns_server"`
+	// ProbeHost is a "hostname:port" DNS server reached by name, so the proxy must resolve it.
+	ProbeHost string `json:"probe_host"`
+	Qname     string `json:"qname"`
+	// TimeoutMs bounds each probe; Concurrency bounds how many proxies are checked at once.
+	TimeoutMs   int `json:"timeout_ms"`
+	Concurrency int `json:"concurrency"`
+}
+
+// ProbeResult is the outcome of one capability probe.
+type ProbeResult struct {
+	OK    bool          `json:"ok"`
+	RTT   time.Duration `json:"rtt"`
+	Error string        `json:"error,omitempty"`
+}
+
+// ProxyCheckResult is the report for a single proxy.
+type ProxyCheckResult struct {
+	Rank        int           `json:"rank"`
+	Proxy       string        `json:"proxy"` // password redacted
+	Type        string        `json:"type"`
+	Addr        string        `json:"addr"`
+	Reachable   bool          `json:"reachable"`
+	Connect     time.Duration `json:"connect"`
+	Handshake   time.Duration `json:"handshake"`
+	AuthMethods []string      `json:"auth_methods"`
+	Auth        ProbeResult   `json:"auth"`           // handshake with the configured credentials
+	TCP         ProbeResult   `json:"tcp"`            // DNS over TCP to dns_server
+	UDP         ProbeResult   `json:"udp"`            // DNS over UDP to dns_server
+	RemoteDNS   ProbeResult   `json:"remote_resolve"` // DNS over TCP to probe_host, resolved by the proxy
+	Score       int           `json:"score"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Probe targets used when dns_server or probe_host is empty; tests point them
+// at a local server.
+var (
+	proxyCheckDNSServer = "8.8.8.8:53"
+	proxyCheckProbeHost = "dns.google:53"
+)
+
+// CheckProxies probes every proxy concurrently and returns the results ranked best first:
+// more working capabilities win, then lower connect+handshake latency.
+func CheckProxies(ctx context.Context, opts ProxyCheckOptions) []*ProxyCheckResult {
+	if opts.DNSServer == "" {
+		opts.DNSServer = proxyCheckDNSServer
+	}
+	if opts.ProbeHost == "" {
+		opts.ProbeHost = proxyCheckProbeHost
+	}
+	if opts.Qname == "" {
+		opts.Qname = "example.com"
+	}
+	if opts.TimeoutMs <= 0 {
+		opts.TimeoutMs = 5000
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+
+	results := make([]*ProxyCheckResult, len(opts.Proxies))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, raw := range opts.Proxies {
+		wg.Add(1)
+		go func(i int, raw string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = checkProxy(ctx, raw, opts)
+		}(i, raw)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Reachable != b.Reachable {
+			return a.Reachable
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Connect+a.Handshake < b.Connect+b.Handshake
+	})
+	for i, r := range results {
+		r.Rank = i + 1
+	}
+	return results
+}
+
+func checkProxy(ctx context.Context, raw string, opts ProxyCheckOptions) *ProxyCheckResult {
+	res := &ProxyCheckResult{Proxy: raw, AuthMethods: []string{}}
+	proxy, err := transport.ParseProxyURL(raw)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	proxy.TLS.InsecureSkipVerify = opts.ProxyInsecure
+	res.Proxy, res.Type, res.Addr = redactProxy(proxy), proxy.Type, proxy.Addr
+
+	timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+	// Hand hostnames to the proxy so remote_resolve tests the proxy, not us.
+	proxy.LocalResolve = false
+	d, err := transport.NewProxyDialer(proxy, transport.DialOptions{Timeout: timeout})
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	probeCtx := func() (context.Context, context.CancelFunc) { return context.WithTimeout(ctx, timeout) }
+
+	if p, ok := d.(transport.ProxyProber); ok {
+		c, cancel := probeCtx()
+		st, err := p.ProbeHandshake(c, opts.DNSServer)
+		cancel()
+		res.Connect, res.Handshake = st.Connect, st.Handshake
+		res.Reachable = st.Connect > 0
+		res.Auth = probeResult(st.Connect+st.Handshake, err)
+		if !res.Reachable {
+			res.Error = err.Error()
+			return res
+		}
+		c, cancel = probeCtx()
+		methods, err := p.ProbeAuthMethods(c, opts.DNSServer)
+		cancel()
+		if err == nil {
+			res.AuthMethods = append(res.AuthMethods, methods...)
+		}
+	}
+
+	res.TCP = probeTCP(ctx, d, opts.DNSServer, opts.Qname, timeout)
+	res.UDP = probeUDP(ctx, d, opts.DNSServer, opts.Qname, timeout)
+	res.RemoteDNS = probeTCP(ctx, d, opts.ProbeHost, opts.Qname, timeout)
+	for _, p := range []ProbeResult{res.TCP, res.UDP, res.RemoteDNS} {
+		if p.OK {
+			res.Score++
+		}
+	}
+	return res
+}
+
+// probeTCP sends one DNS query over a CONNECT tunnel to address.
+func probeTCP(ctx context.Context, d transport.Dialer, address, qname string, timeout time.Duration) ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return probeResult(time.Since(start), err)
+	}
+	defer conn.Close()
+	_, err = exchangeProbe(ctx, &dns.Conn{Conn: conn}, qname)
+	return probeResult(time.Since(start), err)
+}
+
+// probeUDP sends one DNS query through the proxy's UDP relay, if it has one.
+func probeUDP(ctx context.Context, d transport.Dialer, address, qname string, timeout time.Duration) ProbeResult {
+	pd, ok := d.(transport.PacketDialer)
+	if !ok {
+		return probeResult(0, transport.ErrUDPUnsupported)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	pc, err := pd.DialPacket(ctx, "udp", address)
+	if err != nil {
+		return probeResult(time.Since(start), err)
+	}
+	defer pc.Close()
+	conn, ok := pc.(net.Conn)
+	if !ok {
+		return probeResult(time.Since(start), fmt.Errorf("unexpected packet conn %T", pc))
+	}
+	_, err = exchangeProbe(ctx, &dns.Conn{Conn: conn}, qname)
+	return probeResult(time.Since(start), err)
+}
+
+func exchangeProbe(ctx context.Context, co *dns.Conn, qname string) (*dns.Msg, error) {
+	if d, ok := ctx.Deadline(); ok {
+		_ = co.SetDeadline(d)
+	}
+	m := buildDnsMassage(qname, "A", "IN")
+	if err := co.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	for {
+		r, err := co.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if r.Id != m.Id {
+			continue
+		}
+		if r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused {
+			return r, errors.New("dns " + dns.RcodeToString[r.Rcode])
+		}
+		return r, nil
+	}
+}
+
+func probeResult(rtt time.Duration, err error) ProbeResult {
+	if err != nil {
+		return ProbeResult{RTT: rtt, Error: err.Error()}
+	}
+	return ProbeResult{OK: true, RTT: rtt}
+}
+
+func redactProxy(p transport.ProxyOptions) string {
+	u := url.URL{Scheme: p.Type, Host: p.Addr}
+	if p.User != "" || p.Pass != "" {
+		u.User = url.UserPassword(p.User, p.Pass)
+	}
+	return u.Redacted()
+}
+
+// ProxyCheckJson health-checks one or many proxies and returns a ranked report.
+// Fields: proxies, proxy_insecure, dns_server, probe_host, qname, timeout_ms, concurrency.
+// Example: {"proxies":["socks5://10.0.0.1:1080","http://u:p@10.0.0.2:3128"],"dns_server":"1.1.1.1:53"}
+func ProxyCheckJson(jsonStr string) string {
+	var opts ProxyCheckOptions
+	if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	var proxies []string
+	for _, p := range opts.Proxies {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if len(proxies) == 0 {
+		return utils.BuildErrJSON(errors.New("no proxies to check"))
+	}
+	opts.Proxies = proxies
+	start := time.Now()
+	results := CheckProxies(context.Background(), opts)
+	data := map[string]interface{}{
+		"elapsed": time.Since(start),
+		"results": results,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
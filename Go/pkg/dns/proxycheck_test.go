@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	s5 "github.com/txthinking/socks5"
+)
+
+// serveLoop accepts on a loopback listener until the test ends.
+func serveLoop(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(b, a)
+		b.Close()
+	}()
+	_, _ = io.Copy(a, b)
+}
+
+// startSocks5 runs a SOCKS5 proxy that resolves names itself and supports
+// CONNECT and UDP ASSOCIATE; with user set it only accepts that user.
+func startSocks5(t *testing.T, user, pass string) string {
+	return serveLoop(t, func(c net.Conn) {
+		rq, err := s5.NewNegotiationRequestFrom(c)
+		if err != nil {
+			return
+		}
+		want := byte(s5.MethodNone)
+		if user != "" {
+			want = s5.MethodUsernamePassword
+		}
+		if !strings.ContainsRune(string(rq.Methods), rune(want)) {
+			_, _ = s5.NewNegotiationReply(s5.MethodUnsupportAll).WriteTo(c)
+			return
+		}
+		if _, err := s5.NewNegotiationReply(want).WriteTo(c); err != nil {
+			return
+		}
+		if user != "" {
+			up, err := s5.NewUserPassNegotiationRequestFrom(c)
+			if err != nil {
+				return
+			}
+			status := byte(s5.UserPassStatusSuccess)
+			if string(up.Uname) != user || string(up.Passwd) != pass {
+				status = s5.UserPassStatusFailure
+			}
+			if _, err := s5.NewUserPassNegotiationReply(status).WriteTo(c); err != nil || status != s5.UserPassStatusSuccess {
+				return
+			}
+		}
+		r, err := s5.NewRequestFrom(c)
+		if err != nil {
+			return
+		}
+		zero := []byte{0, 0}
+		switch r.Cmd {
+		case s5.CmdConnect:
+			target, err := net.Dial("tcp", r.Address())
+			if err != nil {
+				_, _ = s5.NewReply(s5.RepHostUnreachable, s5.ATYPIPv4, []byte{0, 0, 0, 0}, zero).WriteTo(c)
+				return
+			}
+			defer target.Close()
+			if _, err := s5.NewReply(s5.RepSuccess, s5.ATYPIPv4, []byte{0, 0, 0, 0}, zero).WriteTo(c); err != nil {
+				return
+			}
+			pipe(c, target)
+		case s5.CmdUDP:
+			relayUDP(c)
+		}
+	})
+}
+
+// relayUDP forwards datagrams between the client and their targets for as
+// long as the control connection c stays open.
+func relayUDP(c net.Conn) {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	out, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	port := relay.LocalAddr().(*net.UDPAddr).Port
+	if _, err := s5.NewReply(s5.RepSuccess, s5.ATYPIPv4, []byte{127, 0, 0, 1}, []byte{byte(port >> 8), byte(port)}).WriteTo(c); err != nil {
+		return
+	}
+	client := make(chan *net.UDPAddr, 1)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case client <- from:
+			default:
+			}
+			d, err := s5.NewDatagramFromBytes(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				continue
+			}
+			if to, err := net.ResolveUDPAddr("udp", d.Address()); err == nil {
+				_, _ = out.WriteTo(d.Data, to)
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		var to *net.UDPAddr
+		for {
+			n, from, err := out.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if to == nil {
+				to = <-client
+			}
+			a, h, p, err := s5.ParseAddress(from.String())
+			if err != nil {
+				continue
+			}
+			_, _ = relay.WriteTo(s5.NewDatagram(a, h, p, buf[:n]).Bytes(), to)
+		}
+	}()
+	_, _ = io.Copy(io.Discard, c)
+}
+
+// startHTTPConnect runs an HTTP CONNECT proxy without authentication.
+func startHTTPConnect(t *testing.T) string {
+	return serveLoop(t, func(c net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer target.Close()
+		if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+		pipe(c, target)
+	})
+}
+
+// startDNS answers every query with 192.0.2.1 over UDP and TCP on the same
+// loopback port and returns that address.
+func startDNS(t *testing.T) string {
+	t.Helper()
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		_ = w.WriteMsg(m)
+	})
+	for attempt := 0; attempt < 10; attempt++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+		for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: ln, Handler: handler}} {
+			started := make(chan struct{})
+			srv.NotifyStartedFunc = func() { close(started) }
+			go func() { _ = srv.ActivateAndServe() }()
+			<-started
+			t.Cleanup(func() { _ = srv.Shutdown() })
+		}
+		return pc.LocalAddr().String()
+	}
+	t.Fatal("no free port for both udp and tcp")
+	return ""
+}
+
+func TestCheckProxies(t *testing.T) {
+	addr := startDNS(t)
+	_, port, _ := net.SplitHostPort(addr)
+	// The defaults point at public servers; use the local one instead.
+	defer func(server, host string) { proxyCheckDNSServer, proxyCheckProbeHost = server, host }(proxyCheckDNSServer, proxyCheckProbeHost)
+	proxyCheckDNSServer = addr
+	proxyCheckProbeHost = net.JoinHostPort("localhost", port)
+
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	authed := startSocks5(t, "alice", "s3cret")
+	proxies := []string{
+		"socks5://" + deadAddr,
+		"http://" + startHTTPConnect(t),
+		"socks5://mallory:s3cret@" + authed,
+		"socks5://alice:s3cret@" + authed,
+		"socks5://" + startSocks5(t, "", ""),
+	}
+	results := CheckProxies(t.Context(), ProxyCheckOptions{Proxies: proxies, Qname: "www.example.test", TimeoutMs: 2000})
+	if len(results) != len(proxies) {
+		t.Fatalf("%d results for %d proxies", len(results), len(proxies))
+	}
+	byProxy := make(map[string]*ProxyCheckResult)
+	for i, r := range results {
+		if r.Rank != i+1 {
+			t.Fatalf("result %d has rank %d", i, r.Rank)
+		}
+		byProxy[r.Proxy] = r
+	}
+
+	good := byProxy["socks5://alice:xxxxx@"+authed]
+	if good == nil || !good.Auth.OK || good.Score != 3 || !good.TCP.OK || !good.UDP.OK || !good.RemoteDNS.OK {
+		t.Fatalf("authenticated socks5: %+v", good)
+	}
+	if strings.Join(good.AuthMethods, ",") != "username/password" {
+		t.Fatalf("auth methods %q", good.AuthMethods)
+	}
+	open := byProxy[proxies[4]]
+	if open == nil || open.Score != 3 || strings.Join(open.AuthMethods, ",") != "none" {
+		t.Fatalf("open socks5: %+v", open)
+	}
+	httpProxy := byProxy[proxies[1]]
+	if httpProxy == nil || httpProxy.Score != 2 || httpProxy.UDP.OK || !httpProxy.TCP.OK || !httpProxy.RemoteDNS.OK {
+		t.Fatalf("http proxy: %+v", httpProxy)
+	}
+	if results[0].Score != 3 || results[1].Score != 3 || results[2] != httpProxy {
+		t.Fatalf("ranking %s, %s, %s: want the working socks5 proxies, then http", results[0].Proxy, results[1].Proxy, results[2].Proxy)
+	}
+	last := results[len(results)-1]
+	if last.Proxy != proxies[0] || last.Reachable || last.Error == "" {
+		t.Fatalf("unreachable proxy ranked %d: %+v", last.Rank, last)
+	}
+	wrong := results[len(results)-2]
+	if wrong.Auth.OK || wrong.Score != 0 || !wrong.Reachable {
+		t.Fatalf("wrong password: %+v", wrong)
+	}
+}
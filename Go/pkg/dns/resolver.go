@@ -3,11 +3,14 @@ package dns
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/netip"
 	"strings"
 	"time"
 
+	"nettest/pkg/dns/mdns"
 	"nettest/pkg/dns/singdns"
+	"nettest/pkg/dns/tracing"
 	"nettest/pkg/dns/transport"
 
 	"github.com/miekg/dns"
@@ -18,8 +21,25 @@ type DnsRequestType struct {
 	server       string
 	net          string
 	socks5Proxy  string
+	socks5User   string // overrides credentials embedded in socks5Proxy
+	socks5Pass   string
+	proxy        string   // any registered proxy URL; exclusive with socks5Proxy
+	proxyChain   []string // ordered proxy URLs, first hop dialed directly; exclusive with the above
+	proxyTLSSkip bool     // skip certificate checks of an https:// proxy
+	proxyReuse   bool     // share pre-authenticated SOCKS5 sessions across requests
 	sni          string
-	clientSubnet string // CIDR, e.g. "1.2.3.0/24" or "2001:db8::/56"
+	clientSubnet string   // CIDR, e.g. "1.2.3.0/24" or "2001:db8::/56"
+	bootstrap    string   // DNS server URL used to resolve a hostname-based server
+	resolve      []string // curl-style pins, "host:port:addr[,addr]"
+	sourceIP     string   // local source address for direct dials
+	iface        string   // Linux interface to bind to (SO_BINDTODEVICE)
+	ipVersion    int      // force IPv4 (4) or IPv6 (6); 0 = either
+	socket       transport.SocketOptions
+	leakCheck    bool          // record local DNS lookups made while dialing
+	cache        bool          // answer from and fill the shared response cache
+	timeout      time.Duration // overrides the per-scheme default when set
+	tsig         *TsigOptions
+	sig0         *Sig0Options
 	qname        string
 	qtype        string
 	qclass       string
@@ -29,9 +49,69 @@ type DnsResultType struct {
 	id     string
 	rtt    time.Duration
 	answer *dns.Msg
+	// Hostname resolutions done by the bootstrap dialer before the query.
+	bootstrap []transport.BootstrapRecord
+	// Per-hop dial latency when going through proxies.
+	proxyHops []transport.HopStat
+	// mDNS only: every response collected in the window; answer holds them merged.
+	responders []*mdns.Response
+	// Local DNS lookups seen while dialing, when leak checking is on.
+	leaks *transport.LeakReport
+	// Signature verification of the response, for signed queries.
+	tsig *TsigStatus
+	sig0 *Sig0Status
+	// How the shared cache handled the query, when caching is on.
+	cache singdns.CacheStatus
+	// Connect and handshake timings of the connections opened for the query.
+	dial *singdns.DialTrace
 }
 
+// Request sends the query and records it in the metrics registry, the open
+// history files and, when tracing is configured, as a dns.query span.
 func (d *DnsRequestType) Request() (*DnsResultType, error) {
+	return d.RequestContext(context.Background())
+}
+
+// RequestContext is Request bounded by ctx: cancelling ctx abandons the query,
+// and the dns.query span is a child of any span ctx carries.
+func (d *DnsRequestType) RequestContext(ctx context.Context) (*DnsResultType, error) {
+	ctx, span := tracing.Start(ctx, "dns.query", d.spanAttributes()...)
+	start := time.Now()
+	res, err := d.query(ctx)
+	observeQuery(d, res, err)
+	recordHistory(d, start, res, err)
+	if err == nil && res != nil && res.answer != nil {
+		span.SetAttributes(
+			tracing.String("dns.rcode", dns.RcodeToString[res.answer.Rcode]),
+			tracing.Int("dns.answer.count", len(res.answer.Answer)))
+		if res.cache != "" {
+			span.SetAttributes(tracing.String("dns.cache", string(res.cache)))
+		}
+	}
+	span.SetError(err)
+	span.End()
+	return res, err
+}
+
+// spanAttributes describes the query for its trace span.
+func (d *DnsRequestType) spanAttributes() []tracing.Attr {
+	attrs := []tracing.Attr{
+		tracing.String("dns.server", redactURL(d.server)),
+		tracing.String("dns.scheme", d.net),
+		tracing.String("dns.question.name", d.qname),
+		tracing.String("dns.question.type", d.qtype),
+	}
+	if proxies, err := d.proxyChainOptions(); err == nil && len(proxies) > 0 {
+		names := make([]string, len(proxies))
+		for i, p := range proxies {
+			names[i] = redactProxy(p)
+		}
+		attrs = append(attrs, tracing.String("dns.proxy", strings.Join(names, " -> ")))
+	}
+	return attrs
+}
+
+func (d *DnsRequestType) query(ctx context.Context) (*DnsResultType, error) {
 	result := &DnsResultType{
 		id: d.id,
 	}
@@ -45,208 +125,302 @@ func (d *DnsRequestType) Request() (*DnsResultType, error) {
 		return nil, errors.New("build dns message failed")
 	}
 
-	// Dialer selection (direct or socks5)
-	// Expect socks5Proxy like "socks5://host:port" or "host:port"
-	var dialer transport.Dialer
-	timeout := 5 * time.Second
-	switch d.net {
-	case "tcp-tls", "https", "tls", "quic", "https3":
-		timeout = 7 * time.Second
+	if d.net == "mdns" {
+		return d.requestMulticast(ctx, msg)
 	}
-	if p := strings.TrimSpace(d.socks5Proxy); p != "" {
-		addr := strings.TrimPrefix(p, "socks5://")
-		dialer = transport.NewSocks5Dialer(addr, "", "", transport.DialOptions{Timeout: timeout})
-	} else {
-		dialer = transport.NewDirectDialer(transport.DialOptions{Timeout: timeout})
+
+	if d.tsig != nil || d.sig0 != nil {
+		return d.requestSigned(ctx, msg)
 	}
 
-	// Address normalization handled by transport/singdns layer using d.server
+	_, tspan := tracing.Start(ctx, "dns.transport.create")
+	u, err := d.newUpstream()
+	if err != nil {
+		tspan.SetError(err)
+		tspan.End()
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	ctx, result.dial = singdns.WithDialTrace(ctx)
+	ctx = u.traceHops(ctx)
 
 	var (
 		resp *dns.Msg
 		rtt  time.Duration
-		err  error
 	)
+	t, err := d.newTransport(ctx, u.dialer)
+	tspan.SetError(err)
+	tspan.End()
+	if err == nil {
+		defer t.Close()
+		xctx, trace := singdns.WithCacheTrace(ctx)
+		xctx, xspan := tracing.Start(xctx, "dns.exchange")
+		start := time.Now()
+		resp, err = t.Exchange(xctx, msg)
+		rtt = time.Since(start)
+		result.cache = trace.Status
+		d.recordFirstFlights(xctx, result.dial)
+		xspan.SetError(err)
+		xspan.End()
+	}
+	u.report(result)
+	observeHandshakes(d, result, time.Now(), err)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, errors.New("empty response")
+	}
+	result.answer = resp
+	result.rtt = rtt
+	return result, nil
+}
 
-	// Construct scheme-qualified address for sing-dns factory
-	var serverAddr string
-	switch d.net {
-	case "udp":
-		serverAddr = "udp://" + GetNetAddress(d.server)
-	case "tcp":
-		serverAddr = "tcp://" + GetNetAddress(d.server)
-	case "tcp-tls", "tls":
-		serverAddr = "tls://" + GetNetAddress(d.server)
-	case "https":
-		serverAddr = d.server // full DoH URL
-	case "quic":
-		if strings.HasPrefix(d.server, "quic://") || strings.HasPrefix(d.server, "doq://") {
-			serverAddr = d.server
-		} else {
-			serverAddr = "quic://" + GetNetAddress(d.server)
-		}
-	case "https3":
-		if strings.HasPrefix(d.server, "https3://") || strings.HasPrefix(d.server, "http3://") || strings.HasPrefix(d.server, "h3://") || strings.HasPrefix(d.server, "https://") {
-			serverAddr = d.server
-		} else {
-			serverAddr = "https3://" + GetNetAddress(d.server)
+// recordFirstFlights adds a span per encrypted connection opened, from the end
+// of the dial to the first bytes of the server's TLS or QUIC handshake flight.
+// The handshake itself runs inside sing-dns and may go on after that.
+func (d *DnsRequestType) recordFirstFlights(ctx context.Context, trace *singdns.DialTrace) {
+	if !encryptedScheme(d.net) {
+		return
+	}
+	name := "tls.first_flight"
+	if d.net == "quic" || d.net == "https3" {
+		name = "quic.first_flight"
+	}
+	for _, dt := range trace.Snapshot() {
+		if !dt.FirstByte.IsZero() {
+			tracing.Record(ctx, name, dt.Connected, dt.FirstByte, nil, tracing.String("server.address", dt.Address))
 		}
+	}
+}
+
+// requestSigned sends msg signed with TSIG or SIG(0). sing-dns re-packs queries,
+// which would break the signature, so the exchange is done directly. The client
+// subnet is added before signing; signed answers are never cached, as each one
+// is bound to its own request.
+func (d *DnsRequestType) requestSigned(ctx context.Context, msg *dns.Msg) (*DnsResultType, error) {
+	switch d.net {
+	case "udp", "tcp", "tcp-tls", "https":
 	default:
-		serverAddr = d.server
+		return nil, errSignedScheme
+	}
+	if d.tsig != nil && d.sig0 != nil {
+		return nil, errors.New("tsig and sig0 are mutually exclusive")
+	}
+	if d.cache {
+		return nil, errors.New("signed queries cannot use the cache")
+	}
+	if p := d.subnet(); p.IsValid() {
+		singdns.SetClientSubnet(msg, p)
+	}
+	tsig, err := d.tsig.key()
+	if err != nil {
+		return nil, err
+	}
+	sig0, err := d.sig0.key()
+	if err != nil {
+		return nil, err
+	}
+	u, err := d.newUpstream()
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.exchangeWith(ctx, u, msg, signerOf(tsig, sig0), false)
+	result := &DnsResultType{id: d.id}
+	u.report(result)
+	observeHandshakes(d, result, time.Now(), err)
+	if err != nil {
+		return nil, err
 	}
+	result.answer, result.rtt = res.msg, res.rtt
+	result.tsig, result.sig0 = res.tsig, res.sig0
+	return result, nil
+}
+
+// upstream is the dialer stack built for d: direct or through proxies, optionally
+// behind a bootstrap resolver.
+type upstream struct {
+	dialer    transport.Dialer
+	dialOpts  transport.DialOptions
+	chain     *transport.ChainDialer
+	hops      *transport.HopTrace // per-hop timings of this request's proxy dials
+	bootstrap *transport.BootstrapDialer
+	timeout   time.Duration
+}
 
+func (d *DnsRequestType) newUpstream() (*upstream, error) {
+	u := &upstream{timeout: 5 * time.Second}
 	switch d.net {
-	case "udp":
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		// sing-dns path
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
-		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		// Parse client subnet if provided
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
-		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
-		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
-	case "tcp":
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
-		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
-		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
-		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
-	case "tcp-tls", "tls":
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
-		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
-		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
-		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
-	case "https":
-		ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
-		defer cancel()
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
-		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
-		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
+	case "tcp-tls", "https", "tls", "quic", "https3":
+		u.timeout = 7 * time.Second
+	}
+	if d.timeout > 0 {
+		u.timeout = d.timeout
+	}
+	var err error
+	u.dialOpts, err = d.dialOptions(u.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.leakCheck {
+		u.dialOpts.Leaks = transport.NewLeakDetector()
+	}
+	// Expect socks5Proxy like "socks5://[user:pass@]host:port" or "host:port",
+	// proxy like "http://[user:pass@]host:port", "https://..." or "socks5://..."
+	proxies, err := d.proxyChainOptions()
+	if err != nil {
+		return nil, err
+	}
+	if len(proxies) > 0 {
+		u.chain, err = transport.NewProxyChain(proxies, u.dialOpts)
+		if err != nil {
+			return nil, err
 		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
-	case "quic":
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
+		u.dialer = u.chain
+	} else {
+		u.dialer = transport.NewDirectDialer(u.dialOpts)
+	}
+	if d.bootstrap != "" || len(d.resolve) > 0 {
+		u.bootstrap, err = newBootstrapDialer(u.dialer, d.bootstrap, d.resolve, u.dialOpts.IPVersion)
+		if err != nil {
+			return nil, err
 		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
+		u.dialer = u.bootstrap
+	}
+	return u, nil
+}
+
+// traceHops returns ctx set up to collect per-hop proxy timings for report.
+func (u *upstream) traceHops(ctx context.Context) context.Context {
+	if u.chain == nil {
+		return ctx
+	}
+	ctx, u.hops = transport.WithHopTrace(ctx)
+	return ctx
+}
+
+// report copies what the dialers recorded into the result.
+func (u *upstream) report(result *DnsResultType) {
+	if u.bootstrap != nil {
+		result.bootstrap = u.bootstrap.Records()
+	}
+	if u.hops != nil {
+		result.proxyHops = u.hops.Stats()
+	}
+	if u.dialOpts.Leaks != nil {
+		report := u.dialOpts.Leaks.Report()
+		result.leaks = &report
+	}
+}
+
+// newTransport creates the sing-dns transport for d.server over dialer.
+func (d *DnsRequestType) newTransport(ctx context.Context, dialer transport.Dialer) (singdns.Transport, error) {
+	switch d.net {
+	case "udp", "tcp", "tcp-tls", "tls", "https", "quic", "https3":
+	default:
+		return nil, errors.New("unsupported net scheme: " + d.net)
+	}
+	var pd transport.PacketDialer
+	if v, ok := dialer.(transport.PacketDialer); ok {
+		pd = v
+	}
+	sd := singdns.NewDialerAdapter(dialer, pd)
+	ecs := d.subnet()
+	// Construct scheme-qualified address for sing-dns factory
+	serverAddr := getTransportAddress(d.server, d.net)
+	if !d.cache {
+		return singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
+	}
+	// Prefetches outlive the request, so the transport must not die with ctx.
+	t, err := singdns.CreateTransport(singdns.TransportOptions{Context: context.WithoutCancel(ctx), Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
+	if err != nil {
+		return nil, err
+	}
+	scope := serverAddr
+	if ecs.IsValid() {
+		scope += "|" + ecs.String()
+	}
+	return singdns.NewCachedTransport(t, sharedCache, scope), nil
+}
+
+// subnet parses clientSubnet; an empty or invalid one yields the zero Prefix.
+func (d *DnsRequestType) subnet() netip.Prefix {
+	p, _ := netip.ParsePrefix(strings.TrimSpace(d.clientSubnet))
+	return p
+}
+
+// dialOptions validates the source/interface/family and socket settings and folds them into DialOptions.
+func (d *DnsRequestType) dialOptions(timeout time.Duration) (transport.DialOptions, error) {
+	opts := transport.DialOptions{
+		Timeout:   timeout,
+		Interface: strings.TrimSpace(d.iface),
+		IPVersion: d.ipVersion,
+		Socket:    d.socket,
+	}
+	if err := d.socket.Validate(); err != nil {
+		return opts, err
+	}
+	switch d.ipVersion {
+	case 0, 4, 6:
+	default:
+		return opts, fmt.Errorf("invalid ip version %d, want 4 or 6", d.ipVersion)
+	}
+	if s := strings.TrimSpace(d.sourceIP); s != "" {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid source ip: %w", err)
 		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
+		ip = ip.Unmap()
+		if (d.ipVersion == 4 && !ip.Is4()) || (d.ipVersion == 6 && !ip.Is6()) {
+			return opts, fmt.Errorf("source ip %s does not match ip version %d", ip, d.ipVersion)
 		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
-	case "https3":
-		ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
-		defer cancel()
-		var pd transport.PacketDialer
-		if v, ok := dialer.(transport.PacketDialer); ok {
-			pd = v
+		opts.LocalAddr = ip
+	}
+	return opts, nil
+}
+
+// proxyChainOptions picks the proxies from socks5Proxy, proxy or proxyChain; empty means a direct dial.
+func (d *DnsRequestType) proxyChainOptions() ([]transport.ProxyOptions, error) {
+	socks5 := strings.TrimSpace(d.socks5Proxy)
+	other := strings.TrimSpace(d.proxy)
+	set := 0
+	for _, v := range []bool{socks5 != "", other != "", len(d.proxyChain) > 0} {
+		if v {
+			set++
 		}
-		sd := singdns.NewDialerAdapter(dialer, pd)
-		var ecs netip.Prefix
-		if s := strings.TrimSpace(d.clientSubnet); s != "" {
-			if p, perr := netip.ParsePrefix(s); perr == nil {
-				ecs = p
-			}
+	}
+	if set > 1 {
+		return nil, errors.New("socks5, proxy and proxy_chain are mutually exclusive")
+	}
+	switch {
+	case socks5 != "":
+		proxy, err := transport.ParseSocks5URL(socks5)
+		if err != nil {
+			return nil, err
 		}
-		t, e := singdns.CreateTransport(singdns.TransportOptions{Context: ctx, Dialer: sd, Address: serverAddr, SNI: d.sni, ClientSubnet: ecs})
-		if e != nil {
-			err = e
-			break
+		if d.socks5User != "" || d.socks5Pass != "" {
+			proxy.User, proxy.Pass = d.socks5User, d.socks5Pass
 		}
-		defer t.Close()
-		start := time.Now()
-		resp, err = t.Exchange(ctx, msg)
-		rtt = time.Since(start)
+		proxy.ReuseSessions = d.proxyReuse
+		return []transport.ProxyOptions{proxy}, nil
+	case other != "":
+		return d.parseProxies([]string{other})
 	default:
-		err = errors.New("unsupported net scheme: " + d.net)
+		return d.parseProxies(d.proxyChain)
 	}
+}
 
-	if err != nil {
-		return nil, err
-	}
-	if resp == nil {
-		return nil, errors.New("empty response")
+func (d *DnsRequestType) parseProxies(urls []string) ([]transport.ProxyOptions, error) {
+	var out []transport.ProxyOptions
+	for i, u := range urls {
+		proxy, err := transport.ParseProxyURL(u)
+		if err != nil {
+			return nil, fmt.Errorf("proxy hop %d: %w", i, err)
+		}
+		proxy.TLS.InsecureSkipVerify = d.proxyTLSSkip
+		proxy.ReuseSessions = d.proxyReuse
+		out = append(out, proxy)
 	}
-	result.answer = resp
-	result.rtt = rtt
-	return result, nil
+	return out, nil
 }
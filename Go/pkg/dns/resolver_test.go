@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"os"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+
+	"github.com/miekg/dns"
+)
+
+func TestMain(m *testing.M) {
+	path, err := dnstest.TrustRoot()
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.Remove(path)
+	os.Exit(code)
+}
+
+const testZone = `$TTL 300
+@        SOA    ns1 hostmaster 1 3600 600 86400 60
+@        NS     ns1
+ns1      A      192.0.2.53
+www      A      192.0.2.1
+alias    CNAME  www
+*.wild   A      192.0.2.10
+sub      NS     ns.sub
+ns.sub   A      192.0.2.54
+`
+
+var testSchemes = []string{"udp", "tcp", "tls", "https", "quic", "h3"}
+
+// listenAll starts a zone server with one listener per scheme.
+func listenAll(t *testing.T) (*dnstest.Server, map[string]string) {
+	t.Helper()
+	z, err := dnstest.ParseZoneString(testZone, "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dnstest.NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	urls := make(map[string]string)
+	for _, scheme := range testSchemes {
+		if urls[scheme], err = s.Listen(scheme, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, urls
+}
+
+func testRequest(server, qname, qtype string) *DnsRequestType {
+	return DnsRequestOptions{Server: server, Qname: qname, Qtype: qtype, Qclass: "IN", TimeoutMs: 2000}.request()
+}
+
+func TestRequestAgainstZone(t *testing.T) {
+	_, urls := listenAll(t)
+	tests := []struct {
+		qname   string
+		qtype   string
+		rcode   int
+		answers int
+		ns      int
+	}{
+		{"www.example.test", "A", dns.RcodeSuccess, 1, 0},
+		{"alias.example.test", "A", dns.RcodeSuccess, 2, 0},
+		{"x.wild.example.test", "A", dns.RcodeSuccess, 1, 0},
+		{"www.example.test", "AAAA", dns.RcodeSuccess, 0, 1},
+		{"nope.example.test", "A", dns.RcodeNameError, 0, 1},
+		{"host.sub.example.test", "A", dns.RcodeSuccess, 0, 1},
+		{"www.example.org", "A", dns.RcodeRefused, 0, 0},
+	}
+	for _, scheme := range testSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			for _, tt := range tests {
+				res, err := testRequest(urls[scheme], tt.qname, tt.qtype).Request()
+				if err != nil {
+					t.Fatalf("%s %s: %v", tt.qname, tt.qtype, err)
+				}
+				m := res.answer
+				if m.Rcode != tt.rcode || len(m.Answer) != tt.answers || len(m.Ns) != tt.ns {
+					t.Fatalf("%s %s: rcode %s, %d answers, %d authority; want %s, %d, %d",
+						tt.qname, tt.qtype, dns.RcodeToString[m.Rcode], len(m.Answer), len(m.Ns),
+						dns.RcodeToString[tt.rcode], tt.answers, tt.ns)
+				}
+				if tt.qname == "x.wild.example.test" && m.Answer[0].Header().Name != "x.wild.example.test." {
+					t.Fatalf("wildcard answer owned by %s", m.Answer[0].Header().Name)
+				}
+			}
+		})
+	}
+}
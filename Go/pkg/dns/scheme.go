@@ -14,6 +14,8 @@ func GetNetScheme(url string) string {
 		net = "quic"
 	} else if strings.HasPrefix(url, "https3://") || strings.HasPrefix(url, "http3://") || strings.HasPrefix(url, "h3://") {
 		net = "https3"
+	} else if strings.HasPrefix(url, "mdns://") {
+		net = "mdns"
 	}
 	return net
 }
@@ -30,3 +32,29 @@ func GetNetAddress(url string) string {
 	address = strings.TrimPrefix(address, "h3://")
 	return address
 }
+
+// getTransportAddress builds the scheme-qualified address expected by the sing-dns factory.
+func getTransportAddress(server, net string) string {
+	switch net {
+	case "udp":
+		return "udp://" + GetNetAddress(server)
+	case "tcp":
+		return "tcp://" + GetNetAddress(server)
+	case "tcp-tls", "tls":
+		return "tls://" + GetNetAddress(server)
+	case "https":
+		return server // full DoH URL
+	case "quic":
+		if strings.HasPrefix(server, "quic://") || strings.HasPrefix(server, "doq://") {
+			return server
+		}
+		return "quic://" + GetNetAddress(server)
+	case "https3":
+		if strings.HasPrefix(server, "https3://") || strings.HasPrefix(server, "http3://") || strings.HasPrefix(server, "h3://") || strings.HasPrefix(server, "https://") {
+			return server
+		}
+		return "https3://" + GetNetAddress(server)
+	default:
+		return server
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"time"
 
+	"nettest/pkg/dns/tracing"
 	"nettest/pkg/dns/transport"
 
 	M "github.com/sagernet/sing/common/metadata"
@@ -26,29 +27,71 @@ func (a *dialerAdapter) OurDialer() transport.Dialer             { return a.d }
 func (a *dialerAdapter) OurPacketDialer() transport.PacketDialer { return a.pd }
 
 func (a *dialerAdapter) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "net.dial",
+		tracing.String("network.transport", network), tracing.String("server.address", destination.String()))
 	if network == N.NetworkUDP || network == "udp" || network == "udp4" || network == "udp6" {
 		if a.pd == nil {
-			return nil, transport.ErrSocks5UDPUnsupported
+			return traceDial(ctx, span, network, destination.String(), start, nil, transport.ErrUDPUnsupported)
 		}
 		pc, err := a.pd.DialPacket(ctx, "udp", destination.String())
 		if err != nil {
-			return nil, err
+			return traceDial(ctx, span, network, destination.String(), start, nil, err)
 		}
+		// Packet dialers apply the dial deadline, but sing-dns shares this
+		// socket across exchanges and watches each exchange's ctx itself.
+		_ = pc.SetDeadline(time.Time{})
 		if c, ok := pc.(net.Conn); ok {
-			return c, nil
+			return traceDial(ctx, span, network, destination.String(), start, c, nil)
 		}
-		return &packetConnAsConn{pc: pc, raddr: destination.UDPAddr()}, nil
+		return traceDial(ctx, span, network, destination.String(), start, &packetConnAsConn{pc: pc, raddr: destination.UDPAddr()}, nil)
 	}
-	return a.d.DialContext(ctx, network, destination.String())
+	conn, err := a.d.DialContext(ctx, network, destination.String())
+	return traceDial(ctx, span, network, destination.String(), start, conn, err)
 }
 
 func (a *dialerAdapter) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
 	if a.pd == nil {
-		return nil, transport.ErrSocks5UDPUnsupported
+		return nil, transport.ErrUDPUnsupported
 	}
 	return a.pd.DialPacket(ctx, "udp", destination.String())
 }
 
+// readBoundDialer bounds every read on the stream connections it dials by the
+// dial context's timeout, so an exchange abandoned on timeout does not keep its
+// goroutine and socket alive. The window restarts on each read: sing-dns keeps
+// TLS connections for later exchanges, and a fixed deadline taken from the
+// first query would fail every reuse once it passed. UDP is left alone; the
+// sing-dns UDP transport watches ctx itself and never recovers from a read
+// error on its shared socket.
+type readBoundDialer struct {
+	N.Dialer
+}
+
+func (d readBoundDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || N.NetworkName(network) == N.NetworkUDP {
+		return conn, nil
+	}
+	return &readBoundConn{Conn: conn, window: time.Until(deadline)}, nil
+}
+
+// readBoundConn sets a fresh read deadline before each read. An idle kept
+// connection whose read times out is dropped by its transport and redialed.
+type readBoundConn struct {
+	net.Conn
+	window time.Duration
+}
+
+func (c *readBoundConn) Read(b []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.window))
+	return c.Conn.Read(b)
+}
+
 // packetConnAsConn adapts a PacketConn to a Conn bound to a fixed remote address.
 type packetConnAsConn struct {
 	pc    net.PacketConn
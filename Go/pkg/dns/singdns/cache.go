@@ -0,0 +1,441 @@
+package singdns
+
+import (
+	"container/list"
+	"context"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// CacheOptions tunes a Cache. Zero values pick the defaults noted per field.
+type CacheOptions struct {
+	MaxEntries int `json:"max_entries"` // default 4096; least recently used entries are evicted
+	// MinTTL and MaxTTL clamp positive answers (MaxTTL default 86400 s).
+	MinTTL uint32 `json:"min_ttl"`
+	MaxTTL uint32 `json:"max_ttl"`
+	// MaxNegativeTTL caps NXDOMAIN/NODATA caching, whose TTL comes from the SOA
+	// (RFC 2308 §5); default 3600 s.
+	MaxNegativeTTL uint32 `json:"max_negative_ttl"`
+	// ServeStale answers from expired entries when the upstream fails (RFC 8767),
+	// for up to StaleTTL seconds past expiry (default 86400) with records carrying
+	// StaleAnswerTTL (default 30).
+	ServeStale     bool   `json:"serve_stale"`
+	StaleTTL       uint32 `json:"stale_ttl"`
+	StaleAnswerTTL uint32 `json:"stale_answer_ttl"`
+	// PrefetchPercent refreshes an entry in the background when a hit finds less
+	// than this share of its TTL left; 0 disables prefetching.
+	PrefetchPercent int `json:"prefetch_percent"`
+}
+
+func (o CacheOptions) withDefaults() CacheOptions {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = 4096
+	}
+	if o.MaxTTL == 0 {
+		o.MaxTTL = 86400
+	}
+	if o.MaxNegativeTTL == 0 {
+		o.MaxNegativeTTL = 3600
+	}
+	if o.StaleTTL == 0 {
+		o.StaleTTL = 86400
+	}
+	if o.StaleAnswerTTL == 0 {
+		o.StaleAnswerTTL = 30
+	}
+	return o
+}
+
+// CacheStats are the counters of a Cache since it was created or last reset.
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	StaleHits  uint64 `json:"stale_hits"`
+	Prefetches uint64 `json:"prefetches"`
+	Inserts    uint64 `json:"inserts"`
+	Evictions  uint64 `json:"evictions"` // dropped to stay within MaxEntries
+	Expired    uint64 `json:"expired"`   // dropped after their TTL (and stale window) ran out
+	Uncached   uint64 `json:"uncached"`  // responses not eligible for caching (SERVFAIL, truncated, ...)
+}
+
+// CacheEntry is one cached response as shown by Dump.
+type CacheEntry struct {
+	Scope    string   `json:"scope"` // upstream the answer came from
+	Qname    string   `json:"qname"`
+	Qtype    string   `json:"qtype"`
+	Qclass   string   `json:"qclass"`
+	Rcode    string   `json:"rcode"`
+	Negative bool     `json:"negative"`
+	TTL      uint32   `json:"ttl"`       // TTL the entry was stored with, after clamping
+	Remain   int64    `json:"remaining"` // seconds until expiry; negative once stale
+	Hits     uint64   `json:"hits"`
+	Records  []string `json:"records"`
+}
+
+// CacheStatus says how the cache handled one exchange.
+type CacheStatus string
+
+const (
+	CacheMiss  CacheStatus = "miss"
+	CacheHit   CacheStatus = "hit"
+	CacheStale CacheStatus = "stale"
+)
+
+type cacheKey struct {
+	scope string
+	name  string
+	qtype uint16
+	class uint16
+	do    bool
+	cd    bool
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	msg      *dns.Msg
+	stored   time.Time
+	ttl      uint32
+	negative bool
+	hits     uint64
+	fetching bool
+	elem     *list.Element
+}
+
+func (e *cacheEntry) expires() time.Time { return e.stored.Add(time.Duration(e.ttl) * time.Second) }
+
+// Cache is a TTL-aware response cache shared by any number of transports.
+type Cache struct {
+	mu      sync.Mutex
+	opts    CacheOptions
+	entries map[cacheKey]*cacheEntry
+	lru     *list.List // front = most recently used
+	stats   CacheStats
+	now     func() time.Time
+}
+
+// NewCache creates an empty cache.
+func NewCache(opts CacheOptions) *Cache {
+	return &Cache{
+		opts:    opts.withDefaults(),
+		entries: make(map[cacheKey]*cacheEntry),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// SetOptions changes the tuning, evicting entries if MaxEntries shrank.
+func (c *Cache) SetOptions(opts CacheOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts.withDefaults()
+	c.trim()
+}
+
+// Options returns the effective tuning.
+func (c *Cache) Options() CacheOptions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opts
+}
+
+// Stats returns the counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	return s
+}
+
+// Flush removes entries for name (any type when qtype is 0), or everything
+// when name is empty, and returns how many were removed.
+func (c *Cache) Flush(name string, qtype uint16) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = strings.ToLower(dns.Fqdn(name))
+	n := 0
+	for k, e := range c.entries {
+		if name == "." || (k.name == name && (qtype == 0 || k.qtype == qtype)) {
+			c.remove(e)
+			n++
+		}
+	}
+	return n
+}
+
+// Dump lists the entries, most recently used first.
+func (c *Cache) Dump() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	out := make([]CacheEntry, 0, len(c.entries))
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cacheEntry)
+		ce := CacheEntry{
+			Scope:    e.key.scope,
+			Qname:    e.key.name,
+			Qtype:    dns.TypeToString[e.key.qtype],
+			Qclass:   dns.ClassToString[e.key.class],
+			Rcode:    dns.RcodeToString[e.msg.Rcode],
+			Negative: e.negative,
+			TTL:      e.ttl,
+			Remain:   int64(e.expires().Sub(now) / time.Second),
+			Hits:     e.hits,
+		}
+		for _, rr := range append(append([]dns.RR{}, e.msg.Answer...), e.msg.Ns...) {
+			ce.Records = append(ce.Records, rr.String())
+		}
+		out = append(out, ce)
+	}
+	return out
+}
+
+func keyOf(scope string, m *dns.Msg) (cacheKey, bool) {
+	if len(m.Question) != 1 || m.Opcode != dns.OpcodeQuery {
+		return cacheKey{}, false
+	}
+	q := m.Question[0]
+	switch q.Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeANY:
+		return cacheKey{}, false
+	}
+	k := cacheKey{scope: scope, name: strings.ToLower(q.Name), qtype: q.Qtype, class: q.Qclass, cd: m.CheckingDisabled}
+	if opt := m.IsEdns0(); opt != nil {
+		k.do = opt.Do()
+	}
+	return k, true
+}
+
+// ttlOf works out how long resp may be cached; ok is false when it may not be.
+func (c *Cache) ttlOf(resp *dns.Msg) (ttl uint32, negative, ok bool) {
+	if resp.Truncated {
+		return 0, false, false
+	}
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		ttl = ^uint32(0)
+		for _, rr := range resp.Answer {
+			if t := rr.Header().Ttl; t < ttl {
+				ttl = t
+			}
+		}
+		if ttl < c.opts.MinTTL {
+			ttl = c.opts.MinTTL
+		}
+		if ttl > c.opts.MaxTTL {
+			ttl = c.opts.MaxTTL
+		}
+		return ttl, false, ttl > 0
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		// NODATA or NXDOMAIN: only with an SOA to take the negative TTL from.
+		for _, rr := range resp.Ns {
+			if soa, isSOA := rr.(*dns.SOA); isSOA {
+				ttl = soa.Hdr.Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				if ttl < c.opts.MinTTL {
+					ttl = c.opts.MinTTL
+				}
+				if ttl > c.opts.MaxNegativeTTL {
+					ttl = c.opts.MaxNegativeTTL
+				}
+				return ttl, true, ttl > 0
+			}
+		}
+	}
+	return 0, false, false
+}
+
+// lookup returns the entry for k, dropping it if even its stale window passed.
+func (c *Cache) lookup(k cacheKey, now time.Time) *cacheEntry {
+	e := c.entries[k]
+	if e == nil {
+		return nil
+	}
+	limit := e.expires()
+	if c.opts.ServeStale {
+		limit = limit.Add(time.Duration(c.opts.StaleTTL) * time.Second)
+	}
+	if !now.Before(limit) {
+		c.remove(e)
+		c.stats.Expired++
+		return nil
+	}
+	return e
+}
+
+func (c *Cache) store(k cacheKey, resp *dns.Msg) {
+	ttl, negative, ok := c.ttlOf(resp)
+	if !ok {
+		c.stats.Uncached++
+		return
+	}
+	if old := c.entries[k]; old != nil {
+		c.remove(old)
+	}
+	e := &cacheEntry{key: k, msg: resp.Copy(), stored: c.now(), ttl: ttl, negative: negative}
+	e.elem = c.lru.PushFront(e)
+	c.entries[k] = e
+	c.stats.Inserts++
+	c.trim()
+}
+
+func (c *Cache) trim() {
+	for len(c.entries) > c.opts.MaxEntries {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+}
+
+// reply builds the response to q from e, aging TTLs by the time spent in the
+// cache, or setting them to StaleAnswerTTL for a stale answer.
+func (e *cacheEntry) reply(q *dns.Msg, now time.Time, staleTTL uint32) *dns.Msg {
+	resp := e.msg.Copy()
+	resp.Id = q.Id
+	resp.Question = append([]dns.Question(nil), q.Question...) // keep the client's 0x20 casing
+	age := uint32(now.Sub(e.stored) / time.Second)
+	for _, sec := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range sec {
+			h := rr.Header()
+			switch {
+			case h.Rrtype == dns.TypeOPT:
+			case staleTTL > 0:
+				h.Ttl = staleTTL
+			case h.Ttl > age:
+				h.Ttl -= age
+			default:
+				h.Ttl = 0
+			}
+		}
+	}
+	return resp
+}
+
+type cacheTraceKey struct{}
+
+// CacheTrace receives the status of an exchange made through a cached transport.
+type CacheTrace struct {
+	Status CacheStatus
+}
+
+// WithCacheTrace returns a context that records how the cache handled the exchange.
+func WithCacheTrace(ctx context.Context) (context.Context, *CacheTrace) {
+	t := &CacheTrace{}
+	return context.WithValue(ctx, cacheTraceKey{}, t), t
+}
+
+func traceCache(ctx context.Context, s CacheStatus) {
+	if t, ok := ctx.Value(cacheTraceKey{}).(*CacheTrace); ok {
+		t.Status = s
+	}
+}
+
+// cachedTransport answers from cache when it can and fills it otherwise. Close
+// returns at once; the inner transport is closed once in-flight prefetches end.
+type cachedTransport struct {
+	inner Transport
+	cache *Cache
+	scope string
+	wg    sync.WaitGroup
+}
+
+// NewCachedTransport puts cache in front of inner. scope separates entries of
+// different upstreams (and client subnets) sharing one cache.
+func NewCachedTransport(inner Transport, cache *Cache, scope string) Transport {
+	return &cachedTransport{inner: inner, cache: cache, scope: scope}
+}
+
+func (t *cachedTransport) Name() string { return t.inner.Name() }
+func (t *cachedTransport) Start() error { return t.inner.Start() }
+func (t *cachedTransport) Reset()       { t.inner.Reset() }
+func (t *cachedTransport) Raw() bool    { return t.inner.Raw() }
+func (t *cachedTransport) Lookup(ctx context.Context, d string, s DomainStrategy) ([]netip.Addr, error) {
+	return t.inner.Lookup(ctx, d, s)
+}
+
+func (t *cachedTransport) Close() error {
+	go func() {
+		t.wg.Wait()
+		t.inner.Close()
+	}()
+	return nil
+}
+
+func (t *cachedTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	k, cacheable := keyOf(t.scope, m)
+	if !cacheable {
+		return t.inner.Exchange(ctx, m)
+	}
+	c := t.cache
+	c.mu.Lock()
+	now := c.now()
+	e := c.lookup(k, now)
+	if e != nil && now.Before(e.expires()) {
+		e.hits++
+		c.stats.Hits++
+		c.lru.MoveToFront(e.elem)
+		resp := e.reply(m, now, 0)
+		if p := c.opts.PrefetchPercent; p > 0 && !e.fetching &&
+			e.expires().Sub(now) < time.Duration(e.ttl)*time.Second*time.Duration(p)/100 {
+			e.fetching = true
+			c.stats.Prefetches++
+			t.prefetch(k, m.Copy())
+		}
+		c.mu.Unlock()
+		traceCache(ctx, CacheHit)
+		return resp, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	resp, err := t.inner.Exchange(ctx, m)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && resp != nil && resp.Rcode != dns.RcodeServerFailure {
+		c.store(k, resp)
+		traceCache(ctx, CacheMiss)
+		return resp, nil
+	}
+	if err == nil {
+		c.stats.Uncached++
+	}
+	// RFC 8767: fall back to a stale answer when the upstream fails.
+	if e = c.lookup(k, c.now()); e != nil && c.opts.ServeStale {
+		e.hits++
+		c.stats.StaleHits++
+		traceCache(ctx, CacheStale)
+		return e.reply(m, c.now(), c.opts.StaleAnswerTTL), nil
+	}
+	traceCache(ctx, CacheMiss)
+	return resp, err
+}
+
+// prefetch refreshes k in the background; called with c.mu held.
+func (t *cachedTransport) prefetch(k cacheKey, m *dns.Msg) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := t.inner.Exchange(ctx, m)
+		c := t.cache
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err == nil && resp != nil && resp.Rcode != dns.RcodeServerFailure {
+			c.store(k, resp)
+		} else if e := c.entries[k]; e != nil {
+			e.fetching = false
+		}
+	}()
+}
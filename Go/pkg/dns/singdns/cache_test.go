@@ -0,0 +1,249 @@
+package singdns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeClock is a settable Cache.now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// fakeUpstream answers every query with reply, or fails when reply is nil.
+type fakeUpstream struct {
+	mu      sync.Mutex
+	reply   func(q *dns.Msg) *dns.Msg
+	queries int
+}
+
+func (f *fakeUpstream) Name() string { return "fake" }
+func (f *fakeUpstream) Start() error { return nil }
+func (f *fakeUpstream) Reset()       {}
+func (f *fakeUpstream) Close() error { return nil }
+func (f *fakeUpstream) Raw() bool    { return true }
+func (f *fakeUpstream) Lookup(context.Context, string, DomainStrategy) ([]netip.Addr, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeUpstream) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+func (f *fakeUpstream) set(reply func(q *dns.Msg) *dns.Msg) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reply = reply
+}
+
+func (f *fakeUpstream) Exchange(_ context.Context, q *dns.Msg) (*dns.Msg, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+	if f.reply == nil {
+		return nil, errors.New("upstream down")
+	}
+	return f.reply(q), nil
+}
+
+// answerA replies with one A record carrying ttl.
+func answerA(ttl uint32) func(q *dns.Msg) *dns.Msg {
+	return func(q *dns.Msg) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetReply(q)
+		rr, _ := dns.NewRR(q.Question[0].Name + " A 192.0.2.1")
+		rr.Header().Ttl = ttl
+		r.Answer = append(r.Answer, rr)
+		return r
+	}
+}
+
+// negative replies with rcode and, unless soaTTL is 0, an SOA whose TTL is
+// soaTTL and minimum minTTL.
+func negative(rcode int, soaTTL, minTTL uint32) func(q *dns.Msg) *dns.Msg {
+	return func(q *dns.Msg) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetRcode(q, rcode)
+		if soaTTL > 0 {
+			r.Ns = append(r.Ns, &dns.SOA{
+				Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soaTTL},
+				Ns:  "ns.example.", Mbox: "hostmaster.example.", Minttl: minTTL,
+			})
+		}
+		return r
+	}
+}
+
+func newTestCache(opts CacheOptions, reply func(q *dns.Msg) *dns.Msg) (*Cache, *cachedTransport, *fakeUpstream, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	c := NewCache(opts)
+	c.now = clock.now
+	up := &fakeUpstream{reply: reply}
+	return c, NewCachedTransport(up, c, "test").(*cachedTransport), up, clock
+}
+
+func query(t *testing.T, tr Transport, name string) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	resp, err := tr.Exchange(context.Background(), m)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return resp
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  CacheOptions
+		reply func(q *dns.Msg) *dns.Msg
+		ttl   uint32 // stored TTL; 0 means not cached
+		isNeg bool
+	}{
+		{"answer", CacheOptions{}, answerA(300), 300, false},
+		{"raised to min", CacheOptions{MinTTL: 60}, answerA(5), 60, false},
+		{"capped at max", CacheOptions{MaxTTL: 600}, answerA(86400), 600, false},
+		{"default max", CacheOptions{}, answerA(1 << 20), 86400, false},
+		{"zero ttl", CacheOptions{}, answerA(0), 0, false},
+		{"nxdomain soa minimum", CacheOptions{}, negative(dns.RcodeNameError, 900, 120), 120, true},
+		{"nodata soa ttl", CacheOptions{}, negative(dns.RcodeSuccess, 90, 600), 90, true},
+		{"negative capped", CacheOptions{MaxNegativeTTL: 30}, negative(dns.RcodeNameError, 900, 900), 30, true},
+		{"negative without soa", CacheOptions{}, negative(dns.RcodeNameError, 0, 0), 0, false},
+		{"servfail", CacheOptions{}, negative(dns.RcodeServerFailure, 900, 900), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, tr, _, _ := newTestCache(tt.opts, tt.reply)
+			query(t, tr, "www.example")
+			entries := c.Dump()
+			if tt.ttl == 0 {
+				if len(entries) != 0 {
+					t.Fatalf("cached %+v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].TTL != tt.ttl || entries[0].Negative != tt.isNeg {
+				t.Fatalf("entries %+v, want ttl %d negative %v", entries, tt.ttl, tt.isNeg)
+			}
+		})
+	}
+}
+
+func TestCacheAgingAndExpiry(t *testing.T) {
+	c, tr, up, clock := newTestCache(CacheOptions{}, answerA(60))
+	query(t, tr, "www.example")
+	clock.advance(20 * time.Second)
+	if resp := query(t, tr, "www.example"); resp.Answer[0].Header().Ttl != 40 {
+		t.Fatalf("aged ttl %d, want 40", resp.Answer[0].Header().Ttl)
+	}
+	if up.count() != 1 {
+		t.Fatalf("%d upstream queries, want a cache hit", up.count())
+	}
+	clock.advance(41 * time.Second)
+	query(t, tr, "www.example")
+	if up.count() != 2 {
+		t.Fatal("expired entry answered from cache")
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 2 || s.Expired != 1 || s.Inserts != 2 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestCacheServeStale(t *testing.T) {
+	c, tr, up, clock := newTestCache(CacheOptions{ServeStale: true, StaleTTL: 100, StaleAnswerTTL: 7}, answerA(60))
+	query(t, tr, "www.example")
+	up.set(nil)
+	clock.advance(90 * time.Second)
+	resp := query(t, tr, "www.example")
+	if ttl := resp.Answer[0].Header().Ttl; ttl != 7 {
+		t.Fatalf("stale answer ttl %d, want 7", ttl)
+	}
+	clock.advance(80 * time.Second) // 170 s: past expiry plus the stale window
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.", dns.TypeA)
+	if _, err := tr.Exchange(context.Background(), m); err == nil {
+		t.Fatal("served an answer past the stale window")
+	}
+	if s := c.Stats(); s.StaleHits != 1 || s.Expired != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	// Without ServeStale an expired entry is never used.
+	_, tr, up, clock = newTestCache(CacheOptions{}, answerA(60))
+	query(t, tr, "www.example")
+	up.set(nil)
+	clock.advance(61 * time.Second)
+	if _, err := tr.Exchange(context.Background(), m); err == nil {
+		t.Fatal("served a stale answer with serve_stale off")
+	}
+}
+
+func TestCachePrefetch(t *testing.T) {
+	c, tr, up, clock := newTestCache(CacheOptions{PrefetchPercent: 20}, answerA(100))
+	query(t, tr, "www.example")
+	clock.advance(50 * time.Second)
+	query(t, tr, "www.example") // half the TTL left: no prefetch
+	tr.wg.Wait()
+	if up.count() != 1 {
+		t.Fatal("prefetched with half the TTL left")
+	}
+	clock.advance(35 * time.Second)
+	if resp := query(t, tr, "www.example"); resp.Answer[0].Header().Ttl != 15 {
+		t.Fatalf("hit during prefetch has ttl %d, want 15", resp.Answer[0].Header().Ttl)
+	}
+	tr.wg.Wait()
+	if up.count() != 2 {
+		t.Fatalf("%d upstream queries, want one prefetch", up.count())
+	}
+	if e := c.Dump(); len(e) != 1 || e[0].Remain != 100 {
+		t.Fatalf("entry after prefetch %+v", e)
+	}
+	if s := c.Stats(); s.Prefetches != 1 || s.Hits != 2 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	c, tr, up, _ := newTestCache(CacheOptions{MaxEntries: 2}, answerA(300))
+	query(t, tr, "a.example")
+	query(t, tr, "b.example")
+	query(t, tr, "a.example") // a becomes most recently used
+	query(t, tr, "c.example") // evicts b
+	var names []string
+	for _, e := range c.Dump() {
+		names = append(names, e.Qname)
+	}
+	if len(names) != 2 || names[0] != "c.example." || names[1] != "a.example." {
+		t.Fatalf("entries %v, want c then a", names)
+	}
+	query(t, tr, "b.example")
+	if up.count() != 4 {
+		t.Fatalf("%d upstream queries, want evicted b fetched again", up.count())
+	}
+	c.SetOptions(CacheOptions{MaxEntries: 1})
+	if s := c.Stats(); s.Entries != 1 || s.Evictions != 3 {
+		t.Fatalf("stats %+v", s)
+	}
+}
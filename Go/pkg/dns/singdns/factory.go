@@ -4,13 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/netip"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/miekg/dns"
 	upstreamdns "github.com/sagernet/sing-dns"
 	_ "github.com/sagernet/sing-dns/quic"
+	"github.com/sagernet/sing/common/logger"
 	N "github.com/sagernet/sing/common/network"
 )
 
@@ -97,12 +100,14 @@ func (w *edns0SubnetTransportWrapper) Lookup(ctx context.Context, d string, s Do
 }
 func (w *edns0SubnetTransportWrapper) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
 	if m != nil && w.subnet.IsValid() {
-		ensureECS(m, w.subnet)
+		SetClientSubnet(m, w.subnet)
 	}
 	return w.inner.Exchange(ctx, m)
 }
 
-func ensureECS(m *dns.Msg, p netip.Prefix) {
+// SetClientSubnet adds an EDNS Client Subnet option for p to m (RFC 7871),
+// replacing any it already carries.
+func SetClientSubnet(m *dns.Msg, p netip.Prefix) {
 	opt := m.IsEdns0()
 	if opt == nil {
 		m.SetEdns0(1232, true)
@@ -138,45 +143,38 @@ func ensureECS(m *dns.Msg, p netip.Prefix) {
 	opt.Option = append(opt.Option, ecs)
 }
 
+// Upstream transports get a no-op logger: sing-dns logs unconditionally on some
+// paths, e.g. when retrying a truncated UDP answer over TCP. TCP and TLS (and
+// the TCP retry of UDP) read without watching ctx, so their reads are bounded
+// by readBoundDialer. DoQ reads its stream without a deadline and is only
+// abandoned on ctx; the others follow ctx themselves.
 func init() {
 	RegisterTransport([]string{"udp"}, func(opt TransportOptions) (Transport, error) {
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "udp", Dialer: opt.Dialer, Address: opt.Address})
+		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Logger: logger.NOP(), Name: "udp", Dialer: readBoundDialer{opt.Dialer}, Address: opt.Address})
 		if err != nil {
 			return nil, err
 		}
-		return singUpstreamTransport{name: "udp", upstream: u}, nil
+		return &singUpstreamTransport{name: "udp", upstream: u}, nil
 	})
 	RegisterTransport([]string{"tcp"}, func(opt TransportOptions) (Transport, error) {
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "tcp", Dialer: opt.Dialer, Address: opt.Address})
-		if err != nil {
-			return nil, err
-		}
-		return singUpstreamTransport{name: "tcp", upstream: u}, nil
+		return newBlockingTransport(opt.Context, upstreamdns.TransportOptions{Logger: logger.NOP(), Name: "tcp", Dialer: readBoundDialer{opt.Dialer}, Address: opt.Address})
 	})
 	RegisterTransport([]string{"tls"}, func(opt TransportOptions) (Transport, error) {
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "tls", Dialer: opt.Dialer, Address: opt.Address})
-		if err != nil {
-			return nil, err
-		}
-		return singUpstreamTransport{name: "tls", upstream: u}, nil
+		return newBlockingTransport(opt.Context, upstreamdns.TransportOptions{Logger: logger.NOP(), Name: "tls", Dialer: readBoundDialer{opt.Dialer}, Address: opt.Address})
 	})
 	RegisterTransport([]string{"https"}, func(opt TransportOptions) (Transport, error) {
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "https", Dialer: opt.Dialer, Address: opt.Address})
+		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Logger: logger.NOP(), Name: "https", Dialer: opt.Dialer, Address: opt.Address})
 		if err != nil {
 			return nil, err
 		}
-		return singUpstreamTransport{name: "https", upstream: u}, nil
+		return &singUpstreamTransport{name: "https", upstream: u}, nil
 	})
 	RegisterTransport([]string{"quic", "doq"}, func(opt TransportOptions) (Transport, error) {
 		a := opt.Address
 		if strings.HasPrefix(a, "doq://") {
 			a = "quic://" + a[len("doq://"):]
 		}
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "quic", Dialer: opt.Dialer, Address: a})
-		if err != nil {
-			return nil, err
-		}
-		return singUpstreamTransport{name: "quic", upstream: u}, nil
+		return newBlockingTransport(opt.Context, upstreamdns.TransportOptions{Logger: logger.NOP(), Name: "quic", Dialer: opt.Dialer, Address: a})
 	})
 	RegisterTransport([]string{"https3", "http3", "h3"}, func(opt TransportOptions) (Transport, error) {
 		a := opt.Address
@@ -186,11 +184,11 @@ func init() {
 				a = u.String()
 			}
 		}
-		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Name: "h3", Dialer: opt.Dialer, Address: a})
+		u, err := upstreamdns.CreateTransport(upstreamdns.TransportOptions{Context: opt.Context, Logger: logger.NOP(), Name: "h3", Dialer: opt.Dialer, Address: a})
 		if err != nil {
 			return nil, err
 		}
-		return singUpstreamTransport{name: "https3", upstream: u}, nil
+		return &singUpstreamTransport{name: "https3", upstream: u}, nil
 	})
 }
 
@@ -215,25 +213,160 @@ func (t simpleExchangeTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns
 
 // (no helpers required)
 
+type singUpstream interface {
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+	Close() error
+	Start() error
+	Raw() bool
+	Reset()
+}
+
 type singUpstreamTransport struct {
 	name     string
-	upstream interface {
-		Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
-		Close() error
-		Start() error
-		Raw() bool
-		Reset()
+	upstream singUpstream
+	// create builds a fresh instance of a blocking upstream, one that reads
+	// without watching ctx once the query is written; nil for the others.
+	create func() (*abandonable, error)
+	mu     sync.Mutex
+	live   *abandonable
+	closed bool
+}
+
+// abandonable is one instance of a blocking upstream. Its exchanges may
+// outlive their ctx, so it is closed only after all of them have ended.
+type abandonable struct {
+	singUpstream
+	cancel  context.CancelFunc
+	pending sync.WaitGroup
+}
+
+// retire aborts the instance's dials and closes it once its exchanges end.
+// sing-dns does not guard a connection being opened against concurrent
+// exchanges or Reset, so a retired instance is never used again.
+func (a *abandonable) retire() {
+	a.cancel()
+	go func() {
+		a.pending.Wait()
+		a.singUpstream.Close()
+	}()
+}
+
+func newBlockingTransport(ctx context.Context, opts upstreamdns.TransportOptions) (Transport, error) {
+	t := &singUpstreamTransport{name: opts.Name}
+	t.create = func() (*abandonable, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		o := opts
+		o.Context = ctx
+		u, err := upstreamdns.CreateTransport(o)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &abandonable{singUpstream: u, cancel: cancel}, nil
 	}
+	var err error
+	if t.live, err = t.create(); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
-func (t singUpstreamTransport) Name() string { return t.name }
-func (t singUpstreamTransport) Start() error { return t.upstream.Start() }
-func (t singUpstreamTransport) Reset()       { t.upstream.Reset() }
-func (t singUpstreamTransport) Close() error { return t.upstream.Close() }
-func (t singUpstreamTransport) Raw() bool    { return t.upstream.Raw() }
-func (t singUpstreamTransport) Lookup(ctx context.Context, domain string, strategy DomainStrategy) ([]netip.Addr, error) {
+func (t *singUpstreamTransport) Name() string { return t.name }
+func (t *singUpstreamTransport) Lookup(ctx context.Context, domain string, strategy DomainStrategy) ([]netip.Addr, error) {
 	return nil, errors.New("Lookup not implemented in singUpstreamTransport")
 }
-func (t singUpstreamTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
-	return t.upstream.Exchange(ctx, m)
+
+func (t *singUpstreamTransport) Start() error {
+	if t.create == nil {
+		return t.upstream.Start()
+	}
+	return nil
+}
+
+func (t *singUpstreamTransport) Raw() bool {
+	if t.create == nil {
+		return t.upstream.Raw()
+	}
+	return true
+}
+
+// Reset drops the connections of the upstream; a blocking one is retired and
+// the next exchange starts a new instance.
+func (t *singUpstreamTransport) Reset() {
+	if t.create == nil {
+		t.upstream.Reset()
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.live != nil {
+		t.live.retire()
+		t.live = nil
+	}
+}
+
+// Close returns at once; a blocking upstream is closed once its abandoned
+// exchanges have ended.
+func (t *singUpstreamTransport) Close() error {
+	if t.create == nil {
+		return t.upstream.Close()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.live != nil {
+		t.live.retire()
+		t.live = nil
+	}
+	return nil
+}
+
+// Exchange returns when ctx is done even if a blocking upstream is still
+// reading. The instance running the abandoned exchange is retired: its dial is
+// cancelled and it is closed when the exchange ends, while later exchanges use
+// a new instance. Upstreams that watch ctx are called directly, so they clean
+// up before returning.
+func (t *singUpstreamTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if t.create == nil {
+		return t.upstream.Exchange(ctx, m)
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	if t.live == nil {
+		a, err := t.create()
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		t.live = a
+	}
+	a := t.live
+	a.pending.Add(1)
+	t.mu.Unlock()
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer a.pending.Done()
+		resp, err := a.Exchange(ctx, m)
+		done <- result{resp, err}
+	}()
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		t.mu.Lock()
+		if t.live == a {
+			a.retire()
+			t.live = nil
+		}
+		t.mu.Unlock()
+		return nil, ctx.Err()
+	}
 }
@@ -0,0 +1,135 @@
+package singdns_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"nettest/pkg/dns/dnstest"
+	"nettest/pkg/dns/singdns"
+	"nettest/pkg/dns/transport"
+
+	"github.com/miekg/dns"
+)
+
+func TestMain(m *testing.M) {
+	path, err := dnstest.TrustRoot()
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.Remove(path)
+	os.Exit(code)
+}
+
+func newServer(t *testing.T) *dnstest.Server {
+	t.Helper()
+	z, err := dnstest.ParseZoneString("$TTL 60\n@ SOA ns admin 1 3600 600 86400 30\nwww A 192.0.2.1\n", "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dnstest.NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newTransport(t *testing.T, address string) singdns.Transport {
+	t.Helper()
+	d := transport.NewDirectDialer(transport.DialOptions{})
+	tr, err := singdns.CreateTransport(singdns.TransportOptions{
+		Context: context.Background(),
+		Dialer:  singdns.NewDialerAdapter(d, d),
+		Address: address,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func exchange(tr singdns.Transport, timeout time.Duration) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.test.", dns.TypeA)
+	return tr.Exchange(ctx, m)
+}
+
+// A timed-out exchange must not break the transport for later ones.
+func TestExchangeTimeoutKeepsTransport(t *testing.T) {
+	for _, scheme := range []string{"udp", "tcp", "tls", "quic"} {
+		t.Run(scheme, func(t *testing.T) {
+			s := newServer(t)
+			u, err := s.Listen(scheme, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := newTransport(t, u)
+			// A timeout may hit during the handshake, before the query
+			// reaches the server, so drop everything rather than a count.
+			s.SetScript(dnstest.Always(dnstest.Fault{Drop: true}))
+			for i := 0; i < 5; i++ {
+				if _, err := exchange(tr, 100*time.Millisecond); err == nil {
+					t.Fatal("dropped query succeeded")
+				}
+			}
+			s.SetScript(nil)
+			resp, err := exchange(tr, 2*time.Second)
+			if err != nil {
+				t.Fatalf("query after a timeout: %v", err)
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("got %d answers, want 1", len(resp.Answer))
+			}
+		})
+	}
+}
+
+// Kept connections must outlive the deadline of the query that dialed them.
+func TestReuseAfterFirstDeadline(t *testing.T) {
+	for _, scheme := range []string{"udp", "tls", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			s := newServer(t)
+			u, err := s.Listen(scheme, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := newTransport(t, u)
+			if _, err := exchange(tr, 300*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(400 * time.Millisecond)
+			if _, err := exchange(tr, 2*time.Second); err != nil {
+				t.Fatalf("query after the first deadline: %v", err)
+			}
+		})
+	}
+}
+
+// Closing right after a timed-out exchange must neither block nor race the
+// exchange that is still dialing or reading (run with -race).
+func TestCloseAfterAbandonedExchange(t *testing.T) {
+	for _, scheme := range []string{"tcp", "tls", "quic"} {
+		t.Run(scheme, func(t *testing.T) {
+			s := newServer(t)
+			u, err := s.Listen(scheme, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := newTransport(t, u)
+			s.SetScript(dnstest.Always(dnstest.Fault{Drop: true}))
+			if _, err := exchange(tr, 100*time.Millisecond); err == nil {
+				t.Fatal("dropped query succeeded")
+			}
+			start := time.Now()
+			if err := tr.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Fatalf("Close blocked for %v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package singdns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/tracing"
+)
+
+type dialTraceKey struct{}
+
+// DialTiming is one connection opened through a DialerAdapter.
+type DialTiming struct {
+	Network   string
+	Address   string
+	Start     time.Time
+	Connected time.Time // dial returned: TCP or UDP connect, including any proxy hops
+	Error     string    // the dial failed at Connected
+	// FirstByte is when the server's first bytes arrived, zero if none did. For
+	// TLS and QUIC upstreams that first read is the server's handshake flight.
+	FirstByte time.Time
+}
+
+// DialTrace collects the connections opened by exchanges made with its context.
+type DialTrace struct {
+	mu    sync.Mutex
+	dials []*DialTiming
+}
+
+// WithDialTrace returns a context that records dials made through a DialerAdapter.
+func WithDialTrace(ctx context.Context) (context.Context, *DialTrace) {
+	t := &DialTrace{}
+	return context.WithValue(ctx, dialTraceKey{}, t), t
+}
+
+// Snapshot returns copies of the recorded timings.
+func (t *DialTrace) Snapshot() []DialTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]DialTiming, len(t.dials))
+	for i, d := range t.dials {
+		out[i] = *d
+	}
+	return out
+}
+
+// traceDial ends the dial span and, when ctx carries a DialTrace, records the
+// dial, failed or not, and wraps conn to catch its first read.
+func traceDial(ctx context.Context, span *tracing.Span, network, address string, start time.Time, conn net.Conn, err error) (net.Conn, error) {
+	span.SetError(err)
+	span.End()
+	t, ok := ctx.Value(dialTraceKey{}).(*DialTrace)
+	if !ok {
+		return conn, err
+	}
+	d := &DialTiming{Network: network, Address: address, Start: start, Connected: time.Now()}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	t.mu.Lock()
+	t.dials = append(t.dials, d)
+	t.mu.Unlock()
+	if err != nil {
+		return conn, err
+	}
+	return &tracedConn{Conn: conn, trace: t, dial: d}, nil
+}
+
+// tracedConn records when its first read returns data.
+type tracedConn struct {
+	net.Conn
+	trace *DialTrace
+	dial  *DialTiming
+	once  sync.Once
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.once.Do(func() {
+			c.trace.mu.Lock()
+			c.dial.FirstByte = time.Now()
+			c.trace.mu.Unlock()
+		})
+	}
+	return n, err
+}
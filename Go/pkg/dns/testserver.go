@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/dnstest"
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// TestZoneOptions is one zone for the authoritative test server: inline text or a file.
+type TestZoneOptions struct {
+	Origin string `json:"origin"`
+	Text   string `json:"text"`
+	File   string `json:"file"`
+}
+
+// TestFaultOptions is the JSON form of dnstest.Fault.
+type TestFaultOptions struct {
+	DelayMs       int    `json:"delay_ms"`
+	Drop          bool   `json:"drop"`
+	Reset         bool   `json:"reset"`
+	Malformed     bool   `json:"malformed"`
+	Rcode         string `json:"rcode"` // e.g. "SERVFAIL", "REFUSED"
+	Truncate      bool   `json:"truncate"`
+	WrongID       bool   `json:"wrong_id"`
+	HandshakeFail bool   `json:"handshake_fail"`
+}
+
+func (o TestFaultOptions) fault() (dnstest.Fault, error) {
+	f := dnstest.Fault{
+		Delay:         time.Duration(o.DelayMs) * time.Millisecond,
+		Drop:          o.Drop,
+		Reset:         o.Reset,
+		Malformed:     o.Malformed,
+		Truncate:      o.Truncate,
+		WrongID:       o.WrongID,
+		HandshakeFail: o.HandshakeFail,
+	}
+	if o.Rcode != "" {
+		rc, ok := dns.StringToRcode[strings.ToUpper(o.Rcode)]
+		if !ok {
+			return f, fmt.Errorf("invalid rcode %q", o.Rcode)
+		}
+		f.Rcode = rc
+	}
+	return f, nil
+}
+
+var testServers = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*dnstest.Server
+}{byID: make(map[string]*dnstest.Server)}
+
+// TestServerStartJson starts an in-process authoritative server on loopback.
+// Fields: listen (default "127.0.0.1:0"), zones [{origin, text | file}],
+// schemes (default ["udp","tcp"]; also tls, https, quic, h3), and faults: a
+// list applied to the first queries in order, or fault applied to every query
+// and handshake. The result carries one server URL per scheme and the PEM of
+// the self-signed certificate used by the TLS-based schemes. A fixed listen
+// port takes at most one of udp, quic, h3 and one of tcp, tls, https.
+// Example: {"zones":[{"origin":"example.test","text":"@ 60 IN SOA ns1 host 1 3600 600 86400 60\n* 60 IN A 192.0.2.1"}]}
+// Example: {"schemes":["udp","tls"],"faults":[{"rcode":"SERVFAIL"},{"delay_ms":8000},{"wrong_id":true}]}
+// Example: {"schemes":["https"],"fault":{"handshake_fail":true}}
+func TestServerStartJson(jsonStr string) string {
+	var in struct {
+		Listen  string             `json:"listen"`
+		Zones   []TestZoneOptions  `json:"zones"`
+		Schemes []string           `json:"schemes"`
+		Faults  []TestFaultOptions `json:"faults"`
+		Fault   *TestFaultOptions  `json:"fault"`
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	var zones []*dnstest.Zone
+	origins := make([]string, 0, len(in.Zones))
+	for i, zo := range in.Zones {
+		var (
+			z   *dnstest.Zone
+			err error
+		)
+		switch {
+		case zo.File != "":
+			z, err = dnstest.LoadZoneFile(zo.File, zo.Origin)
+		case zo.Text != "":
+			z, err = dnstest.ParseZoneString(zo.Text, zo.Origin)
+		default:
+			err = errors.New("empty zone, want text or file")
+		}
+		if err != nil {
+			return utils.BuildErrJSON(fmt.Errorf("zone %d: %w", i, err))
+		}
+		zones = append(zones, z)
+		origins = append(origins, z.Origin)
+	}
+	srv := dnstest.NewServer(zones...)
+	switch {
+	case in.Fault != nil:
+		f, err := in.Fault.fault()
+		if err != nil {
+			return utils.BuildErrJSON(err)
+		}
+		srv.SetScript(dnstest.Always(f))
+	case len(in.Faults) > 0:
+		faults := make([]dnstest.Fault, len(in.Faults))
+		for i, o := range in.Faults {
+			f, err := o.fault()
+			if err != nil {
+				return utils.BuildErrJSON(fmt.Errorf("fault %d: %w", i, err))
+			}
+			faults[i] = f
+		}
+		srv.SetScript(dnstest.Sequence(faults...))
+	}
+	if err := checkListenPort(in.Listen, in.Schemes); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	urls := make(map[string]string)
+	if len(in.Schemes) == 0 {
+		if err := srv.Start(in.Listen); err != nil {
+			_ = srv.Close()
+			return utils.BuildErrJSON(err)
+		}
+		urls["udp"], urls["tcp"] = srv.URL("udp"), srv.URL("tcp")
+	}
+	for _, scheme := range in.Schemes {
+		u, err := srv.Listen(scheme, in.Listen)
+		if err != nil {
+			_ = srv.Close()
+			return utils.BuildErrJSON(err)
+		}
+		urls[scheme] = u
+	}
+	certPEM, err := dnstest.RootPEM()
+	if err != nil {
+		_ = srv.Close()
+		return utils.BuildErrJSON(err)
+	}
+
+	testServers.mu.Lock()
+	testServers.seq++
+	id := fmt.Sprintf("auth-%d", testServers.seq)
+	testServers.byID[id] = srv
+	testServers.mu.Unlock()
+
+	out := map[string]interface{}{
+		"id":       id,
+		"urls":     urls,
+		"zones":    origins,
+		"cert_pem": string(certPEM),
+	}
+	if addr := srv.Addr(); addr != "" {
+		out["addr"] = addr
+	}
+	jsonData, err := json.Marshal(out)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
+
+// checkListenPort rejects a fixed port shared by schemes that need the same
+// socket type: udp, quic and h3 each bind UDP; tcp, tls and https bind TCP.
+func checkListenPort(listen string, schemes []string) error {
+	if listen == "" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+	if port == "0" || port == "" {
+		return nil
+	}
+	seen := make(map[string]string)
+	for _, scheme := range schemes {
+		network := "tcp"
+		switch scheme {
+		case "udp", "quic", "h3":
+			network = "udp"
+		}
+		if prev, ok := seen[network]; ok {
+			return fmt.Errorf("%s and %s cannot share %s port %s; use port 0", prev, scheme, network, port)
+		}
+		seen[network] = scheme
+	}
+	return nil
+}
+
+// TestServerStop stops a server started by TestServerStartJson.
+func TestServerStop(id string) string {
+	testServers.mu.Lock()
+	srv := testServers.byID[id]
+	delete(testServers.byID, id)
+	testServers.mu.Unlock()
+	if srv == nil {
+		return utils.BuildErrJSON(fmt.Errorf("no test server with id %q", id))
+	}
+	if err := srv.Close(); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q,"stopped":true}`, id)
+}
@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServerStartStop(t *testing.T) {
+	var started struct {
+		ID    string   `json:"id"`
+		Addr  string   `json:"addr"`
+		Zones []string `json:"zones"`
+	}
+	out := TestServerStartJson(`{"zones":[{"origin":"example.test","text":"@ 60 IN SOA ns1 host 1 3600 600 86400 60\nwww 60 IN A 192.0.2.1"}]}`)
+	if err := json.Unmarshal([]byte(out), &started); err != nil || started.ID == "" {
+		t.Fatalf("start: %s", out)
+	}
+	if strings.Join(started.Zones, ",") != "example.test." {
+		t.Fatalf("zones %q", started.Zones)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.test.", dns.TypeA)
+	m, err := dns.Exchange(q, started.Addr)
+	if err != nil || !m.Authoritative || len(m.Answer) != 1 {
+		t.Fatalf("query: %v, %v", m, err)
+	}
+	if out := TestServerStop(started.ID); !strings.Contains(out, `"stopped":true`) {
+		t.Fatalf("stop: %s", out)
+	}
+	if out := TestServerStop(started.ID); !strings.Contains(out, `"code"`) {
+		t.Fatalf("second stop: %s", out)
+	}
+	if out := TestServerStartJson(`{"zones":[{"origin":"example.test"}]}`); !strings.Contains(out, "empty zone") {
+		t.Fatalf("empty zone: %s", out)
+	}
+}
+
+func TestServerStartListenPort(t *testing.T) {
+	// Hold the TCP side of a port so Start binds UDP and then fails.
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	listen := tcp.Addr().String()
+
+	if out := TestServerStartJson(`{"listen":"` + listen + `","schemes":["udp","quic"]}`); !strings.Contains(out, "cannot share") {
+		t.Fatalf("udp and quic on one port: %s", out)
+	}
+	if out := TestServerStartJson(`{"listen":"` + listen + `"}`); !strings.Contains(out, `"code"`) {
+		t.Fatalf("start with the TCP port taken: %s", out)
+	}
+	// The failed start must not keep the UDP listener.
+	udp, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		t.Fatalf("UDP port still bound after a failed start: %v", err)
+	}
+	udp.Close()
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/tracing"
+	utils "nettest/pkg/utils"
+)
+
+// TracingConfigureJson points span export at an OTLP/HTTP collector, or turns it
+// off when endpoint is empty, and returns the exporter's state.
+// Fields: endpoint ("default" = http://127.0.0.1:4318/v1/traces), headers,
+// service_name, batch_size, flush_ms, queue_size, timeout_ms.
+// Example: {"endpoint":"http://otel-collector:4318/v1/traces","headers":{"authorization":"Bearer abc"}}
+func TracingConfigureJson(jsonStr string) string {
+	var opts tracing.Options
+	if strings.TrimSpace(jsonStr) != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &opts); err != nil {
+			return utils.BuildErrJSON(err)
+		}
+	}
+	tracing.Configure(opts)
+	return marshalResult(tracing.CurrentStats())
+}
+
+// TracingFlush sends queued spans now and returns the exporter's state.
+func TracingFlush() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracing.Flush(ctx); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return marshalResult(tracing.CurrentStats())
+}
+
+var collectors = struct {
+	mu   sync.Mutex
+	seq  int
+	byID map[string]*tracing.Collector
+}{byID: make(map[string]*tracing.Collector)}
+
+// TracingCollectorStart starts an in-process stand-in collector on listen
+// (default "127.0.0.1:0") and returns its id and the endpoint to configure.
+func TracingCollectorStart(listen string) string {
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	c, err := tracing.NewCollector(listen)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	collectors.mu.Lock()
+	collectors.seq++
+	id := fmt.Sprintf("otlp-%d", collectors.seq)
+	collectors.byID[id] = c
+	collectors.mu.Unlock()
+	return fmt.Sprintf(`{"id":%q,"endpoint":%q}`, id, c.URL())
+}
+
+// TracingCollectorSpans returns the spans a stand-in collector received.
+func TracingCollectorSpans(id string) string {
+	collectors.mu.Lock()
+	c := collectors.byID[id]
+	collectors.mu.Unlock()
+	if c == nil {
+		return utils.BuildErrJSON(fmt.Errorf("no collector with id %q", id))
+	}
+	return marshalResult(map[string]interface{}{"spans": c.Spans()})
+}
+
+// TracingCollectorStop stops a stand-in collector.
+func TracingCollectorStop(id string) string {
+	collectors.mu.Lock()
+	c := collectors.byID[id]
+	delete(collectors.byID, id)
+	collectors.mu.Unlock()
+	if c == nil {
+		return utils.BuildErrJSON(fmt.Errorf("no collector with id %q", id))
+	}
+	if err := c.Close(); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return fmt.Sprintf(`{"id":%q,"stopped":true}`, id)
+}
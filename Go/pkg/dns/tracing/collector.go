@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanData is a span as received by a Collector.
+type SpanData struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Service    string                 `json:"service"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   time.Duration          `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Collector is a stand-in OTLP/HTTP JSON receiver that keeps what it is sent,
+// for tests and for looking at traces without running a real collector.
+type Collector struct {
+	srv *http.Server
+	url string
+
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewCollector listens on addr (e.g. "127.0.0.1:0") and serves /v1/traces.
+func NewCollector(addr string) (*Collector, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Collector{url: "http://" + ln.Addr().String() + "/v1/traces"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", c.receive)
+	c.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second, ErrorLog: log.New(io.Discard, "", 0)}
+	go c.srv.Serve(ln)
+	return c, nil
+}
+
+// URL is the endpoint to configure the exporter with.
+func (c *Collector) URL() string { return c.url }
+
+// Spans returns what was received so far, in arrival order.
+func (c *Collector) Spans() []SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SpanData(nil), c.spans...)
+}
+
+// Close stops the receiver.
+func (c *Collector) Close() error { return c.srv.Close() }
+
+func (c *Collector) receive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var got []SpanData
+	for _, rs := range req.ResourceSpans {
+		var service string
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" && kv.Value.StringValue != nil {
+				service = *kv.Value.StringValue
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				d := SpanData{
+					TraceID:  s.TraceID,
+					SpanID:   s.SpanID,
+					ParentID: s.ParentSpanID,
+					Name:     s.Name,
+					Service:  service,
+					Start:    unixNano(s.StartTimeUnixNano),
+					End:      unixNano(s.EndTimeUnixNano),
+				}
+				d.Duration = d.End.Sub(d.Start)
+				if s.Status.Code == 2 {
+					d.Error = s.Status.Message
+				}
+				if len(s.Attributes) > 0 {
+					d.Attributes = make(map[string]interface{}, len(s.Attributes))
+					for _, kv := range s.Attributes {
+						d.Attributes[kv.Key] = kv.Value.value()
+					}
+				}
+				got = append(got, d)
+			}
+		}
+	}
+	c.mu.Lock()
+	c.spans = append(c.spans, got...)
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+func unixNano(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, n)
+}
@@ -0,0 +1,231 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEndpoint is the OTLP/HTTP traces path of a collector on this machine.
+const DefaultEndpoint = "http://127.0.0.1:4318/v1/traces"
+
+// Options configures the exporter.
+type Options struct {
+	// Endpoint is the full OTLP/HTTP traces URL; "default" means DefaultEndpoint
+	// and empty turns tracing off.
+	Endpoint    string            `json:"endpoint"`
+	Headers     map[string]string `json:"headers"`      // e.g. an authorization header for the collector
+	ServiceName string            `json:"service_name"` // default "nettest"
+	// Spans are sent in batches of up to BatchSize (default 256) at least every
+	// FlushMs milliseconds (default 2000); at most QueueSize (default 4096) wait,
+	// further spans are dropped.
+	BatchSize int `json:"batch_size"`
+	FlushMs   int `json:"flush_ms"`
+	QueueSize int `json:"queue_size"`
+	TimeoutMs int `json:"timeout_ms"` // per export request, default 5000
+}
+
+// Stats are the exporter's counters since it was configured.
+type Stats struct {
+	Enabled   bool   `json:"enabled"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Queued    int    `json:"queued"`
+	Exported  uint64 `json:"exported"`
+	Dropped   uint64 `json:"dropped"`
+	Failed    uint64 `json:"failed"` // spans in batches the collector did not accept
+	LastError string `json:"last_error,omitempty"`
+}
+
+type tracer struct {
+	opts   Options
+	client *http.Client
+	kick   chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+
+	mu      sync.Mutex
+	queue   []*Span
+	lastErr string
+
+	exported, dropped, failed atomic.Uint64
+}
+
+var active atomic.Pointer[tracer]
+
+func current() *tracer { return active.Load() }
+
+// Configure replaces the exporter; an empty Endpoint only stops it. Spans
+// queued for the previous one are still sent, but in the background, so a slow
+// or unreachable collector does not hold up the caller.
+func Configure(opts Options) {
+	if opts.Endpoint == "default" {
+		opts.Endpoint = DefaultEndpoint
+	}
+	var t *tracer
+	if opts.Endpoint != "" {
+		if opts.ServiceName == "" {
+			opts.ServiceName = "nettest"
+		}
+		if opts.BatchSize <= 0 {
+			opts.BatchSize = 256
+		}
+		if opts.FlushMs <= 0 {
+			opts.FlushMs = 2000
+		}
+		if opts.QueueSize <= 0 {
+			opts.QueueSize = 4096
+		}
+		if opts.TimeoutMs <= 0 {
+			opts.TimeoutMs = 5000
+		}
+		t = &tracer{
+			opts:   opts,
+			client: &http.Client{Timeout: time.Duration(opts.TimeoutMs) * time.Millisecond},
+			kick:   make(chan struct{}, 1),
+			done:   make(chan struct{}),
+			exited: make(chan struct{}),
+		}
+		go t.run()
+	}
+	if old := active.Swap(t); old != nil {
+		close(old.done)
+	}
+}
+
+// Flush sends every queued span now.
+func Flush(ctx context.Context) error {
+	t := current()
+	if t == nil {
+		return nil
+	}
+	for {
+		batch := t.take()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.export(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// CurrentStats returns the exporter's counters.
+func CurrentStats() Stats {
+	t := current()
+	if t == nil {
+		return Stats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Stats{
+		Enabled:   true,
+		Endpoint:  t.opts.Endpoint,
+		Queued:    len(t.queue),
+		Exported:  t.exported.Load(),
+		Dropped:   t.dropped.Load(),
+		Failed:    t.failed.Load(),
+		LastError: t.lastErr,
+	}
+}
+
+func (t *tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	if len(t.queue) >= t.opts.QueueSize {
+		t.mu.Unlock()
+		t.dropped.Add(1)
+		return
+	}
+	t.queue = append(t.queue, s)
+	full := len(t.queue) >= t.opts.BatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes up to one batch from the queue.
+func (t *tracer) take() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.queue)
+	if n > t.opts.BatchSize {
+		n = t.opts.BatchSize
+	}
+	batch := append([]*Span(nil), t.queue[:n]...)
+	t.queue = append(t.queue[:0], t.queue[n:]...)
+	return batch
+}
+
+func (t *tracer) run() {
+	defer close(t.exited)
+	ticker := time.NewTicker(time.Duration(t.opts.FlushMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			for batch := t.take(); len(batch) > 0; batch = t.take() {
+				t.export(context.Background(), batch)
+			}
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		for batch := t.take(); len(batch) > 0; batch = t.take() {
+			t.export(context.Background(), batch)
+		}
+	}
+}
+
+func (t *tracer) export(ctx context.Context, batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+	service := t.opts.ServiceName
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: &service}}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "nettest/dns"}, Spans: spans}},
+	}}})
+	if err == nil {
+		err = t.post(ctx, body)
+	}
+	t.mu.Lock()
+	if err != nil {
+		t.failed.Add(uint64(len(batch)))
+		t.lastErr = err.Error()
+	} else {
+		t.exported.Add(uint64(len(batch)))
+	}
+	t.mu.Unlock()
+	return err
+}
+
+func (t *tracer) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package tracing records spans of DNS queries and exports them to an
+// OpenTelemetry collector over OTLP/HTTP with the JSON encoding. Until
+// Configure is given an endpoint, Start returns a nil span and costs nothing.
+//
+//	tracing.Configure(tracing.Options{Endpoint: "http://127.0.0.1:4318/v1/traces"})
+//	ctx, span := tracing.Start(ctx, "dns.query", tracing.String("dns.server", server))
+//	defer span.End()
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Attr is a span attribute.
+type Attr struct {
+	Key   string
+	Value interface{} // string, int64, float64 or bool
+}
+
+// String returns a string attribute.
+func String(k, v string) Attr { return Attr{k, v} }
+
+// Int returns an integer attribute.
+func Int(k string, v int) Attr { return Attr{k, int64(v)} }
+
+// Bool returns a boolean attribute.
+func Bool(k string, v bool) Attr { return Attr{k, v} }
+
+// Float returns a floating point attribute.
+func Float(k string, v float64) Attr { return Attr{k, v} }
+
+// Span is one timed operation. A nil *Span is valid and records nothing.
+type Span struct {
+	tracer  *tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+type spanKey struct{}
+
+// FromContext returns the span carried by ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a span as a child of the one in ctx, or a new trace's root.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	t := current()
+	if t == nil {
+		return ctx, nil
+	}
+	s := newSpan(t, FromContext(ctx), name, time.Now(), attrs)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Record adds an already finished span under the one in ctx, for phases whose
+// timing is only known afterwards; err may be nil.
+func Record(ctx context.Context, name string, start, end time.Time, err error, attrs ...Attr) {
+	t := current()
+	if t == nil {
+		return
+	}
+	s := newSpan(t, FromContext(ctx), name, start, attrs)
+	s.kind = KindInternal
+	s.SetError(err)
+	s.finish(end)
+}
+
+func newSpan(t *tracer, parent *Span, name string, start time.Time, attrs []Attr) *Span {
+	s := &Span{tracer: t, name: name, kind: KindClient, start: start, attrs: attrs}
+	if parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+		s.kind = KindInternal
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// TraceID returns the span's trace id in hex, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetAttributes adds or overrides attributes.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed with err; a nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.finish(time.Now())
+}
+
+func (s *Span) finish(end time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, end
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpansReachCollector(t *testing.T) {
+	c, err := NewCollector("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, s := Start(context.Background(), "off"); s != nil {
+		t.Fatal("span started with tracing off")
+	}
+	Configure(Options{Endpoint: c.URL(), ServiceName: "test", FlushMs: 60000})
+	defer Configure(Options{})
+
+	ctx, root := Start(context.Background(), "root", String("dns.server", "udp://192.0.2.1:53"))
+	cctx, child := Start(ctx, "child", Int("n", 2), Bool("ok", true))
+	start := time.Now().Add(-time.Second)
+	Record(cctx, "phase", start, start.Add(250*time.Millisecond), errors.New("refused"))
+	child.End()
+	root.SetAttributes(Float("ratio", 0.5))
+	root.End()
+	root.End() // only the first End counts
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := c.Spans()
+	byName := make(map[string]SpanData)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	if len(spans) != 3 || len(byName) != 3 {
+		t.Fatalf("got %d spans: %+v", len(spans), spans)
+	}
+	r, ch, ph := byName["root"], byName["child"], byName["phase"]
+	if r.ParentID != "" || ch.ParentID != r.SpanID || ph.ParentID != ch.SpanID {
+		t.Fatalf("parents: root %q, child %q (root %s), phase %q (child %s)", r.ParentID, ch.ParentID, r.SpanID, ph.ParentID, ch.SpanID)
+	}
+	if r.TraceID != root.TraceID() || ch.TraceID != r.TraceID || ph.TraceID != r.TraceID {
+		t.Fatal("spans are not in one trace")
+	}
+	if r.Service != "test" || r.Attributes["dns.server"] != "udp://192.0.2.1:53" || r.Attributes["ratio"] != 0.5 {
+		t.Fatalf("root %+v", r)
+	}
+	if ch.Attributes["n"] != int64(2) || ch.Attributes["ok"] != true {
+		t.Fatalf("child attributes %v", ch.Attributes)
+	}
+	if ph.Error != "refused" || ph.Duration != 250*time.Millisecond || !ph.Start.Equal(start) {
+		t.Fatalf("phase %+v", ph)
+	}
+	if st := CurrentStats(); !st.Enabled || st.Exported != 3 || st.Queued != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+// Reconfiguring returns at once even when the old collector hangs; the old
+// exporter still delivers what it had queued.
+func TestConfigureDoesNotWaitForDrain(t *testing.T) {
+	release := make(chan struct{})
+	got := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		got <- struct{}{}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	Configure(Options{Endpoint: srv.URL, FlushMs: 60000})
+	old := current()
+	_, s := Start(context.Background(), "queued")
+	s.End()
+
+	done := make(chan struct{})
+	go func() {
+		Configure(Options{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Configure waited for the old exporter")
+	}
+	if CurrentStats().Enabled {
+		t.Fatal("exporter still enabled")
+	}
+	release <- struct{}{}
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued span never sent")
+	}
+	<-old.exited
+	if n := old.exported.Load(); n != 1 {
+		t.Fatalf("old exporter sent %d spans", n)
+	}
+}
@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+	"nettest/pkg/dns/tracing"
+)
+
+// Each query is one trace rooted at dns.query; the edges are parent>child names.
+func TestQuerySpans(t *testing.T) {
+	s, urls := listenAll(t)
+	s.SetSigner(dnstest.TSIG{Name: "key.example.", Secret: testTsigSecret})
+	c, err := tracing.NewCollector("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tracing.Configure(tracing.Options{Endpoint: c.URL(), FlushMs: 60000})
+	defer tracing.Configure(tracing.Options{})
+
+	tests := []struct {
+		name  string
+		req   *DnsRequestType
+		edges string
+	}{
+		{"udp", testRequest(urls["udp"], "www.example.test", "A"),
+			"dns.exchange>net.dial dns.query>dns.exchange dns.query>dns.transport.create"},
+		{"tls", testRequest(urls["tls"], "www.example.test", "A"),
+			"dns.exchange>net.dial dns.exchange>tls.first_flight dns.query>dns.exchange dns.query>dns.transport.create"},
+		{"quic", testRequest(urls["quic"], "www.example.test", "A"),
+			"dns.exchange>quic.first_flight dns.query>dns.exchange dns.query>dns.transport.create dns.query>net.dial"},
+		{"signed", signedRequest(urls["tcp"], &TsigOptions{Name: "key.example", Secret: testTsigSecret}, nil),
+			"dns.query>dns.exchange"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(c.Spans())
+			if _, err := tt.req.Request(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tracing.Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			spans := c.Spans()[before:]
+			names := make(map[string]string)
+			for _, sp := range spans {
+				names[sp.SpanID] = sp.Name
+			}
+			var edges []string
+			var root tracing.SpanData
+			for _, sp := range spans {
+				if sp.TraceID != spans[0].TraceID {
+					t.Fatalf("%s is in another trace", sp.Name)
+				}
+				if sp.ParentID == "" {
+					root = sp
+					continue
+				}
+				edges = append(edges, names[sp.ParentID]+">"+sp.Name)
+			}
+			sort.Strings(edges)
+			if got := strings.Join(edges, " "); got != tt.edges {
+				t.Fatalf("edges %s\nwant  %s", got, tt.edges)
+			}
+			if root.Name != "dns.query" || root.Attributes["dns.rcode"] != "NOERROR" || root.Attributes["dns.answer.count"] != int64(1) {
+				t.Fatalf("root %+v", root)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// TransferOptions describes a zone transfer. The embedded request options give
+// the server ("tcp://host[:53]", "tls://host[:853]" for XoT, or bare "host:port"
+// for TCP), the zone as qname, AXFR (default) or IXFR as qtype, and the usual
+// proxy, bootstrap and source address settings.
+type TransferOptions struct {
+	DnsRequestOptions
+	Serial   uint32 `json:"serial"`   // IXFR: the serial the client already has
+	Insecure bool   `json:"insecure"` // XoT: skip certificate verification
+	// ReadTimeoutMs bounds each message read or write, default 10000;
+	// timeout_ms applies to connecting, as for other requests.
+	ReadTimeoutMs int  `json:"read_timeout_ms"`
+	Records       bool `json:"records"` // ZoneTransferJson: include every record in the result
+}
+
+// TransferSummary describes a finished transfer and what its verification found.
+type TransferSummary struct {
+	Zone   string `json:"zone"`
+	Type   string `json:"type"`
+	Server string `json:"server"`
+	Serial uint32 `json:"serial"` // SOA serial the server sent
+	// Mode is "axfr"; or for IXFR "incremental", "full" (server sent the whole
+	// zone) or "up-to-date" (a single SOA, nothing to transfer).
+	Mode     string         `json:"mode"`
+	Deltas   int            `json:"deltas,omitempty"` // IXFR difference sequences
+	Messages int            `json:"messages"`
+	Records  int            `json:"records"`
+	Types    map[string]int `json:"types"`
+	Duration time.Duration  `json:"duration"`
+	Tsig     *TsigStatus    `json:"tsig,omitempty"`
+	Verified bool           `json:"verified"`
+	Problems []string       `json:"problems,omitempty"`
+}
+
+// xfrCheck follows the record stream without keeping it, enough to verify the
+// SOA framing of RFC 5936 §2.2 and RFC 1995 §4.
+type xfrCheck struct {
+	zone    string
+	n       int
+	first   *dns.SOA
+	second  dns.RR
+	last    dns.RR
+	soas    int
+	outside int
+	types   map[string]int
+}
+
+func (c *xfrCheck) add(rr dns.RR) {
+	c.n++
+	switch c.n {
+	case 1:
+		c.first, _ = rr.(*dns.SOA)
+	case 2:
+		c.second = rr
+	}
+	c.last = rr
+	if _, ok := rr.(*dns.SOA); ok {
+		c.soas++
+	}
+	if !dns.IsSubDomain(c.zone, rr.Header().Name) {
+		c.outside++
+	}
+	c.types[dns.TypeToString[rr.Header().Rrtype]]++
+}
+
+// finish fills in mode, serial and problems.
+func (c *xfrCheck) finish(s *TransferSummary, qtype uint16) {
+	s.Records, s.Types = c.n, c.types
+	if c.first == nil {
+		s.Problems = append(s.Problems, "transfer does not start with an SOA")
+		return
+	}
+	s.Serial = c.first.Serial
+	closed := func() {
+		last, ok := c.last.(*dns.SOA)
+		if c.n < 2 || !ok || last.Serial != c.first.Serial {
+			s.Problems = append(s.Problems, "transfer does not end with the starting SOA")
+		}
+	}
+	switch {
+	case qtype == dns.TypeAXFR:
+		s.Mode = "axfr"
+		closed()
+		if c.soas > 2 {
+			s.Problems = append(s.Problems, fmt.Sprintf("%d SOA records inside the zone", c.soas-2))
+		}
+	case c.n == 1:
+		s.Mode = "up-to-date"
+	case c.second.Header().Rrtype == dns.TypeSOA:
+		s.Mode = "incremental"
+		closed()
+		if c.soas%2 != 0 {
+			s.Problems = append(s.Problems, "unbalanced IXFR difference sequences")
+		}
+		s.Deltas = (c.soas - 2) / 2
+	default:
+		s.Mode = "full"
+		closed()
+	}
+	if c.outside > 0 {
+		s.Problems = append(s.Problems, fmt.Sprintf("%d records outside %s", c.outside, c.zone))
+	}
+}
+
+// ZoneTransfer runs an AXFR or IXFR and hands each received message's records to
+// onRecords (if set) as they arrive.
+func ZoneTransfer(opts TransferOptions, onRecords func([]dns.RR)) (*TransferSummary, error) {
+	qtype := dns.TypeAXFR
+	switch strings.ToUpper(opts.Qtype) {
+	case "", "AXFR":
+	case "IXFR":
+		qtype = dns.TypeIXFR
+	default:
+		return nil, fmt.Errorf("invalid transfer type %q, want AXFR or IXFR", opts.Qtype)
+	}
+	if strings.TrimSpace(opts.Qname) == "" {
+		return nil, errors.New("empty zone")
+	}
+	req := opts.request()
+	switch req.net {
+	case "udp":
+		if strings.HasPrefix(opts.Server, "udp://") {
+			return nil, errors.New("zone transfers need tcp:// or tls:// (XoT)")
+		}
+		req.net = "tcp"
+	case "tcp", "tcp-tls":
+	default:
+		return nil, errors.New("zone transfers need tcp:// or tls:// (XoT)")
+	}
+	if opts.Sig0 != nil {
+		return nil, errors.New("SIG(0) is not supported for zone transfers, use tsig")
+	}
+	key, err := opts.Tsig.key()
+	if err != nil {
+		return nil, err
+	}
+	timeout := 10 * time.Second
+	if opts.ReadTimeoutMs > 0 {
+		timeout = time.Duration(opts.ReadTimeoutMs) * time.Millisecond
+	}
+
+	u, err := req.newUpstream()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+	start := time.Now()
+	// RFC 9103 §9: XoT requires TLS 1.3.
+	conn, err := req.dialClassic(ctx, u, req.net, &tls.Config{MinVersion: tls.VersionTLS13, InsecureSkipVerify: opts.Insecure})
+	if err != nil {
+		return nil, err
+	}
+
+	m := buildDnsMassage(opts.Qname, dns.TypeToString[qtype], "IN")
+	m.RecursionDesired = false
+	zone := m.Question[0].Name
+	if qtype == dns.TypeIXFR {
+		m.Ns = []dns.RR{&dns.SOA{
+			Hdr:    dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET},
+			Ns:     ".",
+			Mbox:   ".",
+			Serial: opts.Serial,
+		}}
+	}
+	t := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: timeout, WriteTimeout: timeout}
+	if key != nil {
+		key.attach(m)
+		t.TsigProvider = key
+	}
+	envs, err := t.In(m, conn.RemoteAddr().String())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	summary := &TransferSummary{Zone: zone, Type: dns.TypeToString[qtype], Server: opts.Server}
+	check := &xfrCheck{zone: zone, types: make(map[string]int)}
+	for env := range envs {
+		if env.Error != nil {
+			// Drain so the reader goroutine can exit; it closes the connection.
+			for range envs {
+			}
+			return nil, fmt.Errorf("%s after %d messages: %w", summary.Type, summary.Messages, env.Error)
+		}
+		summary.Messages++
+		for _, rr := range env.RR {
+			check.add(rr)
+		}
+		if onRecords != nil {
+			onRecords(env.RR)
+		}
+	}
+	summary.Duration = time.Since(start)
+	check.finish(summary, qtype)
+	if key != nil {
+		summary.Tsig = key.status(summary.Messages)
+		if summary.Tsig.Signed == 0 {
+			summary.Problems = append(summary.Problems, "responses are not TSIG signed")
+		}
+	}
+	summary.Verified = len(summary.Problems) == 0
+	return summary, nil
+}
+
+// withDefaultPort appends port when addr has none.
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// ZoneTransferJson runs a transfer and returns its summary; with "records":true
+// the summary also lists every record received under "answer".
+// Fields: server, qname (zone), qtype (AXFR|IXFR), serial, tsig {name, secret, algorithm},
+// insecure, read_timeout_ms, records, plus the dialing fields of DnsRequestJson.
+// Example: {"server":"tcp://192.0.2.53","qname":"example.com","qtype":"AXFR","read_timeout_ms":30000}
+// Example: {"server":"tls://ns1.example.com","qname":"example.com","qtype":"IXFR","serial":2024010101,"tsig":{"name":"xfr-key","secret":"c2VjcmV0"}}
+func ZoneTransferJson(jsonStr string) string {
+	var in TransferOptions
+	if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	var records []map[string]interface{}
+	var onRecords func([]dns.RR)
+	if in.Records {
+		records = []map[string]interface{}{}
+		onRecords = func(rrs []dns.RR) {
+			for _, rr := range rrs {
+				records = append(records, recordData(rr))
+			}
+		}
+	}
+	summary, err := ZoneTransfer(in, onRecords)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return transferResultString(summary, records)
+}
+
+// ZoneTransferJsonStream is ZoneTransferJson for large zones: emit receives one
+// {"message":n,"records":[...]} object per response message, then the summary
+// (with "done":true) or an error object.
+func ZoneTransferJsonStream(jsonStr string, emit func(string)) {
+	var in TransferOptions
+	if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+		emit(utils.BuildErrJSON(err))
+		return
+	}
+	n := 0
+	summary, err := ZoneTransfer(in, func(rrs []dns.RR) {
+		n++
+		records := make([]map[string]interface{}, 0, len(rrs))
+		for _, rr := range rrs {
+			records = append(records, recordData(rr))
+		}
+		if b, err := json.Marshal(map[string]interface{}{"message": n, "records": records}); err == nil {
+			emit(string(b))
+		}
+	})
+	if err != nil {
+		emit(utils.BuildErrJSON(err))
+		return
+	}
+	emit(transferResultString(summary, nil))
+}
+
+// transferResult is the final JSON object: the summary, optionally with the records.
+type transferResult struct {
+	*TransferSummary
+	Done   bool                     `json:"done"`
+	Answer []map[string]interface{} `json:"answer,omitempty"`
+}
+
+func transferResultString(summary *TransferSummary, records []map[string]interface{}) string {
+	jsonData, err := json.Marshal(transferResult{TransferSummary: summary, Done: true, Answer: records})
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestXfrCheck(t *testing.T) {
+	soa := func(serial int) string {
+		return fmt.Sprintf("example.test. 300 IN SOA ns1.example.test. hostmaster.example.test. %d 3600 600 86400 60", serial)
+	}
+	www := "www.example.test. 300 IN A 192.0.2.1"
+	tests := []struct {
+		name     string
+		qtype    uint16
+		records  []string
+		mode     string
+		deltas   int
+		problems []string
+	}{
+		{"axfr", dns.TypeAXFR, []string{soa(3), www, soa(3)}, "axfr", 0, nil},
+		{"axfr unterminated", dns.TypeAXFR, []string{soa(3), www}, "axfr", 0,
+			[]string{"transfer does not end with the starting SOA"}},
+		{"axfr ends with other serial", dns.TypeAXFR, []string{soa(3), www, soa(4)}, "axfr", 0,
+			[]string{"transfer does not end with the starting SOA"}},
+		{"axfr inner soa", dns.TypeAXFR, []string{soa(3), soa(2), www, soa(3)}, "axfr", 0,
+			[]string{"1 SOA records inside the zone"}},
+		{"no leading soa", dns.TypeAXFR, []string{www, soa(3)}, "", 0,
+			[]string{"transfer does not start with an SOA"}},
+		{"out of zone", dns.TypeAXFR, []string{soa(3), "www.example.org. 300 IN A 192.0.2.1", soa(3)}, "axfr", 0,
+			[]string{"1 records outside example.test."}},
+		{"ixfr up to date", dns.TypeIXFR, []string{soa(3)}, "up-to-date", 0, nil},
+		{"ixfr incremental", dns.TypeIXFR, []string{soa(3), soa(1), www, soa(2), soa(2), soa(3), www, soa(3)}, "incremental", 2, nil},
+		{"ixfr unbalanced", dns.TypeIXFR, []string{soa(3), soa(1), www, soa(3)}, "incremental", 0,
+			[]string{"unbalanced IXFR difference sequences"}},
+		{"ixfr full", dns.TypeIXFR, []string{soa(3), www, soa(3)}, "full", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &xfrCheck{zone: "example.test.", types: make(map[string]int)}
+			for _, s := range tt.records {
+				c.add(mustRR(t, s))
+			}
+			var s TransferSummary
+			c.finish(&s, tt.qtype)
+			if s.Mode != tt.mode || s.Deltas != tt.deltas || s.Records != len(tt.records) {
+				t.Fatalf("mode %q deltas %d records %d, want %q %d %d", s.Mode, s.Deltas, s.Records, tt.mode, tt.deltas, len(tt.records))
+			}
+			if strings.Join(s.Problems, "; ") != strings.Join(tt.problems, "; ") {
+				t.Fatalf("problems %q, want %q", s.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+// transferServer serves testZone plus enough hosts to span several messages.
+func transferServer(t *testing.T) (*dnstest.Server, int) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString(testZone)
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, "host%d A 192.0.2.%d\n", i, 100+i)
+	}
+	z, err := dnstest.ParseZoneString(b.String(), "example.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dnstest.NewServer(z)
+	t.Cleanup(func() { s.Close() })
+	return s, len(z.Transfer())
+}
+
+func TestZoneTransfer(t *testing.T) {
+	s, records := transferServer(t)
+	for _, scheme := range []string{"tcp", "tls"} {
+		u, err := s.Listen(scheme, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(scheme, func(t *testing.T) {
+			var streamed int
+			sum, err := ZoneTransfer(TransferOptions{DnsRequestOptions: DnsRequestOptions{Server: u, Qname: "example.test", TimeoutMs: 2000}},
+				func(rrs []dns.RR) { streamed += len(rrs) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sum.Verified || sum.Mode != "axfr" || sum.Serial != 1 || sum.Records != records || streamed != records {
+				t.Fatalf("summary %+v, streamed %d, want %d records", sum, streamed, records)
+			}
+			if sum.Messages < 3 || sum.Types["A"] != 44 {
+				t.Fatalf("%d messages, types %v", sum.Messages, sum.Types)
+			}
+		})
+	}
+
+	u := s.URL("tcp")
+	t.Run("tsig", func(t *testing.T) {
+		s.SetSigner(dnstest.TSIG{Name: "xfr.example.", Secret: testTsigSecret})
+		defer s.SetSigner(nil)
+		sum, err := ZoneTransfer(TransferOptions{DnsRequestOptions: DnsRequestOptions{Server: u, Qname: "example.test", TimeoutMs: 2000,
+			Tsig: &TsigOptions{Name: "xfr.example", Secret: testTsigSecret}}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sum.Verified || sum.Tsig == nil || sum.Tsig.Signed != sum.Messages || sum.Messages < 3 {
+			t.Fatalf("summary %+v, tsig %+v", sum, sum.Tsig)
+		}
+	})
+	t.Run("unsigned reply to tsig", func(t *testing.T) {
+		sum, err := ZoneTransfer(TransferOptions{DnsRequestOptions: DnsRequestOptions{Server: u, Qname: "example.test", TimeoutMs: 2000,
+			Tsig: &TsigOptions{Name: "xfr.example", Secret: testTsigSecret}}}, nil)
+		if err == nil && sum.Verified {
+			t.Fatal("unsigned transfer verified")
+		}
+	})
+	for _, tt := range []struct {
+		serial uint32
+		mode   string
+	}{{1, "up-to-date"}, {5, "up-to-date"}, {0, "full"}} {
+		t.Run(fmt.Sprintf("ixfr from %d", tt.serial), func(t *testing.T) {
+			sum, err := ZoneTransfer(TransferOptions{DnsRequestOptions: DnsRequestOptions{Server: u, Qname: "example.test", Qtype: "IXFR", TimeoutMs: 2000}, Serial: tt.serial}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum.Mode != tt.mode || !sum.Verified {
+				t.Fatalf("summary %+v, want mode %s", sum, tt.mode)
+			}
+		})
+	}
+}
+
+func TestZoneTransferRejects(t *testing.T) {
+	for name, opts := range map[string]TransferOptions{
+		"udp":      {DnsRequestOptions: DnsRequestOptions{Server: "udp://127.0.0.1", Qname: "example.test"}},
+		"https":    {DnsRequestOptions: DnsRequestOptions{Server: "https://127.0.0.1/dns-query", Qname: "example.test"}},
+		"type":     {DnsRequestOptions: DnsRequestOptions{Server: "tcp://127.0.0.1", Qname: "example.test", Qtype: "A"}},
+		"no zone":  {DnsRequestOptions: DnsRequestOptions{Server: "tcp://127.0.0.1"}},
+		"sig0":     {DnsRequestOptions: DnsRequestOptions{Server: "tcp://127.0.0.1", Qname: "example.test", Sig0: &Sig0Options{}}},
+		"bad tsig": {DnsRequestOptions: DnsRequestOptions{Server: "tcp://127.0.0.1", Qname: "example.test", Tsig: &TsigOptions{Name: "k"}}},
+	} {
+		if _, err := ZoneTransfer(opts, nil); err == nil {
+			t.Errorf("%s: transfer accepted", name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBootstrapNoAddress = errors.New("bootstrap: no usable address")
+
+// HostPin 对应 curl 的 --resolve host:port:addr[,addr...]；Port 为 "*" 时匹配任意端口。
+type HostPin struct {
+	Host  string
+	Port  string
+	Addrs []netip.Addr
+}
+
+// ParseHostPin 解析 "host:port:addr[,addr...]"，IPv6 地址可带方括号。
+func ParseHostPin(s string) (HostPin, error) {
+	var pin HostPin
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return pin, fmt.Errorf("invalid resolve entry %q, want host:port:addr", s)
+	}
+	pin.Host = strings.ToLower(strings.TrimSuffix(parts[0], "."))
+	pin.Port = parts[1]
+	for _, a := range strings.Split(parts[2], ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+		ip, err := netip.ParseAddr(a)
+		if err != nil {
+			return pin, fmt.Errorf("invalid resolve entry %q: %w", s, err)
+		}
+		pin.Addrs = append(pin.Addrs, ip.Unmap())
+	}
+	return pin, nil
+}
+
+// Resolver 在拨号前把主机名解析为地址（如指定的 bootstrap DNS 服务器）。
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// BootstrapOptions：Pins 优先匹配；未命中时交给 Resolver；Resolver 为 nil 时原样交给下层拨号器。
+type BootstrapOptions struct {
+	Pins     []HostPin
+	Resolver Resolver
+	// Server 仅用于结果报告，如 "udp://8.8.8.8"
+	Server string
+	// 与 DialOptions.IPVersion 一致，用于筛选解析结果
+	IPVersion int
+}
+
+// BootstrapRecord 记录一次拨号前的主机名解析，与 DNS 查询结果分开报告。
+type BootstrapRecord struct {
+	Host   string        `json:"host"`
+	Port   string        `json:"port"`
+	Source string        `json:"source"` // "pin" 或 "bootstrap"
+	Server string        `json:"server,omitempty"`
+	Addrs  []string      `json:"addrs"`
+	RTT    time.Duration `json:"rtt"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// BootstrapDialer 在下层拨号器之前完成主机名解析，避免走被污染的系统解析器。
+type BootstrapDialer struct {
+	inner Dialer
+	opts  BootstrapOptions
+
+	mu      sync.Mutex
+	cache   map[string][]netip.Addr
+	pinned  map[string]bool // 已记录过的 pin 命中（host:port）
+	records []BootstrapRecord
+}
+
+// maxBootstrapRecords 限制长期复用的拨号器保留的解析记录数（如反复失败的 bootstrap 查询）。
+const maxBootstrapRecords = 64
+
+func NewBootstrapDialer(inner Dialer, opts BootstrapOptions) *BootstrapDialer {
+	return &BootstrapDialer{
+		inner:  inner,
+		opts:   opts,
+		cache:  make(map[string][]netip.Addr),
+		pinned: make(map[string]bool),
+	}
+}
+
+// Records 返回到目前为止的解析记录（副本）。
+func (b *BootstrapDialer) Records() []BootstrapRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BootstrapRecord(nil), b.records...)
+}
+
+func (b *BootstrapDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	addrs, port, err := b.resolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if addrs == nil {
+		return b.inner.DialContext(ctx, network, address)
+	}
+	var firstErr error
+	for _, ip := range addrs {
+		conn, err := b.inner.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+func (b *BootstrapDialer) DialPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	pd, ok := b.inner.(PacketDialer)
+	if !ok {
+		return nil, ErrUDPUnsupported
+	}
+	addrs, port, err := b.resolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if addrs == nil {
+		return pd.DialPacket(ctx, network, address)
+	}
+	// UDP 无握手，无法判断地址是否可达，取第一个即可
+	return pd.DialPacket(ctx, network, net.JoinHostPort(addrs[0].String(), port))
+}
+
+// resolve 返回 nil 地址表示无需（或无法）预解析，直接交给下层拨号器。
+func (b *BootstrapDialer) resolve(ctx context.Context, network, address string) ([]netip.Addr, string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil, port, nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var (
+		addrs  []netip.Addr
+		source string
+	)
+	for _, pin := range b.opts.Pins {
+		if pin.Host == name && (pin.Port == "*" || pin.Port == port) {
+			addrs, source = pin.Addrs, "pin"
+			break
+		}
+	}
+	if addrs == nil {
+		if b.opts.Resolver == nil {
+			return nil, port, nil
+		}
+		b.mu.Lock()
+		cached, ok := b.cache[name]
+		b.mu.Unlock()
+		if ok {
+			addrs = cached
+		} else {
+			start := time.Now()
+			addrs, err = b.opts.Resolver.LookupHost(ctx, name)
+			rec := BootstrapRecord{Host: name, Port: port, Source: "bootstrap", Server: b.opts.Server, RTT: time.Since(start)}
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			rec.Addrs = addrStrings(addrs)
+			b.mu.Lock()
+			b.record(rec)
+			if err == nil {
+				b.cache[name] = addrs
+			}
+			b.mu.Unlock()
+			if err != nil {
+				return nil, port, fmt.Errorf("bootstrap resolve %s via %s: %w", name, b.opts.Server, err)
+			}
+		}
+	} else {
+		// pin 不会变化，每个 host:port 只记录一次
+		key := net.JoinHostPort(name, port)
+		b.mu.Lock()
+		if !b.pinned[key] {
+			b.pinned[key] = true
+			b.record(BootstrapRecord{Host: name, Port: port, Source: source, Addrs: addrStrings(addrs)})
+		}
+		b.mu.Unlock()
+	}
+
+	addrs = filterFamily(ForceNetwork(network, b.opts.IPVersion), addrs)
+	if len(addrs) == 0 {
+		return nil, port, fmt.Errorf("%w for %s (%s)", ErrBootstrapNoAddress, name, network)
+	}
+	return addrs, port, nil
+}
+
+// record 追加一条解析记录，超出 maxBootstrapRecords 时丢弃；调用方持有 b.mu。
+func (b *BootstrapDialer) record(rec BootstrapRecord) {
+	if len(b.records) < maxBootstrapRecords {
+		b.records = append(b.records, rec)
+	}
+}
+
+// filterFamily 按 tcp4/udp6 等网络类型筛选地址族。
+func filterFamily(network string, addrs []netip.Addr) []netip.Addr {
+	var want4, want6 bool
+	switch {
+	case strings.HasSuffix(network, "4"):
+		want4 = true
+	case strings.HasSuffix(network, "6"):
+		want6 = true
+	default:
+		return addrs
+	}
+	out := make([]netip.Addr, 0, len(addrs))
+	for _, a := range addrs {
+		if (want4 && a.Is4()) || (want6 && a.Is6()) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func addrStrings(addrs []netip.Addr) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+type failingResolver struct{}
+
+func (failingResolver) LookupHost(context.Context, string) ([]netip.Addr, error) {
+	return nil, errors.New("no answer")
+}
+
+// 长期复用的拨号器不能随拨号次数无限累积解析记录。
+func TestBootstrapRecordsBounded(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	pin, err := ParseHostPin("dns.example:" + port + ":127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBootstrapDialer(NewDirectDialer(DialOptions{}), BootstrapOptions{Pins: []HostPin{pin}, Resolver: failingResolver{}})
+	for i := 0; i < 10; i++ {
+		c, err := b.DialContext(context.Background(), "tcp", "dns.example:"+port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	if n := len(b.Records()); n != 1 {
+		t.Fatalf("%d records after 10 pinned dials, want 1", n)
+	}
+	for i := 0; i < 2*maxBootstrapRecords; i++ {
+		if _, err := b.DialContext(context.Background(), "tcp", "other.example:53"); err == nil {
+			t.Fatal("dial succeeded with a failing resolver")
+		}
+	}
+	if n := len(b.Records()); n != maxBootstrapRecords {
+		t.Fatalf("%d records, want the cap of %d", n, maxBootstrapRecords)
+	}
+}
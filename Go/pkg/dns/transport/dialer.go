@@ -2,13 +2,30 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
 	"time"
 )
 
+// ErrUDPUnsupported 拨号器不支持 UDP（如 HTTP CONNECT 代理）。
+var ErrUDPUnsupported = errors.New("dialer does not support UDP")
+
 type DialOptions struct {
 	Timeout   time.Duration
 	KeepAlive time.Duration
+
+	// 本地源地址（多出口主机逐个出口测试）；零值由系统选择。
+	LocalAddr netip.Addr
+	// 绑定网卡（Linux SO_BINDTODEVICE，需要 CAP_NET_RAW）；其他平台返回 ErrBindToDeviceUnsupported。
+	Interface string
+	// 强制 IP 协议族：4 或 6；0 表示不限制（设置 LocalAddr 时跟随其协议族）。
+	IPVersion int
+	// 套接字级选项（fwmark、DSCP/TOS、TTL、TFO、缓冲区等）。
+	Socket SocketOptions
+	// Leaks 非 nil 时经其解析器做本地解析并记录查询（DNS 泄漏检查）。
+	Leaks *LeakDetector
 }
 
 type Dialer interface {
@@ -18,3 +35,35 @@ type Dialer interface {
 type PacketDialer interface {
 	DialPacket(ctx context.Context, network, address string) (net.PacketConn, error)
 }
+
+// ForceNetwork 把 "tcp"/"udp" 收窄为 "tcp4"/"udp6" 等；ipVersion 为 0 时原样返回。
+func ForceNetwork(network string, ipVersion int) string {
+	switch ipVersion {
+	case 4, 6:
+	default:
+		return network
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		network = "tcp"
+	case "udp", "udp4", "udp6":
+		network = "udp"
+	default:
+		return network
+	}
+	if ipVersion == 4 {
+		return network + "4"
+	}
+	return network + "6"
+}
+
+// runHandshake 执行代理握手 fn；ctx 取消时把连接截止时间设为过去以打断阻塞的读写，并返回 ctx 的错误。
+func runHandshake(ctx context.Context, conn net.Conn, fn func() error) error {
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	err := fn()
+	if !stop() {
+		// 截止时间已被改写，即使握手恰好完成，连接也不可再用
+		return fmt.Errorf("proxy handshake: %w", ctx.Err())
+	}
+	return err
+}
@@ -8,35 +8,81 @@ import (
 	"time"
 )
 
+var ErrBindToDeviceUnsupported = errors.New("binding to an interface is only supported on linux")
+
 type DirectDialer struct {
-	d net.Dialer
+	d    net.Dialer
+	opts DialOptions
 }
 
 func NewDirectDialer(opts DialOptions) *DirectDialer {
 	d := net.Dialer{
 		Timeout:   opts.Timeout,
 		KeepAlive: opts.KeepAlive,
+		Control:   controlFunc(opts),
+	}
+	if opts.Leaks != nil {
+		d.Resolver = opts.Leaks.Resolver()
+	}
+	return &DirectDialer{d: d, opts: opts}
+}
+
+// ipVersion 返回实际生效的协议族：显式设置优先，否则跟随 LocalAddr。
+func (dd *DirectDialer) ipVersion() int {
+	if dd.opts.IPVersion != 0 {
+		return dd.opts.IPVersion
+	}
+	if a := dd.opts.LocalAddr; a.IsValid() {
+		if a.Unmap().Is4() {
+			return 4
+		}
+		return 6
 	}
-	return &DirectDialer{d: d}
+	return 0
 }
 
 func (dd *DirectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	return dd.d.DialContext(ctx, network, address)
+	network = ForceNetwork(network, dd.ipVersion())
+	d := dd.d
+	if a := dd.opts.LocalAddr; a.IsValid() {
+		switch network {
+		case "udp", "udp4", "udp6":
+			d.LocalAddr = &net.UDPAddr{IP: a.Unmap().AsSlice(), Zone: a.Zone()}
+		default:
+			d.LocalAddr = &net.TCPAddr{IP: a.Unmap().AsSlice(), Zone: a.Zone()}
+		}
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) == nil {
+		dd.opts.Leaks.noteHost(host)
+	}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if nd := dd.opts.Socket.NoDelay; nd != nil {
+		if tc, ok := conn.(*net.TCPConn); ok {
+			if err := tc.SetNoDelay(*nd); err != nil {
+				_ = conn.Close()
+				return nil, fmt.Errorf("set TCP_NODELAY: %w", err)
+			}
+		}
+	}
+	return conn, nil
 }
 
 func (dd *DirectDialer) DialPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
 	switch network {
 	case "udp", "udp4", "udp6":
-		// 解析远端地址
-		raddr, err := net.ResolveUDPAddr(network, address)
-		if err != nil {
-			return nil, fmt.Errorf("resolve udp addr: %w", err)
-		}
-		// 可选：基于 dd.d.LocalAddr 构造本地地址，这里用 nil 即自动选择
-		pc, err := net.DialUDP(network, nil, raddr)
+		// 经 net.Dialer 拨号，以便应用本地地址、协议族与 Control 钩子
+		c, err := dd.DialContext(ctx, network, address)
 		if err != nil {
 			return nil, fmt.Errorf("dial udp: %w", err)
 		}
+		pc, ok := c.(*net.UDPConn)
+		if !ok {
+			_ = c.Close()
+			return nil, fmt.Errorf("dial udp: unexpected conn type %T", c)
+		}
 		// 将 ctx 的截止时间应用到底层连接（若有）
 		if deadline, ok := ctx.Deadline(); ok {
 			_ = pc.SetDeadline(deadline)
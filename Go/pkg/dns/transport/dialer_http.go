@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HttpConnectDialer 通过 HTTP CONNECT 隧道拨 TCP（用于 TCP/TLS/HTTP），支持 Basic 认证与到代理的 TLS（https:// 代理）。
+type HttpConnectDialer struct {
+	ProxyAddr string
+	Username  string
+	Password  string
+	// 为 true 时先与代理建立 TLS（https:// 代理）
+	TLS       bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+	// Forward 用于连接代理本身；链式代理时为上一跳的拨号器。
+	Forward Dialer
+}
+
+func NewHttpConnectDialer(addr, user, pass string, useTLS bool, tlsOpts TLSOptions, opts DialOptions) *HttpConnectDialer {
+	return NewHttpConnectDialerVia(nil, addr, user, pass, useTLS, tlsOpts, opts)
+}
+
+// NewHttpConnectDialerVia 经 forward 连接代理（forward 为 nil 时按 opts 直连）。
+func NewHttpConnectDialerVia(forward Dialer, addr, user, pass string, useTLS bool, tlsOpts TLSOptions, opts DialOptions) *HttpConnectDialer {
+	if forward == nil {
+		forward = NewDirectDialer(opts)
+	}
+	h := &HttpConnectDialer{
+		ProxyAddr: addr,
+		Username:  user,
+		Password:  pass,
+		TLS:       useTLS,
+		Timeout:   opts.Timeout,
+		Forward:   forward,
+	}
+	if useTLS {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		h.TLSConfig = tlsOpts.Config(host)
+	}
+	return h
+}
+
+func (h *HttpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.New("http connect dial supports only tcp/tcp4/tcp6")
+	}
+	outer := ctx
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	conn, err := h.Forward.DialContext(ctx, "tcp", h.ProxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, br, err := h.handshake(ctx, conn, address)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	if deadline, ok := outer.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// handshake 在已连到代理的 conn 上完成（可选的）TLS 与 CONNECT，遵循 ctx 的截止时间与取消；失败时关闭 conn。
+func (h *HttpConnectDialer) handshake(ctx context.Context, conn net.Conn, address string) (net.Conn, *bufio.Reader, error) {
+	if h.TLS {
+		tc := tls.Client(conn, h.TLSConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("tls handshake with proxy: %w", err)
+		}
+		conn = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	var br *bufio.Reader
+	if err := runHandshake(ctx, conn, func() (err error) {
+		br, err = h.connect(conn, address)
+		return err
+	}); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, br, nil
+}
+
+// connect 发送 CONNECT 请求并读取响应头；返回的 reader 可能已缓冲了隧道中的数据。
+func (h *HttpConnectDialer) connect(conn net.Conn, address string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if h.Username != "" || h.Password != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("write CONNECT: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, &proxyAuthError{status: resp.Status, challenges: resp.Header.Values("Proxy-Authenticate")}
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("http connect %s: %s", address, resp.Status)
+	}
+	return br, nil
+}
+
+// bufferedConn 先返回 bufio 中已读入的隧道数据，再读底层连接。
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// proxyAuthError 代理返回 407；challenges 为 Proxy-Authenticate 头，供体检列出支持的认证方式。
+type proxyAuthError struct {
+	status     string
+	challenges []string
+}
+
+func (e *proxyAuthError) Error() string { return ErrHttpProxyAuthFailed.Error() + ": " + e.status }
+
+func (e *proxyAuthError) Unwrap() error { return ErrHttpProxyAuthFailed }
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// connectProxy 是进程内的 HTTP CONNECT 代理；auth 非空时要求匹配的 Proxy-Authorization。
+func connectProxy(t *testing.T, auth string, tlsProxy bool) string {
+	t.Helper()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		w.WriteHeader(http.StatusOK)
+		c, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := brw.Flush(); err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(target, brw)
+			target.Close()
+		}()
+		_, _ = io.Copy(c, target)
+	}))
+	if tlsProxy {
+		s.StartTLS()
+	} else {
+		s.Start()
+	}
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+// lineEcho 逐行回显，用来确认隧道两端连通。
+func lineEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, err := bufio.NewReader(c).ReadString('\n')
+				if err == nil {
+					_, _ = io.WriteString(c, line)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestHttpConnectDialer(t *testing.T) {
+	echo := lineEcho(t)
+	tests := []struct {
+		name       string
+		url        string
+		tls        bool
+		auth       string
+		authFailed bool
+	}{
+		{name: "plain", url: "http://"},
+		{name: "basic auth", url: "http://alice:s%3Acret@", auth: "alice:s:cret"},
+		{name: "tls to proxy", url: "https://", tls: true},
+		{name: "no credentials", url: "http://", auth: "alice:s:cret", authFailed: true},
+		{name: "wrong password", url: "http://alice:nope@", auth: "alice:s:cret", authFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := ParseProxyURL(tt.url + connectProxy(t, tt.auth, tt.tls))
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy.TLS.InsecureSkipVerify = true
+			d, err := NewProxyDialer(proxy, DialOptions{Timeout: 2 * time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := d.DialContext(context.Background(), "tcp", echo)
+			if tt.authFailed {
+				if !errors.Is(err, ErrHttpProxyAuthFailed) {
+					t.Fatalf("error %v, want ErrHttpProxyAuthFailed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if tt.tls {
+				if _, ok := c.(*tls.Conn); !ok {
+					t.Fatalf("tunnel over %T, want TLS to the proxy", c)
+				}
+			}
+			if _, err := io.WriteString(c, "ping\n"); err != nil {
+				t.Fatal(err)
+			}
+			if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "ping\n" {
+				t.Fatalf("read %q, %v", line, err)
+			}
+		})
+	}
+}
+
+// 目标不可达时代理返回 502，错误不应被当作认证失败。
+func TestHttpConnectDialerRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+	d := NewHttpConnectDialer(connectProxy(t, "", false), "", "", false, TLSOptions{}, DialOptions{Timeout: 2 * time.Second})
+	if _, err := d.DialContext(context.Background(), "tcp", closed); err == nil || errors.Is(err, ErrHttpProxyAuthFailed) {
+		t.Fatalf("error %v, want a CONNECT failure", err)
+	}
+	if _, err := d.DialContext(context.Background(), "udp", closed); err == nil {
+		t.Fatal("udp dial through an http proxy succeeded")
+	}
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// 直连时，源地址作用于发出的连接；协议族与目标不符时拨号失败。
+func TestDirectDialOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	from := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		from <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+		c.Close()
+	}()
+	d := NewDirectDialer(DialOptions{Timeout: time.Second, LocalAddr: netip.MustParseAddr("127.0.0.2")})
+	c, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	select {
+	case ip := <-from:
+		if ip != "127.0.0.2" {
+			t.Fatalf("server saw a connection from %s, want 127.0.0.2", ip)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no connection reached the server")
+	}
+
+	d = NewDirectDialer(DialOptions{Timeout: time.Second, IPVersion: 6})
+	if c, err := d.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("IPv6-only dial reached an IPv4 address")
+	}
+	d = NewDirectDialer(DialOptions{Timeout: time.Second, LocalAddr: netip.MustParseAddr("::1")})
+	if c, err := d.DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("dial from an IPv6 source reached an IPv4 address")
+	}
+}
+
+// 配置代理时，源地址与协议族作用于到第一跳代理的连接。
+func TestProxyFirstHopDialOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	from := make(chan string, 8)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			from <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+	for _, typ := range []string{"socks5", "http"} {
+		proxy := ProxyOptions{Type: typ, Addr: ln.Addr().String()}
+		opts := DialOptions{Timeout: time.Second, LocalAddr: netip.MustParseAddr("127.0.0.2")}
+		d, err := NewProxyDialer(proxy, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 代理立即断开，拨号失败，但连接已从指定源地址发出
+		_, _ = d.DialContext(context.Background(), "tcp", "192.0.2.1:53")
+		select {
+		case ip := <-from:
+			if ip != "127.0.0.2" {
+				t.Fatalf("%s: proxy saw a connection from %s, want 127.0.0.2", typ, ip)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no connection reached the proxy", typ)
+		}
+
+		opts = DialOptions{Timeout: time.Second, IPVersion: 6}
+		if d, err = NewProxyDialer(proxy, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.DialContext(context.Background(), "tcp", "192.0.2.1:53"); err == nil {
+			t.Fatalf("%s: IPv6-only dial reached an IPv4 proxy", typ)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// 每个代理/凭据保留的预协商空闲会话上限
+	socks5MaxIdleSessions = 4
+	// 空闲会话的最长保留时间；代理通常会关闭长时间未发请求的连接
+	socks5SessionIdleTimeout = 10 * time.Second
+)
+
+var socks5Sessions = struct {
+	mu    sync.Mutex
+	pools map[sessionKey]*sessionPool
+}{pools: make(map[sessionKey]*sessionPool)}
+
+// sessionKey 区分会话池：代理、凭据以及影响控制连接本身的全部拨号选项。
+// 密码只保存其哈希，不在进程生命周期内留下明文。
+type sessionKey struct {
+	proxy, user string
+	pass        [sha256.Size]byte
+	local       netip.Addr
+	iface       string
+	ipVersion   int
+	keepAlive   time.Duration
+	socket      SocketOptions // NoDelay 置空，取值放在 noDelay 中以便按值比较
+	noDelay     int8          // 0 未设置，1 开启，-1 关闭
+}
+
+type idleSession struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// sessionPool 保存已完成方法协商与认证、尚未发送请求的控制连接。
+type sessionPool struct {
+	key     sessionKey
+	mu      sync.Mutex
+	idle    []idleSession
+	warming int
+}
+
+// sessionPool 仅在开启复用且直连代理时返回会话池。
+func (s *Socks5Dialer) sessionPool() *sessionPool {
+	if !s.ReuseSessions {
+		return nil
+	}
+	dd, ok := s.forward().(*DirectDialer)
+	if !ok {
+		return nil
+	}
+	o := dd.opts
+	key := sessionKey{
+		proxy: s.ProxyAddr, user: s.Username, pass: sha256.Sum256([]byte(s.Password)),
+		local: o.LocalAddr, iface: o.Interface, ipVersion: o.IPVersion, keepAlive: o.KeepAlive,
+		socket: o.Socket,
+	}
+	if nd := key.socket.NoDelay; nd != nil {
+		key.noDelay = -1
+		if *nd {
+			key.noDelay = 1
+		}
+		key.socket.NoDelay = nil
+	}
+	socks5Sessions.mu.Lock()
+	defer socks5Sessions.mu.Unlock()
+	p := socks5Sessions.pools[key]
+	if p == nil {
+		p = &sessionPool{key: key}
+		socks5Sessions.pools[key] = p
+	}
+	return p
+}
+
+// get 取出最新的未过期会话；过期的直接关闭。
+func (p *sessionPool) get() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(s.since) < socks5SessionIdleTimeout {
+			return s.conn
+		}
+		_ = s.conn.Close()
+	}
+	return nil
+}
+
+// prewarm 在后台补充一个预协商会话，直到达到上限。
+func (p *sessionPool) prewarm(s *Socks5Dialer) {
+	p.mu.Lock()
+	if len(p.idle)+p.warming >= socks5MaxIdleSessions {
+		p.mu.Unlock()
+		return
+	}
+	p.warming++
+	p.mu.Unlock()
+
+	go func() {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = socks5SessionIdleTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := s.forward().DialContext(ctx, "tcp", s.ProxyAddr)
+		if err == nil {
+			s.setHandshakeDeadline(ctx, conn)
+			if err = runHandshake(ctx, conn, func() error { return s.negotiate(conn) }); err != nil {
+				_ = conn.Close()
+			} else {
+				_ = conn.SetDeadline(time.Time{})
+			}
+		}
+		p.mu.Lock()
+		p.warming--
+		if err == nil {
+			p.idle = append(p.idle, idleSession{conn: conn, since: time.Now()})
+			time.AfterFunc(socks5SessionIdleTimeout, p.expire)
+		}
+		p.mu.Unlock()
+		if err != nil {
+			p.dropIfEmpty()
+		}
+	}()
+}
+
+// expire 关闭超过 socks5SessionIdleTimeout 未被取用的会话，
+// 使批量测试结束后不再拨号时连接也不会一直挂在代理上；池空后一并移除。
+func (p *sessionPool) expire() {
+	p.mu.Lock()
+	defer p.dropIfEmpty()
+	defer p.mu.Unlock()
+	kept := p.idle[:0]
+	for _, s := range p.idle {
+		if time.Since(s.since) < socks5SessionIdleTimeout {
+			kept = append(kept, s)
+		} else {
+			_ = s.conn.Close()
+		}
+	}
+	for i := len(kept); i < len(p.idle); i++ {
+		p.idle[i] = idleSession{}
+	}
+	p.idle = kept
+}
+
+// dropIfEmpty 在池中既无空闲会话也无补充中的会话时把它从 socks5Sessions 中移除。
+func (p *sessionPool) dropIfEmpty() {
+	socks5Sessions.mu.Lock()
+	defer socks5Sessions.mu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 && p.warming == 0 && socks5Sessions.pools[p.key] == p {
+		delete(socks5Sessions.pools, p.key)
+	}
+}
+
+// CloseIdleSocks5Sessions 关闭所有预协商的空闲会话（批量测试结束时调用），返回关闭的数量。
+func CloseIdleSocks5Sessions() int {
+	socks5Sessions.mu.Lock()
+	defer socks5Sessions.mu.Unlock()
+	n := 0
+	for key, p := range socks5Sessions.pools {
+		p.mu.Lock()
+		for _, s := range p.idle {
+			_ = s.conn.Close()
+		}
+		n += len(p.idle)
+		p.idle = nil
+		p.mu.Unlock()
+		delete(socks5Sessions.pools, key)
+	}
+	return n
+}
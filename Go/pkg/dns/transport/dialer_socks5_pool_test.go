@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionPoolKey(t *testing.T) {
+	defer CloseIdleSocks5Sessions()
+	pool := func(opts DialOptions) *sessionPool {
+		d := NewSocks5Dialer("127.0.0.1:1080", "u", "p", opts)
+		d.ReuseSessions = true
+		return d.sessionPool()
+	}
+	on, off := true, false
+	base := pool(DialOptions{})
+	if base == nil || pool(DialOptions{}) != base {
+		t.Fatal("same options do not share a pool")
+	}
+	if p := pool(DialOptions{Socket: SocketOptions{NoDelay: &on}}); p != pool(DialOptions{Socket: SocketOptions{NoDelay: &on}}) {
+		t.Fatal("equal no_delay values do not share a pool")
+	}
+	for name, opts := range map[string]DialOptions{
+		"ip version": {IPVersion: 4},
+		"keepalive":  {KeepAlive: time.Second},
+		"ttl":        {Socket: SocketOptions{TTL: 3}},
+		"buffers":    {Socket: SocketOptions{RecvBuffer: 1 << 16}},
+		"fast open":  {Socket: SocketOptions{FastOpen: true}},
+		"no delay":   {Socket: SocketOptions{NoDelay: &off}},
+	} {
+		if pool(opts) == base {
+			t.Errorf("%s: shares the default pool", name)
+		}
+	}
+}
+
+func TestSessionPoolExpire(t *testing.T) {
+	stale, peer1 := net.Pipe()
+	fresh, peer2 := net.Pipe()
+	defer peer1.Close()
+	defer peer2.Close()
+	defer CloseIdleSocks5Sessions()
+	d := NewSocks5Dialer("127.0.0.1:1080", "u", "secret", DialOptions{})
+	d.ReuseSessions = true
+	p := d.sessionPool()
+	p.idle = []idleSession{
+		{conn: stale, since: time.Now().Add(-2 * socks5SessionIdleTimeout)},
+		{conn: fresh, since: time.Now()},
+	}
+	p.expire()
+	if len(p.idle) != 1 || p.idle[0].conn != fresh {
+		t.Fatalf("kept %d sessions, want only the fresh one", len(p.idle))
+	}
+	if _, err := stale.Write([]byte{0}); err == nil {
+		t.Fatal("expired session left open")
+	}
+
+	// 池空后从注册表移除，下次拨号重新创建
+	p.idle[0].since = time.Now().Add(-2 * socks5SessionIdleTimeout)
+	p.expire()
+	socks5Sessions.mu.Lock()
+	_, kept := socks5Sessions.pools[p.key]
+	socks5Sessions.mu.Unlock()
+	if kept {
+		t.Fatal("empty pool still registered")
+	}
+	if d.sessionPool() == p {
+		t.Fatal("dialer reused the dropped pool")
+	}
+}
+
+// 会话池的键不保存明文密码，但不同密码仍分属不同的池。
+func TestSessionPoolKeyPassword(t *testing.T) {
+	defer CloseIdleSocks5Sessions()
+	pool := func(pass string) *sessionPool {
+		d := NewSocks5Dialer("127.0.0.1:1080", "u", pass, DialOptions{})
+		d.ReuseSessions = true
+		return d.sessionPool()
+	}
+	p := pool("secret")
+	if p == pool("other") || p != pool("secret") {
+		t.Fatal("pools not keyed by password")
+	}
+	if strings.Contains(fmt.Sprintf("%+v", p.key), "secret") {
+		t.Fatal("pool key holds the plaintext password")
+	}
+}
+
+// 开启复用后，预热的会话只需一个 CONNECT 往返；已被关闭的空闲会话换新连接重试。
+func TestSessionPoolReuse(t *testing.T) {
+	defer CloseIdleSocks5Sessions()
+	target := echoServer(t)
+	d := NewSocks5Dialer(socks5Proxy(t), "", "", DialOptions{Timeout: 2 * time.Second})
+	d.ReuseSessions = true
+	ctx := context.Background()
+	c, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	p := d.sessionPool()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		p.mu.Lock()
+		n := len(p.idle)
+		p.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no session prewarmed")
+		}
+	}
+	conn, reused, err := d.dialControl(ctx, true)
+	if err != nil || !reused {
+		t.Fatalf("dialControl: reused %v, %v", reused, err)
+	}
+	conn.Close()
+
+	CloseIdleSocks5Sessions()
+	closed, peer := net.Pipe()
+	peer.Close()
+	p = d.sessionPool()
+	p.mu.Lock()
+	p.idle = append(p.idle, idleSession{conn: closed, since: time.Now()})
+	p.mu.Unlock()
+	c, err = d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatalf("dial after a dead idle session: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
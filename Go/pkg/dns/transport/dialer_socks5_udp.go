@@ -0,0 +1,242 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	s5 "github.com/txthinking/socks5"
+)
+
+// DialPacket: 通过 SOCKS5 UDP ASSOCIATE 建立可发往任意目标的 net.PacketConn；
+// address 作为 Read/Write（net.Conn 语义）的默认目标。目标地址不在本地解析。
+func (s *Socks5Dialer) DialPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, errors.New("socks5 UDP only supports udp/udp4/udp6")
+	}
+	// UDP ASSOCIATE 的数据报需直达代理的中继地址，无法经由上一跳代理转发
+	dd, direct := s.forward().(*DirectDialer)
+	if !direct {
+		return nil, fmt.Errorf("%w: socks5 UDP associate through a proxy chain", ErrUDPUnsupported)
+	}
+	address, err := s.targetAddress(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := parseSocksAddr(address)
+	if err != nil {
+		return nil, err
+	}
+
+	// 控制连接在关联期间一直占用，不取用空闲会话
+	ctrl, _, err := s.dialControl(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	// 客户端地址填 0.0.0.0:0，由代理按来源地址匹配
+	var rp *s5.Reply
+	err = runHandshake(ctx, ctrl, func() (err error) {
+		rp, err = s.request(ctrl, s5.CmdUDP, "0.0.0.0:0")
+		return err
+	})
+	if err != nil {
+		_ = ctrl.Close()
+		var re *replyError
+		if errors.As(err, &re) && re.rep == s5.RepCommandNotSupported {
+			err = fmt.Errorf("%w: %v", ErrSocks5UDPUnsupported, err)
+		}
+		return nil, err
+	}
+	_ = ctrl.SetDeadline(time.Time{})
+
+	relay, err := relayAddress(rp, ctrl.RemoteAddr())
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	c, err := dd.DialContext(ctx, "udp", relay)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, fmt.Errorf("dial socks5 udp relay %s: %w", relay, err)
+	}
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		_ = c.Close()
+		_ = ctrl.Close()
+		return nil, fmt.Errorf("dial socks5 udp relay: unexpected conn type %T", c)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = uc.SetDeadline(deadline)
+	}
+	pc := &s5PacketConn{
+		ctrl:   ctrl,
+		relay:  uc,
+		remote: remote,
+		buf:    make([]byte, 65535),
+	}
+	if s.LocalResolve {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		pc.resolve = func(host string) (netip.Addr, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return lookupLocal(ctx, s.leaks, network, host)
+		}
+	}
+	go pc.watchControl()
+	return pc, nil
+}
+
+// relayAddress 取 BND.ADDR:BND.PORT；代理返回未指定地址时改用控制连接的对端地址。
+func relayAddress(rp *s5.Reply, ctrlRemote net.Addr) (string, error) {
+	host, port, err := net.SplitHostPort(rp.Address())
+	if err != nil {
+		return "", fmt.Errorf("invalid socks5 udp relay address: %w", err)
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		if ta, ok := ctrlRemote.(*net.TCPAddr); ok {
+			host = ta.IP.String()
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// SocksAddr 是未在本地解析的 "host:port"，以 ATYP=domain 交给代理。
+type SocksAddr struct {
+	Host string
+	Port int
+}
+
+func (a *SocksAddr) Network() string { return "udp" }
+func (a *SocksAddr) String() string  { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+
+// parseSocksAddr：IP 字面量返回 *net.UDPAddr，主机名返回 *SocksAddr（不做本地解析）。
+func parseSocksAddr(address string) (net.Addr, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %q", address)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+	return &SocksAddr{Host: host, Port: port}, nil
+}
+
+// s5PacketConn: SOCKS5 UDP 中继上的 net.PacketConn，每个数据报带 SOCKS5 UDP 头（RFC 1928 §7）。
+// 同时实现 net.Conn，Read/Write 使用 DialPacket 时给定的默认目标。
+type s5PacketConn struct {
+	ctrl   net.Conn     // UDP ASSOCIATE 的 TCP 控制连接，关闭即结束关联
+	relay  *net.UDPConn // 已 connect 到代理中继地址的 UDP 套接字
+	remote net.Addr
+	// resolve 非 nil 时（socks5 模式）WriteTo 的主机名目标先在本地解析
+	resolve func(host string) (netip.Addr, error)
+
+	mu  sync.Mutex // 保护 buf
+	buf []byte
+
+	closeOnce sync.Once
+}
+
+// watchControl 在控制连接断开时关闭 UDP 套接字，让阻塞中的读返回。
+func (pc *s5PacketConn) watchControl() {
+	_, _ = io.Copy(io.Discard, pc.ctrl)
+	_ = pc.Close()
+}
+
+// ReadFrom 读取下一个数据报；b 装不下时返回已复制的部分与 io.ErrShortBuffer。
+func (pc *s5PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for {
+		n, err := pc.relay.Read(pc.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		d, err := s5.NewDatagramFromBytes(pc.buf[:n])
+		if err != nil {
+			continue
+		}
+		// 不支持分片重组，丢弃分片数据报
+		if d.Frag != 0 {
+			continue
+		}
+		from, err := parseSocksAddr(d.Address())
+		if err != nil {
+			continue
+		}
+		n = copy(b, d.Data)
+		if n < len(d.Data) {
+			// 与 net.Conn 的 Read 不同，截断的数据报不能静默交给调用方
+			return n, from, io.ErrShortBuffer
+		}
+		return n, from, nil
+	}
+}
+
+func (pc *s5PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if addr == nil {
+		addr = pc.remote
+	}
+	if sa, ok := addr.(*SocksAddr); ok && pc.resolve != nil {
+		ip, err := pc.resolve(sa.Host)
+		if err != nil {
+			return 0, fmt.Errorf("socks5 resolve %s locally: %w", sa.Host, err)
+		}
+		addr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(sa.Port)))
+	}
+	a, h, p, err := s5.ParseAddress(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	if a == s5.ATYPDomain {
+		h = h[1:]
+	}
+	pkt := s5.NewDatagram(a, h, p, b).Bytes()
+	n, err := pc.relay.Write(pkt)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(pkt) {
+		return 0, io.ErrShortWrite
+	}
+	return len(b), nil
+}
+
+func (pc *s5PacketConn) Read(b []byte) (int, error) {
+	n, _, err := pc.ReadFrom(b)
+	return n, err
+}
+
+func (pc *s5PacketConn) Write(b []byte) (int, error) { return pc.WriteTo(b, pc.remote) }
+
+func (pc *s5PacketConn) Close() error {
+	var err error
+	pc.closeOnce.Do(func() {
+		err = pc.relay.Close()
+		_ = pc.ctrl.Close()
+	})
+	return err
+}
+
+func (pc *s5PacketConn) LocalAddr() net.Addr  { return pc.relay.LocalAddr() }
+func (pc *s5PacketConn) RemoteAddr() net.Addr { return pc.remote }
+
+func (pc *s5PacketConn) SetDeadline(t time.Time) error { return pc.relay.SetDeadline(t) }
+
+func (pc *s5PacketConn) SetReadDeadline(t time.Time) error { return pc.relay.SetReadDeadline(t) }
+
+func (pc *s5PacketConn) SetWriteDeadline(t time.Time) error { return pc.relay.SetWriteDeadline(t) }
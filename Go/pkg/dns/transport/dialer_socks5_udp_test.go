@@ -0,0 +1,294 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	s5 "github.com/txthinking/socks5"
+)
+
+// udpProxy 是进程内的最小 SOCKS5 代理：只支持无认证的 UDP ASSOCIATE，
+// 把每个数据报原样回送（源地址即请求中的目标地址），并记录收到的头部。
+type udpProxy struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu    sync.Mutex
+	got   []*s5.Datagram
+	ctrls []net.Conn
+}
+
+func startUDPProxy(t *testing.T) *udpProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &udpProxy{t: t, ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(c)
+		}
+	}()
+	return p
+}
+
+func (p *udpProxy) serve(c net.Conn) {
+	defer c.Close()
+	if _, err := s5.NewNegotiationRequestFrom(c); err != nil {
+		return
+	}
+	if _, err := s5.NewNegotiationReply(s5.MethodNone).WriteTo(c); err != nil {
+		return
+	}
+	rq, err := s5.NewRequestFrom(c)
+	if err != nil || rq.Cmd != s5.CmdUDP {
+		return
+	}
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	port := relay.LocalAddr().(*net.UDPAddr).Port
+	bport := []byte{byte(port >> 8), byte(port)}
+	if _, err := s5.NewReply(s5.RepSuccess, s5.ATYPIPv4, []byte{127, 0, 0, 1}, bport).WriteTo(c); err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.ctrls = append(p.ctrls, c)
+	p.mu.Unlock()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			d, err := s5.NewDatagramFromBytes(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				continue
+			}
+			p.mu.Lock()
+			p.got = append(p.got, d)
+			p.mu.Unlock()
+			_, _ = relay.WriteTo(d.Bytes(), from)
+		}
+	}()
+	// 控制连接断开即结束关联
+	_, _ = io.Copy(io.Discard, c)
+}
+
+func (p *udpProxy) datagrams() []*s5.Datagram {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*s5.Datagram(nil), p.got...)
+}
+
+// closeControls 从代理一侧关闭所有控制连接。
+func (p *udpProxy) closeControls() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.ctrls {
+		c.Close()
+	}
+}
+
+func dialUDP(t *testing.T, p *udpProxy, localResolve bool, target string) *s5PacketConn {
+	t.Helper()
+	d := NewSocks5Dialer(p.ln.Addr().String(), "", "", DialOptions{Timeout: 2 * time.Second})
+	d.LocalResolve = localResolve
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pc, err := d.DialPacket(ctx, "udp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	// 去掉拨号时应用的 ctx 截止时间，由各测试自行设置
+	_ = pc.SetDeadline(time.Time{})
+	return pc.(*s5PacketConn)
+}
+
+func readWithin(t *testing.T, pc net.PacketConn) ([]byte, net.Addr) {
+	t.Helper()
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n], from
+}
+
+func TestSocks5UDPMultiDestination(t *testing.T) {
+	p := startUDPProxy(t)
+	pc := dialUDP(t, p, false, "192.0.2.1:53")
+	dests := []net.Addr{
+		&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53},
+		&net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5353},
+	}
+	for i, dst := range dests {
+		payload := []byte("query-" + strconv.Itoa(i))
+		if _, err := pc.WriteTo(payload, dst); err != nil {
+			t.Fatal(err)
+		}
+		data, from := readWithin(t, pc)
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("read %q, want %q", data, payload)
+		}
+		if from.String() != dst.String() {
+			t.Fatalf("reply from %s, want %s", from, dst)
+		}
+	}
+	// Write/Read 使用 DialPacket 给定的默认目标
+	if _, err := pc.Write([]byte("default")); err != nil {
+		t.Fatal(err)
+	}
+	data, from := readWithin(t, pc)
+	if string(data) != "default" || from.String() != "192.0.2.1:53" {
+		t.Fatalf("default target: got %q from %s", data, from)
+	}
+}
+
+func TestSocks5UDPAddressTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		localResolve bool
+		dst          net.Addr
+		atyp         byte
+		addr         string
+	}{
+		{"ipv4", false, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, s5.ATYPIPv4, "192.0.2.1:53"},
+		{"ipv6", false, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 853}, s5.ATYPIPv6, "[2001:db8::1]:853"},
+		{"domain", false, &SocksAddr{Host: "dns.example", Port: 53}, s5.ATYPDomain, "dns.example:53"},
+		// resolve=local 时主机名先在本地解析
+		{"domain resolved locally", true, &SocksAddr{Host: "localhost", Port: 53}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := startUDPProxy(t)
+			pc := dialUDP(t, p, tt.localResolve, "192.0.2.1:53")
+			if _, err := pc.WriteTo([]byte("x"), tt.dst); err != nil {
+				t.Fatal(err)
+			}
+			_, from := readWithin(t, pc)
+			got := p.datagrams()
+			if len(got) != 1 {
+				t.Fatalf("proxy got %d datagrams, want 1", len(got))
+			}
+			d := got[0]
+			if tt.atyp == 0 {
+				if d.Atyp == s5.ATYPDomain {
+					t.Fatalf("hostname sent as ATYP=domain (%s), want a locally resolved IP", d.Address())
+				}
+				return
+			}
+			if d.Atyp != tt.atyp {
+				t.Fatalf("ATYP %#x, want %#x", d.Atyp, tt.atyp)
+			}
+			if d.Address() != tt.addr {
+				t.Fatalf("header address %s, want %s", d.Address(), tt.addr)
+			}
+			if from.String() != tt.addr {
+				t.Fatalf("reply from %s, want %s", from, tt.addr)
+			}
+		})
+	}
+}
+
+func TestSocks5UDPDeadline(t *testing.T) {
+	p := startUDPProxy(t)
+	pc := dialUDP(t, p, false, "192.0.2.1:53")
+	_ = pc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, _, err := pc.ReadFrom(make([]byte, 512))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("read without data: %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("deadline honoured after %v", d)
+	}
+	// 截止时间过后重新设置即可继续使用
+	_ = pc.SetDeadline(time.Time{})
+	if _, err := pc.Write([]byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := readWithin(t, pc); string(data) != "again" {
+		t.Fatalf("read %q after deadline reset", data)
+	}
+}
+
+func TestSocks5UDPShortBuffer(t *testing.T) {
+	p := startUDPProxy(t)
+	pc := dialUDP(t, p, false, "192.0.2.1:53")
+	if _, err := pc.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 4)
+	n, from, err := pc.ReadFrom(b)
+	if !errors.Is(err, io.ErrShortBuffer) {
+		t.Fatalf("read into a short buffer: %v, want io.ErrShortBuffer", err)
+	}
+	if n != 4 || string(b) != "0123" || from.String() != "192.0.2.1:53" {
+		t.Fatalf("read %d bytes %q from %v", n, b[:n], from)
+	}
+}
+
+func TestSocks5UDPControlClosedByProxy(t *testing.T) {
+	p := startUDPProxy(t)
+	pc := dialUDP(t, p, false, "192.0.2.1:53")
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 512))
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.closeControls()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("read succeeded after the control connection closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked read did not return after the control connection closed")
+	}
+	if _, err := pc.Write([]byte("late")); err == nil {
+		t.Fatal("write succeeded after the association ended")
+	}
+}
+
+func TestSocks5UDPCloseEndsAssociation(t *testing.T) {
+	p := startUDPProxy(t)
+	pc := dialUDP(t, p, false, "192.0.2.1:53")
+	p.mu.Lock()
+	ctrl := p.ctrls[0]
+	p.mu.Unlock()
+	if err := pc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 代理侧应读到 EOF：关闭 PacketConn 即关闭控制连接
+	_ = ctrl.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ctrl.Read(make([]byte, 1)); err == nil {
+		t.Fatal("control connection still open after Close")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("control connection still open after Close")
+	}
+	if err := pc.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeakQuery 是经本地解析器发出的一次 DNS 查询（只知道网络与所用的系统 DNS 服务器）。
+type LeakQuery struct {
+	Network string    `json:"network"`
+	Server  string    `json:"server"`
+	Time    time.Time `json:"time"`
+}
+
+// LeakReport 汇总一次请求中的本地解析：Hosts 为交给本地解析器的主机名，Queries 为实际发出的 DNS 查询。
+type LeakReport struct {
+	Leaked  bool        `json:"leaked"`
+	Hosts   []string    `json:"hosts"`
+	Queries []LeakQuery `json:"queries"`
+}
+
+// LeakDetector 通过 Go 解析器的 Dial 钩子记录本地发出的 DNS 查询，用于确认经 SOCKS5 代理时没有本地解析。
+// 命中 hosts 文件的解析不产生查询，不计为泄漏。
+// 它只看得到本次请求的拨号器经 Resolver() 做的解析；进程内其他代码（net.DefaultResolver、cgo 解析器）
+// 和其他进程发出的查询都不经过钩子，因此 Leaked 为 false 只说明本次拨号没有在本地解析，不代表整机没有泄漏。
+type LeakDetector struct {
+	resolver *net.Resolver
+
+	mu      sync.Mutex
+	hosts   []string
+	queries []LeakQuery
+}
+
+func NewLeakDetector() *LeakDetector {
+	l := &LeakDetector{}
+	var d net.Dialer
+	l.resolver = &net.Resolver{
+		// 强制使用 Go 解析器，cgo 解析器不经过 Dial 钩子
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			l.mu.Lock()
+			l.queries = append(l.queries, LeakQuery{Network: network, Server: address, Time: time.Now()})
+			l.mu.Unlock()
+			return d.DialContext(ctx, network, address)
+		},
+	}
+	return l
+}
+
+// Resolver 返回带记录钩子的解析器；l 为 nil 时返回 net.DefaultResolver。
+func (l *LeakDetector) Resolver() *net.Resolver {
+	if l == nil {
+		return net.DefaultResolver
+	}
+	return l.resolver
+}
+
+func (l *LeakDetector) noteHost(host string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, h := range l.hosts {
+		if h == host {
+			return
+		}
+	}
+	l.hosts = append(l.hosts, host)
+}
+
+// Report 返回到目前为止的记录（副本）。
+func (l *LeakDetector) Report() LeakReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LeakReport{
+		Leaked:  len(l.queries) > 0,
+		Hosts:   append([]string{}, l.hosts...),
+		Queries: append([]LeakQuery{}, l.queries...),
+	}
+}
+
+// lookupLocal 在本地解析 host，按 network（tcp4/udp6 等）筛选协议族，IPv4 优先。
+func lookupLocal(ctx context.Context, l *LeakDetector, network, host string) (netip.Addr, error) {
+	l.noteHost(host)
+	family := "ip"
+	switch {
+	case strings.HasSuffix(network, "4"):
+		family = "ip4"
+	case strings.HasSuffix(network, "6"):
+		family = "ip6"
+	}
+	addrs, err := l.Resolver().LookupNetIP(ctx, family, host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, a := range addrs {
+		if a.Unmap().Is4() {
+			return a.Unmap(), nil
+		}
+	}
+	if len(addrs) == 0 {
+		return netip.Addr{}, fmt.Errorf("lookup %s: no addresses", host)
+	}
+	return addrs[0], nil
+}
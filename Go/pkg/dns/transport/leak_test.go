@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"context"
+	"testing"
+)
+
+// 只有 resolve=local 的 SOCKS5 拨号才把主机名交给本地解析器；命中 hosts 文件不算泄漏。
+func TestLeakDetectorHosts(t *testing.T) {
+	leaks := NewLeakDetector()
+	d := NewSocks5Dialer("127.0.0.1:1080", "", "", DialOptions{Leaks: leaks})
+	if _, err := d.targetAddress(context.Background(), "tcp", "dns.example:853"); err != nil {
+		t.Fatal(err)
+	}
+	if r := leaks.Report(); len(r.Hosts) != 0 || r.Leaked {
+		t.Fatalf("remote resolution recorded %+v", r)
+	}
+	d.LocalResolve = true
+	for i := 0; i < 2; i++ {
+		if _, err := d.targetAddress(context.Background(), "tcp4", "localhost:853"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := leaks.Report()
+	if len(r.Hosts) != 1 || r.Hosts[0] != "localhost" {
+		t.Fatalf("hosts %q, want [localhost] once", r.Hosts)
+	}
+	if r.Leaked || len(r.Queries) != 0 {
+		t.Fatalf("hosts-file lookup reported as a leak: %+v", r.Queries)
+	}
+
+	var nilDetector *LeakDetector
+	if nilDetector.Resolver() == nil {
+		t.Fatal("nil detector has no resolver")
+	}
+}
@@ -52,4 +52,28 @@ type ProxyOptions struct {
 	Addr string
 	User string
 	Pass string
+	// 到代理本身的 TLS 配置（https:// 代理）；ServerName 为空时取代理主机名。
+	TLS TLSOptions
+	// 仅 socks5：复用预先完成认证的空闲会话（见 Socks5Dialer.ReuseSessions）。
+	ReuseSessions bool
+	// 仅 socks5：目标主机名先在本地解析（见 Socks5Dialer.LocalResolve）。
+	LocalResolve bool
+}
+
+// Config 转换为 *tls.Config；serverName 在 ServerName 为空时使用。SPKISHA256Pins 暂不在此处校验。
+func (o TLSOptions) Config(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		ServerName:            o.ServerName,
+		InsecureSkipVerify:    o.InsecureSkipVerify,
+		RootCAs:               o.RootCAs,
+		Certificates:          o.ClientCertificates,
+		NextProtos:            o.NextProtos,
+		MinVersion:            o.MinVersion,
+		MaxVersion:            o.MaxVersion,
+		VerifyPeerCertificate: o.VerifyPeerCertificate,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	return cfg
 }
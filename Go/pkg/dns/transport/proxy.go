@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var ErrHttpProxyAuthFailed = errors.New("http proxy authentication failed")
+
+// ProxyConstructor 根据 ProxyOptions 构造代理拨号器：经 forward 连接代理（nil 表示按 DialOptions 直连）。
+type ProxyConstructor func(proxy ProxyOptions, forward Dialer, opts DialOptions) (Dialer, error)
+
+var proxies map[string]ProxyConstructor
+
+// RegisterProxy 按类型（即代理 URL 的 scheme）注册构造函数。
+func RegisterProxy(types []string, constructor ProxyConstructor) {
+	if proxies == nil {
+		proxies = make(map[string]ProxyConstructor)
+	}
+	for _, t := range types {
+		proxies[t] = constructor
+	}
+}
+
+// NewProxyDialer 按 ProxyOptions.Type 构造直连代理的拨号器。
+func NewProxyDialer(proxy ProxyOptions, opts DialOptions) (Dialer, error) {
+	return NewProxyDialerVia(nil, proxy, opts)
+}
+
+// NewProxyDialerVia 按 ProxyOptions.Type 构造经 forward 连接代理的拨号器。
+func NewProxyDialerVia(forward Dialer, proxy ProxyOptions, opts DialOptions) (Dialer, error) {
+	constructor := proxies[strings.ToLower(proxy.Type)]
+	if constructor == nil {
+		return nil, fmt.Errorf("unknown proxy type: %q", proxy.Type)
+	}
+	return constructor(proxy, forward, opts)
+}
+
+// NewProxyDialerFromURL 解析代理 URL 后构造拨号器。
+func NewProxyDialerFromURL(raw string, opts DialOptions) (Dialer, error) {
+	proxy, err := ParseProxyURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	return NewProxyDialer(proxy, opts)
+}
+
+// ParseProxyURL 解析 "scheme://[user:pass@]host:port"；没有 scheme 时按 socks5 处理。
+// 用户名与密码按百分号编码解码。socks5:// 可加 "?resolve=local" 在本地解析目标主机名。
+func ParseProxyURL(raw string) (ProxyOptions, error) {
+	var opts ProxyOptions
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "socks5://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return opts, fmt.Errorf("invalid proxy: %w", err)
+	}
+	if u.Host == "" {
+		return opts, errors.New("invalid proxy: missing host")
+	}
+	opts.Type = strings.ToLower(u.Scheme)
+	opts.Addr = u.Host
+	if u.Port() == "" {
+		switch opts.Type {
+		case "http":
+			opts.Addr += ":80"
+		case "https":
+			opts.Addr += ":443"
+		case "socks5", "socks5h":
+			opts.Addr += ":1080"
+		}
+	}
+	if u.User != nil {
+		opts.User = u.User.Username()
+		opts.Pass, _ = u.User.Password()
+	}
+	switch r := u.Query().Get("resolve"); {
+	case r == "" || r == "remote":
+	case r == "local" && opts.Type == "socks5":
+		opts.LocalResolve = true
+	default:
+		return opts, fmt.Errorf("invalid proxy: resolve=%s is not supported for %s", r, opts.Type)
+	}
+	return opts, nil
+}
+
+func init() {
+	// 两者都把目标主机名交给代理解析；socks5h 保留为同义写法
+	RegisterProxy([]string{"socks5", "socks5h"}, func(proxy ProxyOptions, forward Dialer, opts DialOptions) (Dialer, error) {
+		d := NewSocks5DialerVia(forward, proxy.Addr, proxy.User, proxy.Pass, opts)
+		d.ReuseSessions = proxy.ReuseSessions
+		d.LocalResolve = proxy.LocalResolve
+		return d, nil
+	})
+	RegisterProxy([]string{"http"}, func(proxy ProxyOptions, forward Dialer, opts DialOptions) (Dialer, error) {
+		return NewHttpConnectDialerVia(forward, proxy.Addr, proxy.User, proxy.Pass, false, proxy.TLS, opts), nil
+	})
+	RegisterProxy([]string{"https"}, func(proxy ProxyOptions, forward Dialer, opts DialOptions) (Dialer, error) {
+		return NewHttpConnectDialerVia(forward, proxy.Addr, proxy.User, proxy.Pass, true, proxy.TLS, opts), nil
+	})
+}
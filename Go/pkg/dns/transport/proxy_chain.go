@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"nettest/pkg/dns/tracing"
+)
+
+// HopStat 记录一次链式拨号中某一跳的耗时。
+type HopStat struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	Addr  string `json:"addr"`
+	// Elapsed 从开始拨号到该跳隧道建立完成的累计耗时
+	Elapsed time.Duration `json:"elapsed"`
+	// Handshake 该跳自身的耗时（Elapsed 减去上一跳的 Elapsed）
+	Handshake time.Duration `json:"handshake"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// ChainDialer 依次经过多跳代理拨号：每一跳经由上一跳建立到自身代理的连接。
+// 拨号器本身无状态，可并发使用；各跳耗时经 WithHopTrace 放入 ctx 的收集器取得。
+type ChainDialer struct {
+	hops []*hopDialer
+}
+
+// hopDialer 包装某一跳的拨号器，统计其拨号耗时。
+type hopDialer struct {
+	index int
+	proxy ProxyOptions
+	inner Dialer
+}
+
+// NewProxyChain 按顺序构造代理链，第一个代理直连（应用 opts），最后一个代理连接目标。
+func NewProxyChain(chain []ProxyOptions, opts DialOptions) (*ChainDialer, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty proxy chain")
+	}
+	c := &ChainDialer{}
+	var forward Dialer
+	for i, p := range chain {
+		d, err := NewProxyDialerVia(forward, p, opts)
+		if err != nil {
+			return nil, err
+		}
+		hop := &hopDialer{index: i, proxy: p, inner: d}
+		c.hops = append(c.hops, hop)
+		forward = hop
+	}
+	return c, nil
+}
+
+type hopTraceKey struct{}
+
+// HopTrace 收集 ctx 下经代理链的拨号中各跳的耗时。
+type HopTrace struct {
+	mu   sync.Mutex
+	last []HopStat
+}
+
+// WithHopTrace 返回带收集器的 ctx；之后经该 ctx 的每次链式拨号都会更新收集器。
+func WithHopTrace(ctx context.Context) (context.Context, *HopTrace) {
+	t := &HopTrace{}
+	return context.WithValue(ctx, hopTraceKey{}, t), t
+}
+
+// Stats 返回最近一次完成的拨号中各跳的耗时（副本）；没有拨号时为 nil。
+func (t *HopTrace) Stats() []HopStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]HopStat(nil), t.last...)
+}
+
+type hopRunKey struct{}
+
+// hopRun 是一次拨号的各跳统计，经 ctx 传给各跳，并发拨号互不干扰。
+type hopRun struct {
+	mu      sync.Mutex
+	started time.Time
+	stats   []HopStat
+}
+
+func (c *ChainDialer) begin(ctx context.Context) (context.Context, *hopRun) {
+	run := &hopRun{started: time.Now(), stats: make([]HopStat, len(c.hops))}
+	for i, h := range c.hops {
+		run.stats[i] = HopStat{Index: i, Type: h.proxy.Type, Addr: h.proxy.Addr}
+	}
+	return context.WithValue(ctx, hopRunKey{}, run), run
+}
+
+// finish 把本次拨号的统计交给 ctx 中的收集器（如有）。
+func (run *hopRun) finish(ctx context.Context) {
+	t, ok := ctx.Value(hopTraceKey{}).(*HopTrace)
+	if !ok {
+		return
+	}
+	run.mu.Lock()
+	stats := append([]HopStat(nil), run.stats...)
+	run.mu.Unlock()
+	t.mu.Lock()
+	t.last = stats
+	t.mu.Unlock()
+}
+
+func (c *ChainDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, run := c.begin(ctx)
+	conn, err := c.hops[len(c.hops)-1].DialContext(ctx, network, address)
+	run.finish(ctx)
+	return conn, err
+}
+
+func (c *ChainDialer) DialPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	top := c.hops[len(c.hops)-1]
+	pd, ok := top.inner.(PacketDialer)
+	if !ok {
+		return nil, ErrUDPUnsupported
+	}
+	ctx, run := c.begin(ctx)
+	start := time.Now()
+	pc, err := pd.DialPacket(ctx, network, address)
+	run.record(ctx, top.index, time.Since(start), err)
+	run.finish(ctx)
+	return pc, err
+}
+
+// record 记录某一跳的耗时，并在 ctx 带有 span 时补记一个 proxy.handshake 子 span，
+// 其区间为上一跳完成到本跳完成。
+func (run *hopRun) record(ctx context.Context, index int, elapsed time.Duration, err error) {
+	run.mu.Lock()
+	st := &run.stats[index]
+	st.Elapsed = elapsed
+	st.Handshake = elapsed
+	if index > 0 {
+		st.Handshake -= run.stats[index-1].Elapsed
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	hop, end := *st, run.started.Add(elapsed)
+	run.mu.Unlock()
+	tracing.Record(ctx, "proxy.handshake", end.Add(-hop.Handshake), end, err,
+		tracing.Int("proxy.hop", hop.Index), tracing.String("proxy.type", hop.Type), tracing.String("proxy.address", hop.Addr))
+}
+
+// DialContext 在 ctx 属于某次链式拨号时记录本跳耗时；其他拨号（如会话池预热）不计入。
+func (h *hopDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := h.inner.DialContext(ctx, network, address)
+	if run, ok := ctx.Value(hopRunKey{}).(*hopRun); ok {
+		run.record(ctx, h.index, time.Since(start), err)
+	}
+	return conn, err
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	s5 "github.com/txthinking/socks5"
+)
+
+// socks5Proxy 是进程内的最小无认证 SOCKS5 代理，只支持 CONNECT。
+func socks5Proxy(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5Connect(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSocks5Connect(c net.Conn) {
+	defer c.Close()
+	if _, err := s5.NewNegotiationRequestFrom(c); err != nil {
+		return
+	}
+	if _, err := s5.NewNegotiationReply(s5.MethodNone).WriteTo(c); err != nil {
+		return
+	}
+	rq, err := s5.NewRequestFrom(c)
+	if err != nil || rq.Cmd != s5.CmdConnect {
+		return
+	}
+	target, err := net.Dial("tcp", rq.Address())
+	if err != nil {
+		_, _ = s5.NewReply(s5.RepHostUnreachable, s5.ATYPIPv4, []byte{0, 0, 0, 0}, []byte{0, 0}).WriteTo(c)
+		return
+	}
+	defer target.Close()
+	if _, err := s5.NewReply(s5.RepSuccess, s5.ATYPIPv4, []byte{0, 0, 0, 0}, []byte{0, 0}).WriteTo(c); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(target, c)
+		target.Close()
+	}()
+	_, _ = io.Copy(c, target)
+}
+
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 64)
+				n, _ := c.Read(buf)
+				_, _ = c.Write(buf[:n])
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// 并发拨号各自经 ctx 得到自己的各跳统计，互不覆盖。
+func TestChainDialerHopTrace(t *testing.T) {
+	target := echoServer(t)
+	chain, err := NewProxyChain([]ProxyOptions{
+		{Type: "socks5", Addr: socks5Proxy(t)},
+		{Type: "socks5", Addr: socks5Proxy(t)},
+	}, DialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(fail bool) {
+			defer wg.Done()
+			ctx, trace := WithHopTrace(context.Background())
+			addr := target
+			if fail {
+				addr = "127.0.0.1:1" // 最后一跳连接目标失败
+			}
+			c, err := chain.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				c.Close()
+			}
+			stats := trace.Stats()
+			if len(stats) != 2 {
+				t.Errorf("%d hops recorded, want 2", len(stats))
+				return
+			}
+			if stats[0].Error != "" || stats[0].Elapsed <= 0 {
+				t.Errorf("first hop %+v", stats[0])
+			}
+			if (stats[1].Error != "") != fail || (err != nil) != fail {
+				t.Errorf("fail=%v: dial error %v, last hop %+v", fail, err, stats[1])
+			}
+		}(i%2 == 1)
+	}
+	wg.Wait()
+	// 不带收集器的拨号照常工作
+	c, err := chain.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+// 混合代理链逐跳建立隧道；各跳耗时累计递增，UDP 无法经链转发。
+func TestChainDialerMixed(t *testing.T) {
+	target := lineEcho(t)
+	chain, err := NewProxyChain([]ProxyOptions{
+		{Type: "http", Addr: connectProxy(t, "", false)},
+		{Type: "socks5", Addr: socks5Proxy(t)},
+		{Type: "http", Addr: connectProxy(t, "", false)},
+	}, DialOptions{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, trace := WithHopTrace(context.Background())
+	c, err := chain.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "ping\n" {
+		t.Fatalf("read %q, %v", line, err)
+	}
+	stats := trace.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("%d hops recorded, want 3", len(stats))
+	}
+	for i, st := range stats {
+		want := []string{"http", "socks5", "http"}[i]
+		if st.Index != i || st.Type != want || st.Error != "" || st.Handshake <= 0 || st.Handshake > st.Elapsed {
+			t.Errorf("hop %d: %+v", i, st)
+		}
+		if i > 0 && st.Elapsed < stats[i-1].Elapsed {
+			t.Errorf("hop %d elapsed %v before hop %d's %v", i, st.Elapsed, i-1, stats[i-1].Elapsed)
+		}
+	}
+
+	socksLast, err := NewProxyChain([]ProxyOptions{
+		{Type: "http", Addr: connectProxy(t, "", false)},
+		{Type: "socks5", Addr: socks5Proxy(t)},
+	}, DialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*ChainDialer{chain, socksLast} {
+		if _, err := d.DialPacket(context.Background(), "udp", "127.0.0.1:53"); !errors.Is(err, ErrUDPUnsupported) {
+			t.Errorf("udp through a chain: %v, want ErrUDPUnsupported", err)
+		}
+	}
+	if _, err := NewProxyChain(nil, DialOptions{}); err == nil {
+		t.Error("empty chain accepted")
+	}
+}
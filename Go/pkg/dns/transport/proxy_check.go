@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	s5 "github.com/txthinking/socks5"
+)
+
+// HandshakeStat 到代理的 TCP 连接耗时与握手（协商/认证，HTTP 代理为 TLS+CONNECT）耗时。
+type HandshakeStat struct {
+	Connect   time.Duration `json:"connect"`
+	Handshake time.Duration `json:"handshake"`
+}
+
+// ProxyProber 由支持体检的代理拨号器实现；target 仅 HTTP 代理使用（CONNECT 的目标）。
+type ProxyProber interface {
+	// ProbeHandshake 连接代理并以配置的凭据完成握手后关闭，分别计时。
+	ProbeHandshake(ctx context.Context, target string) (HandshakeStat, error)
+	// ProbeAuthMethods 返回代理接受的认证方式，如 "none"、"username/password"、"basic"。
+	ProbeAuthMethods(ctx context.Context, target string) ([]string, error)
+}
+
+var (
+	_ ProxyProber = (*Socks5Dialer)(nil)
+	_ ProxyProber = (*HttpConnectDialer)(nil)
+)
+
+// socks5Methods 为逐一探测的认证方式（RFC 1928 §3）
+var socks5Methods = []struct {
+	method byte
+	name   string
+}{
+	{s5.MethodNone, "none"},
+	{s5.MethodGSSAPI, "gssapi"},
+	{s5.MethodUsernamePassword, "username/password"},
+}
+
+func (s *Socks5Dialer) ProbeHandshake(ctx context.Context, _ string) (HandshakeStat, error) {
+	var st HandshakeStat
+	start := time.Now()
+	conn, err := s.forward().DialContext(ctx, "tcp", s.ProxyAddr)
+	if err != nil {
+		return st, err
+	}
+	defer conn.Close()
+	st.Connect = time.Since(start)
+	s.setHandshakeDeadline(ctx, conn)
+	start = time.Now()
+	err = runHandshake(ctx, conn, func() error { return s.negotiate(conn) })
+	st.Handshake = time.Since(start)
+	return st, err
+}
+
+// ProbeAuthMethods 每种方式单独建连并只提供该方式，代理选中即视为支持。
+func (s *Socks5Dialer) ProbeAuthMethods(ctx context.Context, _ string) ([]string, error) {
+	var out []string
+	for _, m := range socks5Methods {
+		ok, err := s.offerMethod(ctx, m.method)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			out = append(out, m.name)
+		}
+	}
+	return out, nil
+}
+
+func (s *Socks5Dialer) offerMethod(ctx context.Context, method byte) (bool, error) {
+	conn, err := s.forward().DialContext(ctx, "tcp", s.ProxyAddr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	s.setHandshakeDeadline(ctx, conn)
+	var rp *s5.NegotiationReply
+	err = runHandshake(ctx, conn, func() error {
+		if _, err := s5.NewNegotiationRequest([]byte{method}).WriteTo(conn); err != nil {
+			return err
+		}
+		rp, err = s5.NewNegotiationReplyFrom(conn)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return rp.Method == method, nil
+}
+
+func (h *HttpConnectDialer) ProbeHandshake(ctx context.Context, target string) (HandshakeStat, error) {
+	var st HandshakeStat
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	conn, err := h.Forward.DialContext(ctx, "tcp", h.ProxyAddr)
+	if err != nil {
+		return st, err
+	}
+	st.Connect = time.Since(start)
+	start = time.Now()
+	conn, _, err = h.handshake(ctx, conn, target)
+	st.Handshake = time.Since(start)
+	if err != nil {
+		return st, err
+	}
+	return st, conn.Close()
+}
+
+// ProbeAuthMethods 不带凭据发送 CONNECT：成功为 "none"，407 时按 Proxy-Authenticate 列出方案。
+func (h *HttpConnectDialer) ProbeAuthMethods(ctx context.Context, target string) ([]string, error) {
+	anon := *h
+	anon.Username, anon.Password = "", ""
+	conn, err := anon.DialContext(ctx, "tcp", target)
+	if err == nil {
+		_ = conn.Close()
+		return []string{"none"}, nil
+	}
+	var ae *proxyAuthError
+	if !errors.As(err, &ae) {
+		return nil, err
+	}
+	var out []string
+	for _, c := range ae.challenges {
+		scheme, _, _ := strings.Cut(strings.TrimSpace(c), " ")
+		if scheme != "" {
+			out = append(out, strings.ToLower(scheme))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrSocketOptionsUnsupported = errors.New("socket options are only supported on linux")
+
+// SocketOptions 在 connect 之前通过 net.Dialer.Control 设置；零值表示保持系统默认。
+type SocketOptions struct {
+	// SO_MARK（策略路由 fwmark），需要 CAP_NET_ADMIN
+	Mark uint32 `json:"mark"`
+	// IP_TOS / IPV6_TCLASS 整字节
+	TOS int `json:"tos"`
+	// DSCP（0-63）；非零时覆盖 TOS 的高 6 位
+	DSCP int `json:"dscp"`
+	// IP_TTL / IPV6_UNICAST_HOPS
+	TTL int `json:"ttl"`
+	// TCP_NODELAY；nil 保持 Go 默认（开启），连接建立后设置
+	NoDelay *bool `json:"no_delay,omitempty"`
+	// TCP_FASTOPEN_CONNECT
+	FastOpen bool `json:"fast_open"`
+	// SO_SNDBUF / SO_RCVBUF（字节）
+	SendBuffer int `json:"send_buffer"`
+	RecvBuffer int `json:"recv_buffer"`
+}
+
+// Validate 检查取值范围。
+func (o SocketOptions) Validate() error {
+	switch {
+	case o.TOS < 0 || o.TOS > 0xff:
+		return fmt.Errorf("tos %d out of range 0-255", o.TOS)
+	case o.DSCP < 0 || o.DSCP > 63:
+		return fmt.Errorf("dscp %d out of range 0-63", o.DSCP)
+	case o.TTL < 0 || o.TTL > 255:
+		return fmt.Errorf("ttl %d out of range 0-255", o.TTL)
+	case o.SendBuffer < 0 || o.RecvBuffer < 0:
+		return errors.New("socket buffer sizes must not be negative")
+	}
+	return nil
+}
+
+// tos 合并 TOS 与 DSCP，得到最终写入的流量类别字节。
+func (o SocketOptions) tos() int {
+	if o.DSCP != 0 {
+		return o.DSCP<<2 | o.TOS&0x3
+	}
+	return o.TOS
+}
+
+// needsControl 表示是否有需要在 Control 钩子中设置的选项（NoDelay 在连接后设置）。
+func (o SocketOptions) needsControl() bool {
+	return o.Mark != 0 || o.tos() != 0 || o.TTL != 0 || o.FastOpen || o.SendBuffer != 0 || o.RecvBuffer != 0
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlFunc 返回在 connect 之前设置套接字选项的钩子；无需设置时返回 nil。
+func controlFunc(opts DialOptions) func(network, address string, c syscall.RawConn) error {
+	so := opts.Socket
+	if opts.Interface == "" && !so.needsControl() {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = applySockopts(int(fd), network, opts.Interface, so)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+func applySockopts(fd int, network, iface string, so SocketOptions) error {
+	ipv6 := strings.HasSuffix(network, "6")
+	tcp := strings.HasPrefix(network, "tcp")
+	if iface != "" {
+		if err := unix.BindToDevice(fd, iface); err != nil {
+			return fmt.Errorf("bind to device %s: %w", iface, err)
+		}
+	}
+	if so.Mark != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(so.Mark)); err != nil {
+			return fmt.Errorf("set SO_MARK: %w", err)
+		}
+	}
+	if tos := so.tos(); tos != 0 {
+		var err error
+		if ipv6 {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+		}
+		if err != nil {
+			return fmt.Errorf("set traffic class %#x: %w", tos, err)
+		}
+	}
+	if so.TTL != 0 {
+		var err error
+		if ipv6 {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, so.TTL)
+		} else {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, so.TTL)
+		}
+		if err != nil {
+			return fmt.Errorf("set ttl %d: %w", so.TTL, err)
+		}
+	}
+	if so.FastOpen && tcp {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1); err != nil {
+			return fmt.Errorf("set TCP_FASTOPEN_CONNECT: %w", err)
+		}
+	}
+	if so.SendBuffer != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, so.SendBuffer); err != nil {
+			return fmt.Errorf("set SO_SNDBUF: %w", err)
+		}
+	}
+	if so.RecvBuffer != 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, so.RecvBuffer); err != nil {
+			return fmt.Errorf("set SO_RCVBUF: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 选项在 connect 之前写入套接字，可从已建立的连接上读回。
+func TestDirectDialerSocketOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			_, _ = io.Copy(io.Discard, c)
+			c.Close()
+		}
+	}()
+	so := SocketOptions{DSCP: 10, TTL: 7, SendBuffer: 64 << 10}
+	d := NewDirectDialer(DialOptions{Timeout: time.Second, Socket: so})
+	c, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	raw, err := c.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos, ttl, sndbuf int
+	var gerr error
+	if err := raw.Control(func(fd uintptr) {
+		if tos, gerr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS); gerr != nil {
+			return
+		}
+		if ttl, gerr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL); gerr != nil {
+			return
+		}
+		sndbuf, gerr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	// 内核把 SO_SNDBUF 翻倍以容纳簿记开销
+	if tos != so.tos() || ttl != 7 || sndbuf < 64<<10 {
+		t.Fatalf("tos %#x ttl %d sndbuf %d, want tos %#x ttl 7 sndbuf >= %d", tos, ttl, sndbuf, so.tos(), 64<<10)
+	}
+}
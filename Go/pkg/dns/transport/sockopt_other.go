@@ -0,0 +1,18 @@
+//go:build !linux
+
+package transport
+
+import "syscall"
+
+// controlFunc：非 Linux 平台不支持 SO_BINDTODEVICE 与 SocketOptions 中的套接字选项。
+func controlFunc(opts DialOptions) func(network, address string, c syscall.RawConn) error {
+	if opts.Interface == "" && !opts.Socket.needsControl() {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if opts.Interface != "" {
+			return ErrBindToDeviceUnsupported
+		}
+		return ErrSocketOptionsUnsupported
+	}
+}
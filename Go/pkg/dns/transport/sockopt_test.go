@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSocketOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts SocketOptions
+		err  string
+	}{
+		{SocketOptions{}, ""},
+		{SocketOptions{TOS: 0xff, DSCP: 63, TTL: 255, SendBuffer: 1 << 16}, ""},
+		{SocketOptions{TOS: 256}, "tos 256"},
+		{SocketOptions{DSCP: 64}, "dscp 64"},
+		{SocketOptions{TTL: -1}, "ttl -1"},
+		{SocketOptions{RecvBuffer: -1}, "buffer"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%+v: error %v, want %q", tt.opts, err, tt.err)
+		}
+	}
+}
+
+// DSCP 占据高 6 位，保留 TOS 的 ECN 位。
+func TestSocketOptionsTOS(t *testing.T) {
+	tests := []struct {
+		opts SocketOptions
+		want int
+	}{
+		{SocketOptions{}, 0},
+		{SocketOptions{TOS: 0x10}, 0x10},
+		{SocketOptions{DSCP: 46}, 0xb8},
+		{SocketOptions{DSCP: 46, TOS: 0x13}, 0xbb},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.tos(); got != tt.want {
+			t.Errorf("%+v: tos %#x, want %#x", tt.opts, got, tt.want)
+		}
+	}
+}
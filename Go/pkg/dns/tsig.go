@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TsigOptions is a shared TSIG key (RFC 8945).
+type TsigOptions struct {
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`    // base64
+	Algorithm string `json:"algorithm"` // hmac-sha256 (default), hmac-sha1, hmac-sha224, hmac-sha384, hmac-sha512
+}
+
+// TsigStatus reports how the responses of a signed exchange verified.
+type TsigStatus struct {
+	Key       string `json:"key"`
+	Algorithm string `json:"algorithm"`
+	Messages  int    `json:"messages"` // responses received
+	Signed    int    `json:"signed"`   // responses carrying a TSIG that verified
+	// Error is the TSIG error the server returned (e.g. "BADSIG") or why
+	// verifying its response failed.
+	Error string `json:"error,omitempty"`
+}
+
+// tsigKey signs requests and verifies responses, counting successful verifications.
+// It implements dns.TsigProvider and msgSigner.
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+
+	requestMAC string // MAC of the signed request, covered by the response MAC
+
+	mu        sync.Mutex
+	verified  int
+	verifyErr error
+}
+
+func (o *TsigOptions) key() (*tsigKey, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if strings.TrimSpace(o.Name) == "" {
+		return nil, errors.New("tsig: empty key name")
+	}
+	secret, err := base64.StdEncoding.DecodeString(o.Secret)
+	if err != nil || len(secret) == 0 {
+		return nil, errors.New("tsig: secret must be non-empty base64")
+	}
+	alg := dns.HmacSHA256
+	if o.Algorithm != "" {
+		alg = dns.CanonicalName(o.Algorithm)
+	}
+	if tsigHash(alg) == nil {
+		return nil, fmt.Errorf("tsig: unsupported algorithm %q", o.Algorithm)
+	}
+	return &tsigKey{name: dns.CanonicalName(o.Name), algorithm: alg, secret: secret}, nil
+}
+
+func tsigHash(alg string) func() hash.Hash {
+	switch alg {
+	case dns.HmacSHA1:
+		return sha1.New
+	case dns.HmacSHA224:
+		return sha256.New224
+	case dns.HmacSHA256:
+		return sha256.New
+	case dns.HmacSHA384:
+		return sha512.New384
+	case dns.HmacSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// attach adds the TSIG record; the MAC is filled in when the message is written.
+func (k *tsigKey) attach(m *dns.Msg) {
+	m.SetTsig(k.name, k.algorithm, 300, time.Now().Unix())
+}
+
+func (k *tsigKey) sign(m *dns.Msg) ([]byte, error) {
+	k.attach(m)
+	wire, mac, err := dns.TsigGenerateWithProvider(m, k, "", false)
+	if err != nil {
+		return nil, err
+	}
+	k.requestMAC = mac
+	return wire, nil
+}
+
+func (k *tsigKey) check(resp *dns.Msg, wire []byte) {
+	if resp.IsTsig() == nil {
+		return
+	}
+	if err := dns.TsigVerifyWithProvider(wire, k, k.requestMAC, false); err != nil {
+		k.mu.Lock()
+		k.verifyErr = err
+		k.mu.Unlock()
+	}
+}
+
+func (k *tsigKey) report(res *exchangeResult) {
+	st := k.status(1)
+	// Prefer the server's own verdict (BADSIG, BADKEY, BADTIME) over ours.
+	switch t := res.msg.IsTsig(); {
+	case t != nil && t.Error != dns.RcodeSuccess:
+		st.Error = dns.RcodeToString[int(t.Error)]
+	case k.verifyErr != nil:
+		st.Error = k.verifyErr.Error()
+	case st.Signed == 0:
+		st.Error = "response not signed"
+	}
+	res.tsig = st
+}
+
+func (k *tsigKey) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	if dns.CanonicalName(t.Hdr.Name) != k.name {
+		return nil, dns.ErrSecret
+	}
+	newHash := tsigHash(dns.CanonicalName(t.Algorithm))
+	if newHash == nil {
+		return nil, dns.ErrKeyAlg
+	}
+	h := hmac.New(newHash, k.secret)
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+func (k *tsigKey) Verify(msg []byte, t *dns.TSIG) error {
+	want, err := k.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(want, got) {
+		return dns.ErrSig
+	}
+	k.mu.Lock()
+	k.verified++
+	k.mu.Unlock()
+	return nil
+}
+
+// status summarizes verification over messages responses.
+func (k *tsigKey) status(messages int) *TsigStatus {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return &TsigStatus{Key: k.name, Algorithm: k.algorithm, Messages: messages, Signed: k.verified}
+}
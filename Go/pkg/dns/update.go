@@ -0,0 +1,237 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	utils "nettest/pkg/utils"
+
+	"github.com/miekg/dns"
+)
+
+// UpdateOptions describes an RFC 2136 UPDATE. The embedded request options give
+// the server (udp://, tcp:// or tls://, bare "host:port" is UDP), the zone as
+// qname, the TSIG or SIG(0) key, and the dialing settings.
+type UpdateOptions struct {
+	DnsRequestOptions
+	Prerequisites []UpdatePrerequisite `json:"prerequisites"`
+	Updates       []UpdateOperation    `json:"updates"`
+	Insecure      bool                 `json:"insecure"` // tls: skip certificate verification
+}
+
+// UpdatePrerequisite is one RFC 2136 §2.4 condition.
+//   - "exists" with records: the RRset exists with exactly these values
+//   - "exists" with name and type: the RRset exists; with name only: the name is in use
+//   - "not_exists" with name and type: the RRset does not exist; with name only: the name is unused
+type UpdatePrerequisite struct {
+	Condition string   `json:"condition"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Records   []string `json:"records"` // master file lines, e.g. "www 300 IN A 192.0.2.1"
+}
+
+// UpdateOperation is one RFC 2136 §2.5 change.
+//   - "add" inserts records
+//   - "delete" with records removes those records; with name and type the whole
+//     RRset; with name only every RRset at the name
+//   - "replace" deletes the RRsets of records, then inserts records
+type UpdateOperation struct {
+	Action  string   `json:"action"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Records []string `json:"records"`
+}
+
+// parseRecords reads master file lines relative to zone.
+func parseRecords(zone string, lines []string) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(lines))
+	for _, line := range lines {
+		zp := dns.NewZoneParser(strings.NewReader(line), zone, "")
+		rr, ok := zp.Next()
+		if err := zp.Err(); err != nil {
+			return nil, fmt.Errorf("record %q: %w", line, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("record %q: empty", line)
+		}
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			return nil, fmt.Errorf("record %q: outside zone %s", line, zone)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// nameOnly builds the placeholder RR the update helpers use for name (and type)
+// based prerequisites and deletions.
+func nameOnly(zone, name, typ string) (dns.RR, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, errors.New("empty name")
+	}
+	if !dns.IsFqdn(name) {
+		if name == "@" {
+			name = zone
+		} else {
+			name = name + "." + zone
+		}
+	}
+	hdr := dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeANY, Class: dns.ClassINET}
+	if typ != "" {
+		t, ok := dns.StringToType[strings.ToUpper(typ)]
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q", typ)
+		}
+		hdr.Rrtype = t
+	}
+	return &dns.ANY{Hdr: hdr}, nil
+}
+
+// buildUpdate assembles the UPDATE message for opts.
+func (opts UpdateOptions) buildUpdate() (*dns.Msg, error) {
+	if strings.TrimSpace(opts.Qname) == "" {
+		return nil, errors.New("empty zone")
+	}
+	zone := dns.CanonicalName(opts.Qname)
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	for i, p := range opts.Prerequisites {
+		var err error
+		switch strings.ToLower(p.Condition) {
+		case "exists":
+			if len(p.Records) > 0 {
+				var rrs []dns.RR
+				if rrs, err = parseRecords(zone, p.Records); err == nil {
+					m.Used(rrs)
+				}
+				break
+			}
+			var rr dns.RR
+			if rr, err = nameOnly(zone, p.Name, p.Type); err == nil {
+				if p.Type == "" {
+					m.NameUsed([]dns.RR{rr})
+				} else {
+					m.RRsetUsed([]dns.RR{rr})
+				}
+			}
+		case "not_exists":
+			var rr dns.RR
+			if rr, err = nameOnly(zone, p.Name, p.Type); err == nil {
+				if p.Type == "" {
+					m.NameNotUsed([]dns.RR{rr})
+				} else {
+					m.RRsetNotUsed([]dns.RR{rr})
+				}
+			}
+		default:
+			err = fmt.Errorf("invalid condition %q, want exists or not_exists", p.Condition)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("prerequisite %d: %w", i, err)
+		}
+	}
+	for i, op := range opts.Updates {
+		rrs, err := parseRecords(zone, op.Records)
+		if err == nil {
+			switch strings.ToLower(op.Action) {
+			case "add":
+				if len(rrs) == 0 {
+					err = errors.New("no records to add")
+					break
+				}
+				m.Insert(rrs)
+			case "delete":
+				if len(rrs) > 0 {
+					m.Remove(rrs)
+					break
+				}
+				var rr dns.RR
+				if rr, err = nameOnly(zone, op.Name, op.Type); err == nil {
+					if op.Type == "" {
+						m.RemoveName([]dns.RR{rr})
+					} else {
+						m.RemoveRRset([]dns.RR{rr})
+					}
+				}
+			case "replace":
+				if len(rrs) == 0 {
+					err = errors.New("no records to replace with")
+					break
+				}
+				m.RemoveRRset(rrs)
+				m.Insert(rrs)
+			default:
+				err = fmt.Errorf("invalid action %q, want add, delete or replace", op.Action)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("update %d: %w", i, err)
+		}
+	}
+	if len(m.Ns) == 0 {
+		return nil, errors.New("no updates")
+	}
+	return m, nil
+}
+
+// DnsUpdateJson sends a dynamic update and reports the server's rcode and,
+// when signed, how the response's TSIG verified. A refused update is a result,
+// not an error: check "rcode".
+// Fields: server, qname (zone), prerequisites [{condition, name, type, records}],
+// updates [{action, name, type, records}], tsig {name, secret, algorithm} or
+// sig0 {key, private_key, server_key}, insecure,
+// plus the dialing fields of DnsRequestJson.
+// Example: {"server":"udp://192.0.2.53","qname":"example.com","updates":[{"action":"replace","records":["www 300 IN A 192.0.2.10"]}],"tsig":{"name":"ddns-key","secret":"c2VjcmV0","algorithm":"hmac-sha512"}}
+// Example: {"server":"tcp://192.0.2.53","qname":"example.com","prerequisites":[{"condition":"not_exists","name":"host1"}],"updates":[{"action":"add","records":["host1 60 IN A 192.0.2.20","host1 60 IN TXT \"owner=ci\""]}]}
+// Example: {"server":"192.0.2.53:53","qname":"example.com","updates":[{"action":"delete","name":"host1","type":"TXT"}]}
+func DnsUpdateJson(jsonStr string) string {
+	var in UpdateOptions
+	if err := json.Unmarshal([]byte(jsonStr), &in); err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	m, err := in.buildUpdate()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	req := in.request()
+	switch req.net {
+	case "udp", "tcp", "tcp-tls":
+	default:
+		return utils.BuildErrJSON(errors.New("updates need udp, tcp or tls"))
+	}
+	if in.Tsig != nil && in.Sig0 != nil {
+		return utils.BuildErrJSON(errors.New("tsig and sig0 are mutually exclusive"))
+	}
+	tsig, err := in.Tsig.key()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	sig0, err := in.Sig0.key()
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	res, err := req.exchangeSigned(m, signerOf(tsig, sig0), in.Insecure)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	data := map[string]interface{}{
+		"zone":          m.Question[0].Name,
+		"rcode":         dns.RcodeToString[res.msg.Rcode],
+		"rcode_value":   res.msg.Rcode,
+		"rtt":           res.rtt,
+		"prerequisites": len(m.Answer),
+		"updates":       len(m.Ns),
+	}
+	if res.tsig != nil {
+		data["tsig"] = res.tsig
+	}
+	if res.sig0 != nil {
+		data["sig0"] = res.sig0
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return utils.BuildErrJSON(err)
+	}
+	return string(jsonData)
+}
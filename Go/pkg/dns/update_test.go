@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"nettest/pkg/dns/dnstest"
+
+	"github.com/miekg/dns"
+)
+
+// section renders rrs as "name class type ttl [rdata]" for comparison.
+func section(rrs []dns.RR) []string {
+	out := make([]string, len(rrs))
+	for i, rr := range rrs {
+		h := rr.Header()
+		out[i] = fmt.Sprintf("%s %s %s %d", h.Name, dns.ClassToString[h.Class], dns.TypeToString[h.Rrtype], h.Ttl)
+		if data := strings.TrimPrefix(rr.String(), h.String()); data != "" {
+			out[i] += " " + data
+		}
+	}
+	return out
+}
+
+func TestBuildUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prereqs []UpdatePrerequisite
+		updates []UpdateOperation
+		prereq  []string // RFC 2136 §2.4
+		update  []string // RFC 2136 §2.5
+		err     string
+	}{
+		{
+			name:    "add",
+			updates: []UpdateOperation{{Action: "add", Records: []string{"www 300 IN A 192.0.2.1", "www.example.test. 60 TXT x"}}},
+			update:  []string{"www.example.test. IN A 300 192.0.2.1", `www.example.test. IN TXT 60 "x"`},
+		},
+		{
+			name:    "replace",
+			updates: []UpdateOperation{{Action: "replace", Records: []string{"@ 300 IN A 192.0.2.9"}}},
+			update:  []string{"example.test. ANY A 0", "example.test. IN A 300 192.0.2.9"},
+		},
+		{
+			name: "delete",
+			updates: []UpdateOperation{
+				{Action: "delete", Records: []string{"www 300 IN A 192.0.2.1"}},
+				{Action: "delete", Name: "www", Type: "txt"},
+				{Action: "delete", Name: "old.example.test."},
+			},
+			update: []string{"www.example.test. NONE A 0 192.0.2.1", "www.example.test. ANY TXT 0", "old.example.test. ANY ANY 0"},
+		},
+		{
+			name: "prerequisites",
+			prereqs: []UpdatePrerequisite{
+				{Condition: "exists", Records: []string{"www 300 IN A 192.0.2.1"}},
+				{Condition: "exists", Name: "www", Type: "A"},
+				{Condition: "exists", Name: "@"},
+				{Condition: "not_exists", Name: "new", Type: "AAAA"},
+				{Condition: "NOT_EXISTS", Name: "new"},
+			},
+			updates: []UpdateOperation{{Action: "add", Records: []string{"new 60 IN A 192.0.2.2"}}},
+			prereq: []string{
+				"www.example.test. IN A 0 192.0.2.1",
+				"www.example.test. ANY A 0",
+				"example.test. ANY ANY 0",
+				"new.example.test. NONE AAAA 0",
+				"new.example.test. NONE ANY 0",
+			},
+			update: []string{"new.example.test. IN A 60 192.0.2.2"},
+		},
+		{name: "no updates", prereqs: []UpdatePrerequisite{{Condition: "exists", Name: "www"}}, err: "no updates"},
+		{name: "bad action", updates: []UpdateOperation{{Action: "upsert", Records: []string{"www 60 A 192.0.2.1"}}}, err: "update 0: invalid action"},
+		{name: "add nothing", updates: []UpdateOperation{{Action: "add"}}, err: "update 0: no records to add"},
+		{name: "out of zone", updates: []UpdateOperation{{Action: "add", Records: []string{"www.example.org. 60 A 192.0.2.1"}}}, err: "outside zone"},
+		{name: "bad record", updates: []UpdateOperation{{Action: "add", Records: []string{"www 60 A nope"}}}, err: "update 0: record"},
+		{name: "bad type", updates: []UpdateOperation{{Action: "delete", Name: "www", Type: "NOPE"}}, err: `invalid type "NOPE"`},
+		{name: "delete nothing", updates: []UpdateOperation{{Action: "delete"}}, err: "empty name"},
+		{name: "bad condition", prereqs: []UpdatePrerequisite{{Condition: "maybe", Name: "www"}}, err: "prerequisite 0: invalid condition"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := UpdateOptions{DnsRequestOptions: DnsRequestOptions{Qname: "Example.Test"}, Prerequisites: tt.prereqs, Updates: tt.updates}
+			m, err := opts.buildUpdate()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Opcode != dns.OpcodeUpdate || len(m.Question) != 1 || m.Question[0].Name != "example.test." || m.Question[0].Qtype != dns.TypeSOA {
+				t.Fatalf("zone section %v, opcode %d", m.Question, m.Opcode)
+			}
+			if got := section(m.Answer); strings.Join(got, "\n") != strings.Join(tt.prereq, "\n") {
+				t.Errorf("prerequisites\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.prereq, "\n"))
+			}
+			if got := section(m.Ns); strings.Join(got, "\n") != strings.Join(tt.update, "\n") {
+				t.Errorf("updates\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.update, "\n"))
+			}
+		})
+	}
+}
+
+// The update reaches the server as built and signed, and the server's rcode
+// comes back as a result.
+func TestDnsUpdateJson(t *testing.T) {
+	s, urls := listenAll(t)
+	s.SetSigner(dnstest.TSIG{Name: "ddns.example.", Secret: testTsigSecret})
+	var (
+		mu   sync.Mutex
+		seen *dns.Msg
+	)
+	s.SetScript(func(q dnstest.Query) dnstest.Fault {
+		if q.Msg == nil {
+			return dnstest.Fault{}
+		}
+		mu.Lock()
+		seen = q.Msg
+		mu.Unlock()
+		return dnstest.Fault{Rcode: dns.RcodeRefused}
+	})
+	opts := UpdateOptions{
+		DnsRequestOptions: DnsRequestOptions{Qname: "example.test", TimeoutMs: 2000,
+			Tsig: &TsigOptions{Name: "ddns.example", Secret: testTsigSecret}},
+		Prerequisites: []UpdatePrerequisite{{Condition: "not_exists", Name: "host1"}},
+		Updates:       []UpdateOperation{{Action: "add", Records: []string{"host1 60 IN A 192.0.2.20"}}},
+	}
+	want, err := opts.buildUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scheme := range []string{"udp", "tcp", "tls"} {
+		t.Run(scheme, func(t *testing.T) {
+			opts.Server = urls[scheme]
+			b, _ := json.Marshal(opts)
+			var out struct {
+				Rcode   string      `json:"rcode"`
+				Prereqs int         `json:"prerequisites"`
+				Updates int         `json:"updates"`
+				Tsig    *TsigStatus `json:"tsig"`
+			}
+			if err := json.Unmarshal([]byte(DnsUpdateJson(string(b))), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Rcode != "REFUSED" || out.Prereqs != 1 || out.Updates != 1 {
+				t.Fatalf("result %+v", out)
+			}
+			if out.Tsig == nil || out.Tsig.Signed != 1 || out.Tsig.Error != "" {
+				t.Fatalf("tsig %+v", out.Tsig)
+			}
+			mu.Lock()
+			got := seen
+			mu.Unlock()
+			if got == nil || got.Opcode != dns.OpcodeUpdate || got.IsTsig() == nil {
+				t.Fatalf("server received %v", got)
+			}
+			if a, b := section(got.Answer), section(want.Answer); strings.Join(a, "\n") != strings.Join(b, "\n") {
+				t.Fatalf("prerequisites %q, want %q", a, b)
+			}
+			if a, b := section(got.Ns), section(want.Ns); strings.Join(a, "\n") != strings.Join(b, "\n") {
+				t.Fatalf("updates %q, want %q", a, b)
+			}
+		})
+	}
+}